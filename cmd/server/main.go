@@ -96,7 +96,10 @@ func main() {
 	redisRepo := repository.NewRedisRepo(rdb)
 
 	// VIPS
-	vipsProcessor, err := vipsproc.NewProcessor()
+	vipsProcessor, err := vipsproc.NewProcessor(vipsproc.Limits{
+		MaxPixels:    cfg.ImageMaxPixels,
+		MaxDimension: cfg.ImageMaxSourceDimension,
+	}, cfg.ImageAutoQualitySSIM)
 	if err != nil {
 		log.Fatalf("failed to init vips processor: %v", err)
 	}
@@ -110,11 +113,26 @@ func main() {
 		minioRepo,
 		redisRepo,
 		photoRepo,
+		cfg.ImageMediumSize,
+		cfg.DuplicateMaxDistance,
 	)
 	if err != nil {
 		log.Fatalf("failed to create image processor: %v", err)
 	}
 	defer imageProcessor.Shutdown()
+	// заглушки и палитра для фото, загруженных до их появления, и индекс цветов
+	imageProcessor.BackfillPlaceholders()
+	imageProcessor.BackfillColorIndex()
+
+	// Trending-рейтинг (фоновый пересчёт в Redis)
+	trendingRanker := usecase.NewTrendingRanker(photoRepo, redisRepo, time.Duration(cfg.TrendingRefreshSec)*time.Second)
+	trendingRanker.Start()
+	defer trendingRanker.Shutdown()
+
+	// Статистика просмотров (сброс из Redis в Postgres)
+	viewStatsFlusher := usecase.NewViewStatsFlusher(photoRepo, redisRepo, time.Duration(cfg.ViewFlushSec)*time.Second)
+	viewStatsFlusher.Start()
+	defer viewStatsFlusher.Shutdown()
 
 	// JWT
 	jwtManager := auth.NewJWTManager(cfg.JWTSecret)
@@ -2,11 +2,15 @@
 package config
 
 import (
+	"fmt"
 	"os"
+	"sort"
 	"strconv"
 	"strings"
 
 	"github.com/joho/godotenv"
+
+	"github.com/freshtea599/PhotoHubServer.git/pkg/transform"
 )
 
 type Config struct {
@@ -40,13 +44,111 @@ type Config struct {
 	// Prometheus
 	PrometheusPort int // PROMETHEUS_PORT
 
+	// Trending
+	TrendingRefreshSec int // TRENDING_REFRESH_SEC (период пересчёта рейтинга, по умолчанию 300)
+
+	// Статистика просмотров
+	ViewFlushSec int // VIEW_FLUSH_SEC (период сброса счётчиков из Redis в Postgres, по умолчанию 60)
+
 	// Параметры изображений (оставлены для гибкости)
 	ImageThumbSize  int    // IMAGE_THUMB_SIZE (по умолчанию 300)
 	ImageSmallSize  int    // IMAGE_SMALL_SIZE (480)
 	ImageMediumSize int    // IMAGE_MEDIUM_SIZE (768)
 	ImageLargeSize  int    // IMAGE_LARGE_SIZE (1200)
-	ImageQuality    int    // IMAGE_QUALITY (80)
+	ImageQuality    int    // IMAGE_QUALITY (80; auto – подбирать по SSIM, см. transform.QualityAuto)
 	ImageLibrary    string // IMAGE_LIBRARY (было bimg/imaging, теперь govips)
+
+	// Настройки кодировщиков по умолчанию (см. transform.ParseEncoding),
+	// например "progressive,subsample=444". Пресеты задают свои.
+	ImageEncoding        transform.Encoding // IMAGE_ENCODING (по умолчанию пусто – умолчания libvips)
+	ImageMaxEffort       int                // IMAGE_MAX_EFFORT (наибольший effort в произвольных запросах, по умолчанию 6)
+	ImageLosslessMaxSize int                // IMAGE_LOSSLESS_MAX_SIZE (наибольшая сторона lossless и near_lossless в произвольных запросах, по умолчанию 4096)
+
+	// Именованные пресеты трансформаций: встроенные (на основе размеров выше)
+	// плюс переопределения из IMAGE_PRESETS
+	ImagePresets     map[string]ImagePreset
+	ImagePresetsOnly bool // IMAGE_PRESETS_ONLY (запрещает произвольные трансформации вне пресетов)
+
+	// Предгенерация вариантов после загрузки (очередь низкого приоритета)
+	ImageEagerPresets []string // IMAGE_EAGER_PRESETS (по умолчанию thumb,small,medium,large)
+	ImageEagerFormats []string // IMAGE_EAGER_FORMATS (по умолчанию webp,avif,jpeg)
+
+	// Ширины (breakpoints) для srcset
+	ImageSrcsetWidths []int // IMAGE_SRCSET_WIDTHS (по умолчанию 320,480,640,768,1024,1280,1600,1920,2560)
+
+	// Выбор формата по заголовку Accept
+	ImageFormatPreference []string       // IMAGE_FORMAT_PREFERENCE (порядок предпочтения при равных q, по умолчанию avif,webp,jpeg)
+	ImageFormatMaxPixels  map[string]int // IMAGE_FORMAT_MAX_PIXELS (лимит площади результата для формата, по умолчанию avif=4000000)
+
+	// Защита от decompression bomb: лимиты исходного изображения
+	ImageMaxPixels          int // IMAGE_MAX_MEGAPIXELS (в мегапикселях, для анимации – сумма по кадрам; по умолчанию 100)
+	ImageMaxSourceDimension int // IMAGE_MAX_SOURCE_DIMENSION (максимальная сторона, по умолчанию 16384)
+
+	// Целевой SSIM для q=auto: выбирается наименьшее качество, не опускающее SSIM ниже
+	ImageAutoQualitySSIM float64 // IMAGE_AUTO_QUALITY_SSIM (по умолчанию 0.985)
+
+	// Водяные знаки накладываются на варианты не уже этой ширины
+	WatermarkMinWidth int // WATERMARK_MIN_WIDTH (по умолчанию 400)
+
+	// Почти-дубликаты: наибольшее расстояние Хэмминга между перцептивными
+	// хэшами (из 64 бит), при котором фото считаются одним снимком
+	DuplicateMaxDistance int // DUPLICATE_MAX_DISTANCE (по умолчанию 8)
+}
+
+// EagerVariants возвращает параметры вариантов, которые генерируются сразу
+// после загрузки: каждый пресет из ImageEagerPresets во всех ImageEagerFormats
+// (или только в своём формате, если он у пресета фиксирован). edit – текущая
+// правка фото (nil – без правок).
+func (c *Config) EagerVariants(edit *transform.Edit) []transform.Options {
+	var variants []transform.Options
+	for _, name := range c.ImageEagerPresets {
+		p, ok := c.ImagePresets[name]
+		if !ok {
+			continue
+		}
+		formats := c.ImageEagerFormats
+		if p.Format != FormatAuto {
+			formats = []string{p.Format}
+		}
+		for _, format := range formats {
+			opts := p.Options(format)
+			opts.Edit = edit
+			variants = append(variants, opts)
+		}
+	}
+	return variants
+}
+
+// ImagePreset – именованный набор параметров трансформации.
+type ImagePreset struct {
+	Name     string
+	Width    int
+	Height   int
+	Fit      transform.Fit
+	Gravity  transform.Gravity
+	Format   string // "auto" – по заголовку Accept, иначе фиксированный формат
+	Quality  int
+	Frame    int                // кадр анимации для статичного постера (0 – сохранить анимацию)
+	Encoding transform.Encoding // настройки кодировщиков
+}
+
+// FormatAuto – формат пресета выбирается по заголовку Accept.
+const FormatAuto = "auto"
+
+// Options возвращает параметры трансформации пресета для заданного формата.
+func (p ImagePreset) Options(format string) transform.Options {
+	opts := transform.Options{
+		Width:    p.Width,
+		Height:   p.Height,
+		Fit:      p.Fit,
+		Gravity:  p.Gravity,
+		Format:   format,
+		Quality:  p.Quality,
+		Frame:    p.Frame,
+		Encoding: p.Encoding,
+	}
+	opts.Normalize()
+	return opts
 }
 
 func Load() (*Config, error) {
@@ -65,6 +167,73 @@ func Load() (*Config, error) {
 	redisDB, _ := strconv.Atoi(getEnv("REDIS_DB", "0"))
 
 	workerCount, _ := strconv.Atoi(getEnv("WORKER_COUNT", "4"))
+	thumbSize := getEnvInt("IMAGE_THUMB_SIZE", 300)
+	smallSize := getEnvInt("IMAGE_SMALL_SIZE", 480)
+	mediumSize := getEnvInt("IMAGE_MEDIUM_SIZE", 768)
+	largeSize := getEnvInt("IMAGE_LARGE_SIZE", 1200)
+	imageQuality, err := transform.ParseQuality(getEnv("IMAGE_QUALITY", "80"))
+	if err != nil || (transform.Options{Quality: imageQuality}).Validate() != nil {
+		return nil, fmt.Errorf("IMAGE_QUALITY: must be between 1 and 100 or auto")
+	}
+	autoQualitySSIM, err := strconv.ParseFloat(getEnv("IMAGE_AUTO_QUALITY_SSIM", "0.985"), 64)
+	if err != nil || autoQualitySSIM <= 0 || autoQualitySSIM >= 1 {
+		return nil, fmt.Errorf("IMAGE_AUTO_QUALITY_SSIM: must be between 0 and 1")
+	}
+
+	duplicateMaxDistance := getEnvInt("DUPLICATE_MAX_DISTANCE", 8)
+	if duplicateMaxDistance < 0 || duplicateMaxDistance > 32 {
+		return nil, fmt.Errorf("DUPLICATE_MAX_DISTANCE: must be between 0 and 32")
+	}
+
+	imageEncoding, err := transform.ParseEncoding(getEnv("IMAGE_ENCODING", ""))
+	if err == nil {
+		err = (transform.Options{Encoding: imageEncoding}).Validate()
+	}
+	if err != nil {
+		return nil, fmt.Errorf("IMAGE_ENCODING: %w", err)
+	}
+	imageEncoding.Normalize()
+	// умолчание действует и для произвольных запросов, поэтому подчиняется их пределу
+	imageMaxEffort := getEnvInt("IMAGE_MAX_EFFORT", 6)
+	if imageEncoding.Effort > imageMaxEffort {
+		return nil, fmt.Errorf("IMAGE_ENCODING: effort must not exceed IMAGE_MAX_EFFORT (%d)", imageMaxEffort)
+	}
+
+	presets := defaultPresets(thumbSize, smallSize, mediumSize, largeSize, imageQuality, imageEncoding)
+	if err := parsePresets(getEnv("IMAGE_PRESETS", ""), imageQuality, imageEncoding, presets); err != nil {
+		return nil, err
+	}
+	eagerPresets := splitList(getEnv("IMAGE_EAGER_PRESETS", "thumb,small,medium,large"))
+	for _, name := range eagerPresets {
+		if _, ok := presets[name]; !ok {
+			return nil, fmt.Errorf("IMAGE_EAGER_PRESETS: unknown preset %q", name)
+		}
+	}
+	eagerFormats := splitList(getEnv("IMAGE_EAGER_FORMATS", "webp,avif,jpeg"))
+	for _, format := range eagerFormats {
+		if !transform.IsOutputFormat(format) {
+			return nil, fmt.Errorf("IMAGE_EAGER_FORMATS: unsupported format %q", format)
+		}
+	}
+	formatPreference := splitList(getEnv("IMAGE_FORMAT_PREFERENCE", "avif,webp,jpeg"))
+	for _, format := range formatPreference {
+		if !transform.IsOutputFormat(format) {
+			return nil, fmt.Errorf("IMAGE_FORMAT_PREFERENCE: unsupported format %q", format)
+		}
+	}
+	formatMaxPixels, err := parseFormatLimits(getEnv("IMAGE_FORMAT_MAX_PIXELS", "avif=4000000"))
+	if err != nil {
+		return nil, err
+	}
+	var srcsetWidths []int
+	for _, item := range splitList(getEnv("IMAGE_SRCSET_WIDTHS", "320,480,640,768,1024,1280,1600,1920,2560")) {
+		w, err := strconv.Atoi(item)
+		if err != nil || w <= 0 || w > transform.MaxDimension {
+			return nil, fmt.Errorf("IMAGE_SRCSET_WIDTHS: invalid width %q", item)
+		}
+		srcsetWidths = append(srcsetWidths, w)
+	}
+	sort.Ints(srcsetWidths)
 	prometheusPort, _ := strconv.Atoi(getEnv("PROMETHEUS_PORT", "9091"))
 
 	return &Config{
@@ -93,15 +262,164 @@ func Load() (*Config, error) {
 		WorkerCount:    workerCount,
 		PrometheusPort: prometheusPort,
 
-		ImageThumbSize:  getEnvInt("IMAGE_THUMB_SIZE", 300),
-		ImageSmallSize:  getEnvInt("IMAGE_SMALL_SIZE", 480),
-		ImageMediumSize: getEnvInt("IMAGE_MEDIUM_SIZE", 768),
-		ImageLargeSize:  getEnvInt("IMAGE_LARGE_SIZE", 1200),
-		ImageQuality:    getEnvInt("IMAGE_QUALITY", 80),
+		TrendingRefreshSec: getEnvInt("TRENDING_REFRESH_SEC", 300),
+		ViewFlushSec:       getEnvInt("VIEW_FLUSH_SEC", 60),
+
+		ImageThumbSize:  thumbSize,
+		ImageSmallSize:  smallSize,
+		ImageMediumSize: mediumSize,
+		ImageLargeSize:  largeSize,
+		ImageQuality:    imageQuality,
+		ImageEncoding:   imageEncoding,
 		ImageLibrary:    "govips",
+
+		ImageMaxEffort:       imageMaxEffort,
+		ImageLosslessMaxSize: getEnvInt("IMAGE_LOSSLESS_MAX_SIZE", 4096),
+
+		ImagePresets:     presets,
+		ImagePresetsOnly: getEnvBool("IMAGE_PRESETS_ONLY", false),
+
+		ImageEagerPresets: eagerPresets,
+		ImageEagerFormats: eagerFormats,
+		ImageSrcsetWidths: srcsetWidths,
+
+		ImageFormatPreference: formatPreference,
+		ImageFormatMaxPixels:  formatMaxPixels,
+
+		ImageMaxPixels:          getEnvInt("IMAGE_MAX_MEGAPIXELS", 100) * 1_000_000,
+		ImageMaxSourceDimension: getEnvInt("IMAGE_MAX_SOURCE_DIMENSION", 16384),
+
+		ImageAutoQualitySSIM: autoQualitySSIM,
+
+		WatermarkMinWidth: getEnvInt("WATERMARK_MIN_WIDTH", 400),
+
+		DuplicateMaxDistance: duplicateMaxDistance,
 	}, nil
 }
 
+// defaultPresets строит встроенные пресеты из размеров IMAGE_*_SIZE.
+func defaultPresets(thumb, small, medium, large, quality int, enc transform.Encoding) map[string]ImagePreset {
+	presets := []ImagePreset{
+		// миниатюры в сетке – статичный постер из первого кадра даже у анимаций
+		{Name: "thumb", Width: thumb, Height: thumb, Fit: transform.FitCover, Gravity: transform.GravitySmart, Format: FormatAuto, Quality: quality, Frame: 1},
+		{Name: "small", Width: small, Format: FormatAuto, Quality: quality},
+		{Name: "medium", Width: medium, Format: FormatAuto, Quality: quality},
+		{Name: "large", Width: large, Format: FormatAuto, Quality: quality},
+		{Name: "avatar", Width: 128, Height: 128, Fit: transform.FitCover, Gravity: transform.GravitySmart, Format: FormatAuto, Quality: quality, Frame: 1},
+		// соцсети не везде понимают webp/avif, поэтому превью для Open Graph всегда в jpeg
+		{Name: "og-card", Width: 1200, Height: 630, Fit: transform.FitCover, Gravity: transform.GravitySmart, Format: "jpeg", Quality: quality},
+	}
+	for i := range presets {
+		presets[i].Encoding = enc
+	}
+	m := make(map[string]ImagePreset, len(presets))
+	for _, p := range presets {
+		m[p.Name] = p
+	}
+	return m
+}
+
+// parsePresets добавляет или переопределяет пресеты из строки вида
+// "name=WIDTHxHEIGHT[,fit[,format[,quality[,gravity[,frame[,encoding]]]]]];..."
+// (например, "banner=1600x400,cover,auto,85;square=600x600,cover,webp").
+// frame > 0 делает из анимации статичный постер с этим кадром.
+// encoding – настройки кодировщика через «+» (см. transform.ParseEncoding),
+// например "progressive+trellis+effort=6"; без него – IMAGE_ENCODING.
+// Размер 0 означает «не задан»: "wide=1920x0" масштабирует только по ширине.
+func parsePresets(spec string, defaultQuality int, defaultEncoding transform.Encoding, presets map[string]ImagePreset) error {
+	for _, entry := range strings.Split(spec, ";") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		name, rest, ok := strings.Cut(entry, "=")
+		name = strings.TrimSpace(name)
+		if !ok || name == "" {
+			return fmt.Errorf("IMAGE_PRESETS: invalid entry %q", entry)
+		}
+		fields := strings.Split(rest, ",")
+		p := ImagePreset{Name: name, Format: FormatAuto, Quality: defaultQuality, Encoding: defaultEncoding}
+
+		ws, hs, ok := strings.Cut(strings.TrimSpace(fields[0]), "x")
+		var errW, errH error
+		p.Width, errW = strconv.Atoi(ws)
+		p.Height, errH = strconv.Atoi(hs)
+		if !ok || errW != nil || errH != nil {
+			return fmt.Errorf("IMAGE_PRESETS: invalid size in %q", entry)
+		}
+		if len(fields) > 1 && fields[1] != "" {
+			p.Fit = transform.Fit(strings.TrimSpace(fields[1]))
+		}
+		if len(fields) > 2 && fields[2] != "" {
+			p.Format = strings.TrimSpace(fields[2])
+		}
+		if len(fields) > 3 && fields[3] != "" {
+			q, err := transform.ParseQuality(fields[3])
+			if err != nil {
+				return fmt.Errorf("IMAGE_PRESETS: invalid quality in %q", entry)
+			}
+			p.Quality = q
+		}
+		if len(fields) > 4 && fields[4] != "" {
+			p.Gravity = transform.Gravity(strings.TrimSpace(fields[4]))
+		}
+		if len(fields) > 5 && fields[5] != "" {
+			f, err := strconv.Atoi(strings.TrimSpace(fields[5]))
+			if err != nil {
+				return fmt.Errorf("IMAGE_PRESETS: invalid frame in %q", entry)
+			}
+			p.Frame = f
+		}
+		if len(fields) > 6 && fields[6] != "" {
+			enc, err := transform.ParseEncoding(fields[6])
+			if err != nil {
+				return fmt.Errorf("IMAGE_PRESETS: preset %q: %w", name, err)
+			}
+			p.Encoding = enc
+		}
+
+		if p.Format != FormatAuto && !transform.IsOutputFormat(p.Format) {
+			return fmt.Errorf("IMAGE_PRESETS: invalid format in %q", entry)
+		}
+		if p.Width == 0 && p.Height == 0 {
+			return fmt.Errorf("IMAGE_PRESETS: preset %q must set width or height", name)
+		}
+		if err := p.Options("jpeg").Validate(); err != nil {
+			return fmt.Errorf("IMAGE_PRESETS: preset %q: %w", name, err)
+		}
+		presets[name] = p
+	}
+	return nil
+}
+
+// parseFormatLimits разбирает лимиты площади вида "avif=4000000,webp=16000000".
+// Кодирование некоторых форматов (прежде всего AVIF) дорого на больших
+// изображениях – выше лимита согласование выбирает следующий формат.
+func parseFormatLimits(spec string) (map[string]int, error) {
+	limits := make(map[string]int)
+	for _, item := range splitList(spec) {
+		format, val, ok := strings.Cut(item, "=")
+		format = strings.TrimSpace(format)
+		n, err := strconv.Atoi(strings.TrimSpace(val))
+		if !ok || err != nil || n <= 0 || !transform.IsOutputFormat(format) {
+			return nil, fmt.Errorf("IMAGE_FORMAT_MAX_PIXELS: invalid entry %q", item)
+		}
+		limits[format] = n
+	}
+	return limits, nil
+}
+
+// splitList разбирает список через запятую, пропуская пустые элементы.
+func splitList(val string) []string {
+	var items []string
+	for _, item := range strings.Split(val, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
 func getEnv(key, defaultVal string) string {
 	if val, ok := os.LookupEnv(key); ok {
 		return val
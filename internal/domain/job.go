@@ -1,7 +1,12 @@
 package domain
 
 import (
+	"sync/atomic"
+
 	"github.com/google/uuid"
+
+	"github.com/freshtea599/PhotoHubServer.git/pkg/phash"
+	"github.com/freshtea599/PhotoHubServer.git/pkg/transform"
 )
 
 type JobPriority int
@@ -12,19 +17,44 @@ const (
 	PriorityLow    JobPriority = 2
 )
 
+// JobKind – тип задачи пула обработки.
+type JobKind int
+
+const (
+	JobTransform JobKind = iota // построить вариант по Options
+	JobAnalyze                  // определить размеры, кадры и палитру, построить превью не больше Options.Width
+)
+
 type Job struct {
-	ID         uuid.UUID      `json:"id"`
-	PhotoID    int64          `json:"photo_id"`
-	Width      int            `json:"width"`
-	Format     string         `json:"format"`
-	Quality    int            `json:"quality"`
-	Priority   JobPriority    `json:"priority"`
-	CreatedAt  int64          `json:"created_at"`
-	ResultChan chan JobResult `json:"-"` // канал для возврата результата
+	ID      uuid.UUID `json:"id"`
+	Kind    JobKind   `json:"kind"`
+	PhotoID int64     `json:"photo_id"`
+	// EditVersion – версия правки, по которой строится результат JobAnalyze.
+	EditVersion int               `json:"edit_version"`
+	Options     transform.Options `json:"options"`
+	Priority    JobPriority       `json:"priority"`
+	CreatedAt   int64             `json:"created_at"`
+	ResultChan  chan JobResult    `json:"-"` // канал для возврата результата
+	// Boost закрывается, когда результата начинает ждать клиент: задача,
+	// ещё стоящая в очереди, поднимается до высокого приоритета.
+	Boost <-chan struct{} `json:"-"`
+	// Claimed отмечает, что задачу взял воркер: после Boost в очередях
+	// оказываются две копии задачи, и выполняется только одна из них.
+	Claimed *atomic.Bool `json:"-"`
 }
 
 type JobResult struct {
-	Job  Job
-	Data []byte
-	Err  error
+	Job          Job
+	Data         []byte
+	Width        int // фактические размеры результата
+	Height       int
+	Quality      int // качество результата (для q=auto – подобранное)
+	SourceWidth  int // размеры оригинала
+	SourceHeight int
+	// число кадров и длительность анимации оригинала, палитра превью (только для JobAnalyze)
+	SourceFrames     int
+	SourceDurationMs int
+	Palette          []string
+	PHash            *phash.Hash // перцептивный хэш оригинала (JobAnalyze; nil – уже был посчитан)
+	Err              error
 }
@@ -0,0 +1,87 @@
+package domain
+
+import (
+	"encoding/base64"
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ErrInvalidCursor возвращается, если курсор пагинации повреждён или подделан.
+var ErrInvalidCursor = errors.New("invalid cursor")
+
+// Cursor – позиция в списке, упорядоченном по (created_at, id).
+// Для списков с другой сортировкой Sort хранит её имя, а Value – значение
+// ключа сортировки (например, likes_count) у последнего элемента.
+// Клиенту отдаётся только в виде непрозрачной строки.
+type Cursor struct {
+	Sort      string
+	Value     int64
+	CreatedAt time.Time
+	ID        int64
+}
+
+// Page – конверт для постраничных ответов.
+// NextCursor пуст, если следующей страницы нет.
+type Page[T any] struct {
+	Items      []T    `json:"items"`
+	NextCursor string `json:"next_cursor,omitempty"`
+}
+
+// Encode сериализует курсор в непрозрачный токен.
+func (c Cursor) Encode() string {
+	raw := strconv.FormatInt(c.CreatedAt.UnixMicro(), 10) + ":" + strconv.FormatInt(c.ID, 10)
+	if c.Sort != "" {
+		raw += ":" + c.Sort + ":" + strconv.FormatInt(c.Value, 10)
+	}
+	return base64.RawURLEncoding.EncodeToString([]byte(raw))
+}
+
+// DecodeCursor разбирает токен, полученный от клиента.
+// Пустая строка означает первую страницу и возвращает nil.
+func DecodeCursor(token string) (*Cursor, error) {
+	if token == "" {
+		return nil, nil
+	}
+	raw, err := base64.RawURLEncoding.DecodeString(token)
+	if err != nil {
+		return nil, ErrInvalidCursor
+	}
+	parts := strings.Split(string(raw), ":")
+	if len(parts) != 2 && len(parts) != 4 {
+		return nil, ErrInvalidCursor
+	}
+	micros, err := strconv.ParseInt(parts[0], 10, 64)
+	if err != nil {
+		return nil, ErrInvalidCursor
+	}
+	rowID, err := strconv.ParseInt(parts[1], 10, 64)
+	if err != nil || rowID <= 0 {
+		return nil, ErrInvalidCursor
+	}
+	cursor := &Cursor{CreatedAt: time.UnixMicro(micros).UTC(), ID: rowID}
+	if len(parts) == 4 {
+		value, err := strconv.ParseInt(parts[3], 10, 64)
+		if err != nil || parts[2] == "" {
+			return nil, ErrInvalidCursor
+		}
+		cursor.Sort, cursor.Value = parts[2], value
+	}
+	return cursor, nil
+}
+
+// NewPage обрезает выборку до limit элементов и, если строк было больше,
+// формирует курсор по последнему элементу страницы.
+// Репозиторий должен запрашивать limit+1 строк.
+func NewPage[T any](items []T, limit int, cursorOf func(T) Cursor) Page[T] {
+	page := Page[T]{Items: items}
+	if page.Items == nil {
+		page.Items = []T{}
+	}
+	if len(items) > limit {
+		page.Items = items[:limit]
+		page.NextCursor = cursorOf(page.Items[limit-1]).Encode()
+	}
+	return page
+}
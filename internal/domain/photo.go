@@ -3,6 +3,9 @@ package domain
 import (
 	"database/sql"
 	"time"
+
+	"github.com/freshtea599/PhotoHubServer.git/pkg/phash"
+	"github.com/freshtea599/PhotoHubServer.git/pkg/transform"
 )
 
 type Photo struct {
@@ -16,17 +19,94 @@ type Photo struct {
 	IsPublic      bool          `json:"is_public"`
 	LikesCount    int           `json:"likes_count"`
 	CommentsCount int           `json:"comments_count"`
+	ViewsCount    int64         `json:"views_count"`
 	// Новые поля для методики
 	BlurHash    string `json:"blurhash"`
 	ContentHash string `json:"content_hash"`
 	Width       int    `json:"width"`
 	Height      int    `json:"height"`
+	// Заглушки и цвета для показа до загрузки изображения: ThumbHash в base64,
+	// доминирующий цвет и палитра до 5 цветов "#rrggbb" по убыванию доли
+	ThumbHash     string   `json:"thumbhash"`
+	DominantColor string   `json:"dominant_color"`
+	Palette       []string `json:"palette"`
+	// Перцептивный хэш оригинала для поиска почти-дубликатов (nil – ещё не посчитан)
+	PHash *phash.Hash `json:"phash,omitempty"`
+	// Анимация: число кадров (1 у статичных изображений) и длительность в миллисекундах
+	FrameCount int `json:"frame_count"`
+	DurationMs int `json:"duration_ms"`
+	// Статус предгенерации вариантов после загрузки
+	ProcessingStatus string `json:"processing_status"`
+	// Текущая правка (nil – фото без правок) и её номер в истории; Width и
+	// Height остаются размерами оригинала, см. DisplaySize
+	Edit        *transform.Edit `json:"edit,omitempty"`
+	EditVersion int             `json:"edit_version"`
 	// Остальные поля (убраны likes_count, comments_count и пр.)
 	CreatedAt time.Time       `json:"created_at"`
 	UpdatedAt time.Time       `json:"updated_at"`
 	Variants  []*PhotoVariant `json:"variants,omitempty"`
 }
 
+// Animated сообщает, что фото – анимация (GIF или анимированный WebP).
+func (p *Photo) Animated() bool {
+	return p.FrameCount > 1
+}
+
+// DisplaySize возвращает размеры фото с учётом текущей правки.
+func (p *Photo) DisplaySize() (int, int) {
+	return p.Edit.Size(p.Width, p.Height)
+}
+
+// ImageInfo – сведения об изображении, которые вычисляются через libvips после загрузки.
+type ImageInfo struct {
+	Width      int
+	Height     int
+	FrameCount int
+	DurationMs int
+	BlurHash   string
+	ThumbHash  string
+	Palette    []string    // первый цвет – доминирующий
+	PHash      *phash.Hash // nil – хэш уже посчитан и не меняется
+}
+
+// DuplicatePhoto – фото в выдаче почти-дубликатов: только то, что нужно для
+// миниатюры и перехода к фото.
+type DuplicatePhoto struct {
+	ID        int64     `json:"id"`
+	Width     int       `json:"width"`
+	Height    int       `json:"height"`
+	ThumbHash string    `json:"thumbhash"`
+	CreatedAt time.Time `json:"created_at"`
+	Distance  int       `json:"distance"` // расстояние Хэмминга до хэша, с которым сравнивали
+}
+
+// DuplicateGroup – группа почти-дубликатов среди фото пользователя.
+// Distance фото – расстояние до первого фото группы, MaxDistance –
+// наибольшее расстояние Хэмминга между хэшами фото группы (группы
+// транзитивны, поэтому оно может превышать порог).
+type DuplicateGroup struct {
+	Photos      []DuplicatePhoto `json:"photos"`
+	MaxDistance int              `json:"max_distance"`
+}
+
+// DuplicateReport – группы почти-дубликатов среди Scanned последних фото
+// пользователя с посчитанным хэшем. Truncated – более старые фото не
+// сравнивались.
+type DuplicateReport struct {
+	Groups    []DuplicateGroup `json:"groups"`
+	Scanned   int              `json:"scanned"`
+	Truncated bool             `json:"truncated"`
+}
+
+// ReuploadMatch – фото на модерации, похожее на ранее отклонённое.
+type ReuploadMatch struct {
+	Photo           *Photo    `json:"photo"`
+	RejectedPhotoID int64     `json:"rejected_photo_id"`
+	RejectedUserID  int64     `json:"rejected_user_id"`
+	RejectedAt      time.Time `json:"rejected_at"`
+	Distance        int       `json:"distance"` // 0 – в том числе побайтовая копия
+}
+
 type PhotoVariant struct {
 	ID        int64     `json:"id"`
 	PhotoID   int64     `json:"photo_id"`
@@ -40,6 +120,128 @@ type PhotoVariant struct {
 	CreatedAt time.Time `json:"created_at"`
 }
 
+// Статусы фоновой генерации вариантов фото.
+const (
+	ProcessingPending    = "pending"
+	ProcessingInProgress = "processing"
+	ProcessingReady      = "ready"
+	ProcessingFailed     = "failed"
+)
+
+// ProcessingReport – прогресс предгенерации вариантов фото. NearDuplicates –
+// похожие фото, которые у пользователя уже есть (null, пока перцептивный хэш
+// не посчитан анализом).
+type ProcessingReport struct {
+	PhotoID        int64            `json:"photo_id"`
+	Status         string           `json:"status"`
+	Expected       int              `json:"expected"`
+	Ready          int              `json:"ready"`
+	Variants       []*PhotoVariant  `json:"variants"`
+	NearDuplicates []DuplicatePhoto `json:"near_duplicates"`
+}
+
+// UploadResult – ответ на загрузку фото. Заглушки, варианты и похожие фото
+// считаются в фоне: клиент опрашивает ProcessingURL (см. ProcessingReport),
+// пока статус не станет ready или failed.
+type UploadResult struct {
+	*Photo
+	ProcessingURL string `json:"processing_url"`
+}
+
+// SrcsetCandidate – один вариант изображения для srcset.
+type SrcsetCandidate struct {
+	URL   string `json:"url"`
+	Width int    `json:"width"`
+}
+
+// SrcsetSource – набор вариантов одного формата (для <source type=...>).
+type SrcsetSource struct {
+	Format     string            `json:"format"`
+	Type       string            `json:"type"`
+	Srcset     string            `json:"srcset"`
+	Candidates []SrcsetCandidate `json:"candidates"`
+}
+
+// Srcset – готовые данные для адаптивного <picture>/<img srcset>.
+type Srcset struct {
+	PhotoID       int64          `json:"photo_id"`
+	Width         int            `json:"width"`
+	Height        int            `json:"height"`
+	BlurHash      string         `json:"blurhash"`
+	ThumbHash     string         `json:"thumbhash"`
+	DominantColor string         `json:"dominant_color"`
+	Sources       []SrcsetSource `json:"sources"`
+	Fallback      string         `json:"fallback"`
+}
+
+// PhotoSort – порядок сортировки публичной ленты.
+type PhotoSort string
+
+const (
+	SortRecent        PhotoSort = "recent"
+	SortPopular       PhotoSort = "popular"
+	SortMostCommented PhotoSort = "most_commented"
+	// SortTrending – trending-рейтинг за неделю (как /photos/trending?window=week).
+	// С фильтрами рейтинг из Redis неприменим, и лента сортируется как
+	// SortTopWeek.
+	SortTrending PhotoSort = "trending"
+	// SortTopWeek – по лайкам среди фото за последние 7 дней.
+	SortTopWeek PhotoSort = "top_week"
+)
+
+// Orientation – ориентация фото, вычисляемая по его размерам с учётом правки.
+type Orientation string
+
+const (
+	OrientationLandscape Orientation = "landscape"
+	OrientationPortrait  Orientation = "portrait"
+	OrientationSquare    Orientation = "square"
+)
+
+// PhotoFilter – параметры выборки публичной ленты. Нулевые значения означают «без фильтра».
+type PhotoFilter struct {
+	Sort        PhotoSort
+	Orientation Orientation
+	MinWidth    int
+	MinHeight   int
+	MimeTypes   []string
+	From        time.Time
+	To          time.Time
+	UserID      int64
+	// Color – цвет, близкий к которому должен быть в палитре фото;
+	// ColorTolerance – наибольшее расстояние ΔE CIE76 до него
+	Color          *transform.Lab
+	ColorTolerance float64
+}
+
+// HasConditions сообщает, что кроме сортировки заданы фильтры.
+func (f PhotoFilter) HasConditions() bool {
+	return f.Orientation != "" || f.MinWidth > 0 || f.MinHeight > 0 || len(f.MimeTypes) > 0 ||
+		!f.From.IsZero() || !f.To.IsZero() || f.UserID > 0 || f.Color != nil
+}
+
+// Допуск поиска по цвету (ΔE CIE76): по умолчанию – «тот же оттенок»,
+// больше максимума поиск теряет смысл.
+const (
+	DefaultColorTolerance = 15.0
+	MaxColorTolerance     = 50.0
+)
+
+// PhotoEdit – версия в истории правок фото. Версия 0 – исходное фото без
+// правок, в истории она не хранится.
+type PhotoEdit struct {
+	Version   int             `json:"version"`
+	Edit      *transform.Edit `json:"edit"`
+	CreatedAt time.Time       `json:"created_at"`
+}
+
+// PhotoEditHistory – текущая версия правки и все сохранённые версии, новые первыми.
+type PhotoEditHistory struct {
+	PhotoID int64        `json:"photo_id"`
+	Current int          `json:"current"`
+	Edits   []*PhotoEdit `json:"edits"`
+}
+
 type UpdatePhotoRequest struct {
 	Description string `json:"description"`
 	IsPublic    bool   `json:"is_public"`
@@ -0,0 +1,37 @@
+package domain
+
+import "time"
+
+// ViewBucket – агрегаты просмотров фото за один день, накопленные в Redis.
+type ViewBucket struct {
+	PhotoID       int64
+	Day           time.Time
+	Views         int64
+	UniqueViewers int64
+	Referrers     map[string]int64
+}
+
+// DailyStat – статистика фото за день.
+type DailyStat struct {
+	Date          string `json:"date"`
+	Views         int64  `json:"views"`
+	UniqueViewers int64  `json:"unique_viewers"`
+	Likes         int64  `json:"likes"`
+}
+
+// ReferrerStat – число просмотров с одного источника.
+type ReferrerStat struct {
+	Referrer string `json:"referrer"`
+	Views    int64  `json:"views"`
+}
+
+// PhotoStats – аналитика фото для владельца.
+// Уникальные зрители есть только в Daily: дневные множества не складываются.
+type PhotoStats struct {
+	PhotoID   int64          `json:"photo_id"`
+	Days      int            `json:"days"`
+	Views     int64          `json:"views"`
+	Likes     int64          `json:"likes"`
+	Daily     []DailyStat    `json:"daily"`
+	Referrers []ReferrerStat `json:"referrers"`
+}
@@ -0,0 +1,39 @@
+package domain
+
+import "time"
+
+// TrendingWindow – окно, за которое считается trending-рейтинг.
+type TrendingWindow string
+
+const (
+	TrendingDay   TrendingWindow = "day"
+	TrendingWeek  TrendingWindow = "week"
+	TrendingMonth TrendingWindow = "month"
+)
+
+// TrendingWindows – все окна, которые пересчитывает фоновая задача.
+var TrendingWindows = []TrendingWindow{TrendingDay, TrendingWeek, TrendingMonth}
+
+// Period возвращает длительность окна (0 для неизвестного окна).
+func (w TrendingWindow) Period() time.Duration {
+	switch w {
+	case TrendingDay:
+		return 24 * time.Hour
+	case TrendingWeek:
+		return 7 * 24 * time.Hour
+	case TrendingMonth:
+		return 30 * 24 * time.Hour
+	}
+	return 0
+}
+
+// HalfLife – период полураспада вклада события (лайка, комментария) в рейтинг.
+func (w TrendingWindow) HalfLife() time.Duration {
+	return w.Period() / 4
+}
+
+// PhotoScore – рейтинг фото в trending-выдаче.
+type PhotoScore struct {
+	PhotoID int64
+	Score   float64
+}
@@ -0,0 +1,68 @@
+package domain
+
+import (
+	"time"
+
+	"github.com/freshtea599/PhotoHubServer.git/pkg/transform"
+)
+
+// WatermarkKind – вид водяного знака.
+type WatermarkKind string
+
+const (
+	WatermarkText  WatermarkKind = "text"
+	WatermarkImage WatermarkKind = "image"
+	// WatermarkNone отключает знак пользователя по умолчанию для отдельного фото.
+	WatermarkNone WatermarkKind = "none"
+)
+
+// Watermark – настройка водяного знака пользователя (PhotoID = 0) или
+// отдельного фото. Знак накладывается на варианты, которые видят все,
+// кроме владельца; оригиналы не меняются.
+type Watermark struct {
+	ID        int64         `json:"id"`
+	UserID    int64         `json:"user_id"`
+	PhotoID   int64         `json:"photo_id,omitempty"`
+	Kind      WatermarkKind `json:"kind"`
+	Text      string        `json:"text,omitempty"`
+	ImagePath string        `json:"-"`
+	HasImage  bool          `json:"has_image"`
+	Position  string        `json:"position"`
+	Opacity   float64       `json:"opacity"`
+	Scale     float64       `json:"scale"`
+	CreatedAt time.Time     `json:"created_at"`
+	UpdatedAt time.Time     `json:"updated_at"`
+}
+
+// Options возвращает параметры знака для трансформации или nil, если знак не нужен.
+func (w *Watermark) Options() *transform.Watermark {
+	if w == nil {
+		return nil
+	}
+	wm := &transform.Watermark{
+		Gravity: transform.Gravity(w.Position),
+		Opacity: w.Opacity,
+		Scale:   w.Scale,
+	}
+	switch w.Kind {
+	case WatermarkText:
+		wm.Text = w.Text
+	case WatermarkImage:
+		if w.ImagePath == "" {
+			return nil
+		}
+		wm.Image = w.ImagePath
+	default:
+		return nil
+	}
+	return wm
+}
+
+// WatermarkRequest – данные для настройки водяного знака.
+type WatermarkRequest struct {
+	Kind     WatermarkKind `json:"kind"`
+	Text     string        `json:"text"`
+	Position string        `json:"position"`
+	Opacity  float64       `json:"opacity"`
+	Scale    float64       `json:"scale"`
+}
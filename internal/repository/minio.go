@@ -2,6 +2,7 @@
 package repository
 
 import (
+	"bytes"
 	"context"
 	"fmt"
 	"io"
@@ -25,20 +26,47 @@ func NewMinioRepo(client *minio.Client, bucketOriginals, bucketVariants string)
 	}
 }
 
+// streamPartSize – размер части multipart-загрузки, когда длина потока неизвестна.
+// Столько байт MinIO-клиент держит в памяти на одну загрузку (минимум S3 – 5 МиБ).
+const streamPartSize = 5 << 20
+
+// Object – открытый объект хранилища: поток с содержимым и его метаданные.
+type Object struct {
+	io.ReadSeekCloser
+	Size        int64
+	ContentType string
+}
+
+// NewBytesObject оборачивает уже находящиеся в памяти данные в Object.
+func NewBytesObject(data []byte, contentType string) *Object {
+	return &Object{
+		ReadSeekCloser: nopSeekCloser{bytes.NewReader(data)},
+		Size:           int64(len(data)),
+		ContentType:    contentType,
+	}
+}
+
+type nopSeekCloser struct{ io.ReadSeeker }
+
+func (nopSeekCloser) Close() error { return nil }
+
 // PutOriginal загружает оригинал изображения в бакет originals.
+// size = -1 означает поток неизвестной длины: он загружается частями по streamPartSize.
 func (r *MinioRepo) PutOriginal(ctx context.Context, key string, reader io.Reader, size int64, contentType string) error {
-	_, err := r.client.PutObject(ctx, r.bucketOriginals, key, reader, size, minio.PutObjectOptions{
-		ContentType: contentType,
-	})
+	opts := minio.PutObjectOptions{ContentType: contentType}
+	if size < 0 {
+		opts.PartSize = streamPartSize
+	}
+	_, err := r.client.PutObject(ctx, r.bucketOriginals, key, reader, size, opts)
 	if err != nil {
 		return fmt.Errorf("failed to upload original %s: %w", key, err)
 	}
 	return nil
 }
 
-// GetOriginal возвращает io.ReadCloser для чтения оригинала из бакета originals.
-func (r *MinioRepo) GetOriginal(ctx context.Context, key string) (io.ReadCloser, error) {
-	obj, err := r.client.GetObject(ctx, r.bucketOriginals, key, minio.GetObjectOptions{})
+// GetOriginal открывает оригинал из бакета originals для потокового чтения.
+func (r *MinioRepo) GetOriginal(ctx context.Context, key string) (*Object, error) {
+	obj, err := r.open(ctx, r.bucketOriginals, key)
 	if err != nil {
 		return nil, fmt.Errorf("failed to get original %s: %w", key, err)
 	}
@@ -56,19 +84,63 @@ func (r *MinioRepo) PutVariant(ctx context.Context, key string, reader io.Reader
 	return nil
 }
 
-// GetVariant возвращает io.ReadCloser для чтения варианта из бакета variants.
-func (r *MinioRepo) GetVariant(ctx context.Context, key string) (io.ReadCloser, error) {
-	obj, err := r.client.GetObject(ctx, r.bucketVariants, key, minio.GetObjectOptions{})
+// GetVariant открывает вариант из бакета variants для потокового чтения.
+func (r *MinioRepo) GetVariant(ctx context.Context, key string) (*Object, error) {
+	obj, err := r.open(ctx, r.bucketVariants, key)
 	if err != nil {
 		return nil, fmt.Errorf("failed to get variant %s: %w", key, err)
 	}
 	return obj, nil
 }
 
+// open открывает объект и сразу запрашивает его метаданные: так отсутствие
+// объекта обнаруживается до того, как начнётся запись ответа.
+func (r *MinioRepo) open(ctx context.Context, bucket, key string) (*Object, error) {
+	obj, err := r.client.GetObject(ctx, bucket, key, minio.GetObjectOptions{})
+	if err != nil {
+		return nil, err
+	}
+	info, err := obj.Stat()
+	if err != nil {
+		obj.Close()
+		return nil, err
+	}
+	return &Object{ReadSeekCloser: obj, Size: info.Size, ContentType: info.ContentType}, nil
+}
+
+// watermarkPrefix – префикс изображений водяных знаков в бакете originals.
+const watermarkPrefix = "watermarks/"
+
+// PutWatermark загружает изображение водяного знака и возвращает его ключ.
+func (r *MinioRepo) PutWatermark(ctx context.Context, name string, data []byte, contentType string) (string, error) {
+	key := watermarkPrefix + name
+	_, err := r.client.PutObject(ctx, r.bucketOriginals, key, bytes.NewReader(data), int64(len(data)), minio.PutObjectOptions{
+		ContentType: contentType,
+	})
+	if err != nil {
+		return "", fmt.Errorf("failed to upload watermark %s: %w", key, err)
+	}
+	return key, nil
+}
+
+// GetWatermark читает изображение водяного знака целиком – оно небольшое.
+func (r *MinioRepo) GetWatermark(ctx context.Context, key string) ([]byte, error) {
+	obj, err := r.open(ctx, r.bucketOriginals, key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get watermark %s: %w", key, err)
+	}
+	defer obj.Close()
+	return io.ReadAll(obj)
+}
+
 func (r *MinioRepo) DeleteOriginal(ctx context.Context, key string) error {
 	return r.client.RemoveObject(ctx, r.bucketOriginals, key, minio.RemoveObjectOptions{})
 }
 
+func (r *MinioRepo) DeleteVariant(ctx context.Context, key string) error {
+	return r.client.RemoveObject(ctx, r.bucketVariants, key, minio.RemoveObjectOptions{})
+}
+
 func (r *MinioRepo) DeleteVariants(ctx context.Context, photoID int64) error {
 	objectsCh := make(chan minio.ObjectInfo)
 	go func() {
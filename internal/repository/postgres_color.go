@@ -0,0 +1,107 @@
+package repository
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+
+	"github.com/lib/pq"
+
+	"github.com/freshtea599/PhotoHubServer.git/internal/domain"
+	"github.com/freshtea599/PhotoHubServer.git/pkg/transform"
+)
+
+// ===================== ЦВЕТА =====================
+
+// Цвета палитры хранятся в photo_colors по строке на цвет: координаты CIELAB
+// лежат в колонке типа cube (расширение cube) под GiST-индексом. Поиск по
+// цвету сначала выбирает по индексу цвета внутри куба со стороной 2×tolerance,
+// затем отсекает углы куба точным расстоянием.
+
+// SetColors индексирует палитру фото palette, прочитанную из photos.palette.
+// Если палитра фото за это время сменилась (новый анализ или правка), индекс
+// не трогается и возвращается false: строка фото блокируется на чтение,
+// поэтому одновременное обновление палитры дождётся транзакции и само
+// перепишет индекс.
+func (r *PostgresPhotoRepo) SetColors(photoID int64, palette []string) (bool, error) {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+	var current bool
+	err = tx.QueryRow(`
+        SELECT true FROM photos WHERE id = $1 AND palette = $2 FOR SHARE
+    `, photoID, pq.Array(palette)).Scan(&current)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return false, nil
+		}
+		return false, err
+	}
+	if err := replaceColors(tx, photoID, palette); err != nil {
+		return false, err
+	}
+	return true, tx.Commit()
+}
+
+// replaceColors заменяет строки photo_colors фото в транзакции tx.
+// Цвета, которые не удаётся разобрать, пропускаются.
+func replaceColors(tx *sql.Tx, photoID int64, palette []string) error {
+	if _, err := tx.Exec(`DELETE FROM photo_colors WHERE photo_id = $1`, photoID); err != nil {
+		return err
+	}
+	for rank, hex := range palette {
+		c, err := transform.ParseColor(hex)
+		if err != nil {
+			continue
+		}
+		lab := c.Lab()
+		_, err = tx.Exec(`
+            INSERT INTO photo_colors (photo_id, rank, color, lab)
+            VALUES ($1, $2, $3, cube($4::float8[]))
+        `, photoID, rank, hex, pq.Array([]float64{lab.L, lab.A, lab.B}))
+		if err != nil {
+			return fmt.Errorf("insert color %s: %w", hex, err)
+		}
+	}
+	return nil
+}
+
+// colorCondition возвращает условие выборки фото, в палитре которых есть
+// цвет не дальше tolerance (ΔE CIE76) от c. arg добавляет параметр запроса.
+func colorCondition(c transform.Lab, tolerance float64, arg func(any) string) string {
+	lo := pq.Array([]float64{c.L - tolerance, c.A - tolerance, c.B - tolerance})
+	hi := pq.Array([]float64{c.L + tolerance, c.A + tolerance, c.B + tolerance})
+	center := pq.Array([]float64{c.L, c.A, c.B})
+	return `id IN (SELECT photo_id FROM photo_colors
+            WHERE lab <@ cube(` + arg(lo) + `::float8[], ` + arg(hi) + `::float8[])
+              AND cube_distance(lab, cube(` + arg(center) + `::float8[])) <= ` + arg(tolerance) + `)`
+}
+
+// PhotosWithUnindexedColors возвращает до limit фото с id больше afterID,
+// у которых палитра посчитана, но ещё не проиндексирована в photo_colors
+// (по возрастанию id).
+func (r *PostgresPhotoRepo) PhotosWithUnindexedColors(afterID int64, limit int) ([]*domain.Photo, error) {
+	rows, err := r.db.Query(`
+        SELECT `+photoColumns+`
+        FROM photos
+        WHERE cardinality(palette) > 0 AND id > $1
+          AND NOT EXISTS (SELECT 1 FROM photo_colors pc WHERE pc.photo_id = photos.id)
+        ORDER BY id
+        LIMIT $2
+    `, afterID, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var photos []*domain.Photo
+	for rows.Next() {
+		p, err := scanPhoto(rows)
+		if err != nil {
+			return nil, err
+		}
+		photos = append(photos, p)
+	}
+	return photos, rows.Err()
+}
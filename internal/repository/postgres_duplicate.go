@@ -0,0 +1,179 @@
+package repository
+
+import (
+	"database/sql"
+	"strconv"
+
+	"github.com/freshtea599/PhotoHubServer.git/internal/domain"
+	"github.com/freshtea599/PhotoHubServer.git/pkg/phash"
+)
+
+// ===================== ДУБЛИКАТЫ =====================
+
+// Расстояние Хэмминга между перцептивными хэшами считается в Postgres как
+// bit_count(a # b) по 64-битному представлению bigint.
+
+// hashDistance – SQL-выражение расстояния между колонкой column и хэшем в параметре param.
+func hashDistance(column, param string) string {
+	return "bit_count((" + column + " # " + param + ")::bit(64))"
+}
+
+// NearDuplicates возвращает до limit фото пользователя, кроме excludeID, чей
+// перцептивный хэш отличается от hash не больше чем на maxDistance бит –
+// сначала самые похожие.
+func (r *PostgresPhotoRepo) NearDuplicates(userID, excludeID int64, hash phash.Hash, maxDistance, limit int) ([]domain.DuplicatePhoto, error) {
+	rows, err := r.db.Query(`
+        SELECT id, width, height, COALESCE(thumbhash, ''), created_at, distance
+        FROM (
+            SELECT id, width, height, thumbhash, created_at, `+hashDistance("phash", "$3")+` AS distance
+            FROM photos
+            WHERE user_id = $1 AND id <> $2 AND phash IS NOT NULL
+        ) p
+        WHERE distance <= $4
+        ORDER BY distance, id
+        LIMIT $5
+    `, userID, excludeID, int64(hash), maxDistance, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	photos := []domain.DuplicatePhoto{}
+	for rows.Next() {
+		var p domain.DuplicatePhoto
+		if err := rows.Scan(&p.ID, &p.Width, &p.Height, &p.ThumbHash, &p.CreatedAt, &p.Distance); err != nil {
+			return nil, err
+		}
+		photos = append(photos, p)
+	}
+	return photos, rows.Err()
+}
+
+// ListHashedByUser возвращает до limit последних фото пользователя с
+// посчитанным перцептивным хэшем (по убыванию id) и их хэши в том же порядке.
+func (r *PostgresPhotoRepo) ListHashedByUser(userID int64, limit int) ([]domain.DuplicatePhoto, []phash.Hash, error) {
+	rows, err := r.db.Query(`
+        SELECT id, width, height, COALESCE(thumbhash, ''), created_at, phash
+        FROM photos
+        WHERE user_id = $1 AND phash IS NOT NULL
+        ORDER BY id DESC
+        LIMIT $2
+    `, userID, limit)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer rows.Close()
+	var photos []domain.DuplicatePhoto
+	var hashes []phash.Hash
+	for rows.Next() {
+		var p domain.DuplicatePhoto
+		var hash int64
+		if err := rows.Scan(&p.ID, &p.Width, &p.Height, &p.ThumbHash, &p.CreatedAt, &hash); err != nil {
+			return nil, nil, err
+		}
+		photos = append(photos, p)
+		hashes = append(hashes, phash.Hash(hash))
+	}
+	return photos, hashes, rows.Err()
+}
+
+// Совпадения с отклонёнными фото хранятся в reupload_matches – по строке на
+// фото на модерации с самым похожим отклонённым (тот же sha256 или
+// перцептивный хэш не дальше maxDistance; при равенстве – отклонённое
+// последним). Они считаются дважды: при анализе нового фото сравнением с
+// отклонёнными (MatchRejected) и при отклонении сравнением отклонённого с
+// фото на модерации (RejectPhoto), поэтому FindReuploads хэши не сравнивает.
+
+// MatchRejected находит для фото на модерации самое похожее отклонённое и
+// запоминает его, если оно ближе уже найденного.
+func (r *PostgresPhotoRepo) MatchRejected(photoID int64, maxDistance int) error {
+	_, err := r.db.Exec(`
+        INSERT INTO reupload_matches (photo_id, rejected_id, distance)
+        SELECT photos.id, m.id, m.distance
+        FROM photos
+        JOIN LATERAL (
+            SELECT rp.id,
+                   CASE WHEN rp.content_hash = photos.content_hash THEN 0
+                        ELSE `+hashDistance("rp.phash", "photos.phash")+` END AS distance
+            FROM rejected_photos rp
+            WHERE (rp.content_hash <> '' AND rp.content_hash = photos.content_hash)
+               OR `+hashDistance("rp.phash", "photos.phash")+` <= $2
+            ORDER BY distance, rp.rejected_at DESC
+            LIMIT 1
+        ) m ON true
+        WHERE photos.id = $1 AND photos.is_public = false
+        ON CONFLICT (photo_id) DO UPDATE
+        SET rejected_id = EXCLUDED.rejected_id, distance = EXCLUDED.distance
+        WHERE EXCLUDED.distance < reupload_matches.distance
+    `, photoID, maxDistance)
+	return err
+}
+
+// matchPending сопоставляет только что отклонённое фото rejectedID с фото на
+// модерации в транзакции tx: оно заменяет найденное раньше, если не дальше
+// его (при равенстве новое отклонение важнее).
+func matchPending(tx *sql.Tx, rejectedID int64, maxDistance int) error {
+	_, err := tx.Exec(`
+        INSERT INTO reupload_matches (photo_id, rejected_id, distance)
+        SELECT photos.id, rp.id,
+               CASE WHEN rp.content_hash = photos.content_hash THEN 0
+                    ELSE `+hashDistance("rp.phash", "photos.phash")+` END
+        FROM rejected_photos rp
+        JOIN photos ON photos.is_public = false AND photos.id <> rp.photo_id
+         AND ((rp.content_hash <> '' AND rp.content_hash = photos.content_hash)
+              OR `+hashDistance("rp.phash", "photos.phash")+` <= $2)
+        WHERE rp.id = $1
+        ON CONFLICT (photo_id) DO UPDATE
+        SET rejected_id = EXCLUDED.rejected_id, distance = EXCLUDED.distance
+        WHERE EXCLUDED.distance <= reupload_matches.distance
+    `, rejectedID, maxDistance)
+	return err
+}
+
+// FindReuploads возвращает страницу фото на модерации (старые сначала),
+// похожих на отклонённые, вместе с самым похожим отклонённым.
+func (r *PostgresPhotoRepo) FindReuploads(limit int, cursor *domain.Cursor) (domain.Page[domain.ReuploadMatch], error) {
+	cond, args := keysetCondition("photos.", "", cursor, false, nil)
+	args = append(args, limit+1)
+	rows, err := r.db.Query(`
+        SELECT `+photoColumns+`, rp.rejected_photo_id, rp.rejected_user_id, rp.rejected_at, m.distance
+        FROM photos
+        JOIN reupload_matches m ON m.photo_id = photos.id
+        JOIN (
+            SELECT id AS match_id, photo_id AS rejected_photo_id, user_id AS rejected_user_id, rejected_at
+            FROM rejected_photos
+        ) rp ON rp.match_id = m.rejected_id
+        WHERE photos.is_public = false`+cond+`
+        ORDER BY photos.created_at ASC, photos.id ASC
+        LIMIT $`+strconv.Itoa(len(args)), args...)
+	if err != nil {
+		return domain.Page[domain.ReuploadMatch]{}, err
+	}
+	defer rows.Close()
+
+	var matches []domain.ReuploadMatch
+	for rows.Next() {
+		var m domain.ReuploadMatch
+		var err error
+		m.Photo, err = scanPhoto(scanTail{rows, []any{&m.RejectedPhotoID, &m.RejectedUserID, &m.RejectedAt, &m.Distance}})
+		if err != nil {
+			return domain.Page[domain.ReuploadMatch]{}, err
+		}
+		matches = append(matches, m)
+	}
+	if err := rows.Err(); err != nil {
+		return domain.Page[domain.ReuploadMatch]{}, err
+	}
+	return domain.NewPage(matches, limit, func(m domain.ReuploadMatch) domain.Cursor {
+		return domain.Cursor{CreatedAt: m.Photo.CreatedAt, ID: m.Photo.ID}
+	}), nil
+}
+
+// scanTail дочитывает колонки, следующие в строке за колонками фото.
+type scanTail struct {
+	row  rowScanner
+	tail []any
+}
+
+func (s scanTail) Scan(dest ...any) error {
+	return s.row.Scan(append(dest, s.tail...)...)
+}
@@ -0,0 +1,142 @@
+package repository
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/freshtea599/PhotoHubServer.git/internal/domain"
+	"github.com/freshtea599/PhotoHubServer.git/pkg/transform"
+)
+
+// ===================== ПРАВКИ ФОТО =====================
+
+// unmarshalEdit разбирает правку из JSON-колонки (NULL – правки нет).
+func unmarshalEdit(data []byte) (*transform.Edit, error) {
+	if len(data) == 0 {
+		return nil, nil
+	}
+	var e transform.Edit
+	if err := json.Unmarshal(data, &e); err != nil {
+		return nil, fmt.Errorf("unmarshal edit: %w", err)
+	}
+	return &e, nil
+}
+
+// marshalEdit готовит правку к записи в JSON-колонку (nil – NULL).
+// JSON передаётся строкой: []byte драйвер отправил бы как bytea.
+func marshalEdit(e *transform.Edit) (any, error) {
+	if e == nil {
+		return nil, nil
+	}
+	data, err := json.Marshal(e)
+	if err != nil {
+		return nil, err
+	}
+	return string(data), nil
+}
+
+// SaveEdit делает edit (nil – без правок) текущей правкой фото и добавляет
+// её в историю новой версией. Заглушки и палитра сбрасываются, статус
+// обработки возвращается в pending – варианты нужно построить заново.
+// Возвращает номер новой версии.
+func (r *PostgresPhotoRepo) SaveEdit(photoID int64, edit *transform.Edit) (int, error) {
+	data, err := marshalEdit(edit)
+	if err != nil {
+		return 0, err
+	}
+	tx, err := r.db.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	// размеры фото с правкой нужны фильтрам ленты (ориентация, min_width)
+	var width, height int
+	err = tx.QueryRow(`
+        SELECT COALESCE(width, 0), COALESCE(height, 0) FROM photos WHERE id = $1 FOR UPDATE
+    `, photoID).Scan(&width, &height)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return 0, errors.New("photo not found")
+		}
+		return 0, err
+	}
+	displayW, displayH := edit.Size(width, height)
+
+	var version int
+	err = tx.QueryRow(`
+        UPDATE photos
+        SET edit = $1, edit_version = edit_version + 1, blurhash = '',
+            thumbhash = NULL, dominant_color = NULL, palette = NULL,
+            display_width = $2, display_height = $3,
+            processing_status = $4, updated_at = NOW()
+        WHERE id = $5
+        RETURNING edit_version
+    `, data, displayW, displayH, domain.ProcessingPending, photoID).Scan(&version)
+	if err != nil {
+		return 0, err
+	}
+	if _, err := tx.Exec(`DELETE FROM photo_colors WHERE photo_id = $1`, photoID); err != nil {
+		return 0, err
+	}
+	_, err = tx.Exec(`
+        INSERT INTO photo_edits (photo_id, version, edit, created_at)
+        VALUES ($1, $2, $3, NOW())
+    `, photoID, version, data)
+	if err != nil {
+		return 0, err
+	}
+	return version, tx.Commit()
+}
+
+// GetEdit возвращает версию правки фото или nil, nil, если её нет.
+func (r *PostgresPhotoRepo) GetEdit(photoID int64, version int) (*domain.PhotoEdit, error) {
+	e, err := scanEdit(r.db.QueryRow(`
+        SELECT version, edit, created_at
+        FROM photo_edits
+        WHERE photo_id = $1 AND version = $2
+    `, photoID, version))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	return e, err
+}
+
+// ListEdits возвращает историю правок фото, новые версии первыми.
+func (r *PostgresPhotoRepo) ListEdits(photoID int64) ([]*domain.PhotoEdit, error) {
+	rows, err := r.db.Query(`
+        SELECT version, edit, created_at
+        FROM photo_edits
+        WHERE photo_id = $1
+        ORDER BY version DESC
+    `, photoID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	edits := []*domain.PhotoEdit{}
+	for rows.Next() {
+		e, err := scanEdit(rows)
+		if err != nil {
+			return nil, err
+		}
+		edits = append(edits, e)
+	}
+	return edits, rows.Err()
+}
+
+func scanEdit(row rowScanner) (*domain.PhotoEdit, error) {
+	var e domain.PhotoEdit
+	var data []byte
+	if err := row.Scan(&e.Version, &data, &e.CreatedAt); err != nil {
+		return nil, err
+	}
+	var err error
+	if e.Edit, err = unmarshalEdit(data); err != nil {
+		return nil, err
+	}
+	return &e, nil
+}
@@ -3,10 +3,15 @@ package repository
 import (
 	"database/sql"
 	"errors"
+	"fmt"
 	"log"
+	"math"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/freshtea599/PhotoHubServer.git/internal/domain"
+	"github.com/freshtea599/PhotoHubServer.git/pkg/phash"
 	"github.com/lib/pq"
 )
 
@@ -22,79 +27,261 @@ func NewPostgresPhotoRepo(db *sql.DB) *PostgresPhotoRepo {
 func (r *PostgresPhotoRepo) Create(photo *domain.Photo) (*domain.Photo, error) {
 	err := r.db.QueryRow(`
         INSERT INTO photos (user_id, url, file_path, file_size, mime_type, description, is_public,
-                            blurhash, content_hash, width, height, created_at, updated_at)
-        VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, NOW(), NOW())
+                            blurhash, content_hash, width, height, display_width, display_height,
+                            frame_count, duration_ms, processing_status, created_at, updated_at)
+        VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $10, $11, $12, $13, $14, NOW(), NOW())
         RETURNING id, created_at, updated_at
     `, photo.UserID, photo.URL, photo.FilePath, photo.FileSize, photo.MimeType,
 		photo.Description, photo.IsPublic, photo.BlurHash, photo.ContentHash,
-		photo.Width, photo.Height).Scan(&photo.ID, &photo.CreatedAt, &photo.UpdatedAt)
+		photo.Width, photo.Height, photo.FrameCount, photo.DurationMs, photo.ProcessingStatus).Scan(&photo.ID, &photo.CreatedAt, &photo.UpdatedAt)
 	if err != nil {
 		return nil, err
 	}
 	return photo, nil
 }
 
-// CreateVariant сохраняет вариант изображения
-func (r *PostgresPhotoRepo) CreateVariant(variant *domain.PhotoVariant) error {
-	_, err := r.db.Exec(`
+// CreateVariant сохраняет вариант изображения, если фото всё ещё в версии
+// правки editVersion; иначе возвращает false. Строка фото блокируется на
+// чтение, поэтому вставка не разминётся с одновременной правкой. Если
+// вариант с теми же параметрами уже есть, запись обновляется: путь к объекту
+// детерминирован, поэтому повторная генерация перезаписывает тот же объект.
+func (r *PostgresPhotoRepo) CreateVariant(variant *domain.PhotoVariant, editVersion int) (bool, error) {
+	err := r.db.QueryRow(`
         INSERT INTO photo_variants (photo_id, size_name, format, file_path, file_size, width, height, quality, created_at)
-        VALUES ($1, $2, $3, $4, $5, $6, $7, $8, NOW())
+        SELECT id, $2, $3, $4, $5, $6, $7, $8, NOW()
+        FROM photos
+        WHERE id = $1 AND edit_version = $9
+        FOR SHARE
+        ON CONFLICT (photo_id, size_name, format) DO UPDATE
+        SET file_path = EXCLUDED.file_path, file_size = EXCLUDED.file_size,
+            width = EXCLUDED.width, height = EXCLUDED.height, quality = EXCLUDED.quality
+        RETURNING id, created_at
     `, variant.PhotoID, variant.SizeName, variant.Format, variant.FilePath, variant.FileSize,
-		variant.Width, variant.Height, variant.Quality)
-	return err
+		variant.Width, variant.Height, variant.Quality, editVersion).Scan(&variant.ID, &variant.CreatedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// GetVariant ищет готовый вариант фото. Возвращает nil, если вариант ещё не создан.
+func (r *PostgresPhotoRepo) GetVariant(photoID int64, sizeName, format string) (*domain.PhotoVariant, error) {
+	v, err := scanVariant(r.db.QueryRow(`
+        SELECT `+variantColumns+`
+        FROM photo_variants
+        WHERE photo_id = $1 AND size_name = $2 AND format = $3
+    `, photoID, sizeName, format))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return v, nil
+}
+
+// variantColumns – колонки photo_variants в порядке scanVariant.
+const variantColumns = `id, photo_id, size_name, format, file_path, COALESCE(file_size, 0),
+               COALESCE(width, 0), COALESCE(height, 0), COALESCE(quality, 0), created_at`
+
+func scanVariant(row rowScanner) (*domain.PhotoVariant, error) {
+	v := &domain.PhotoVariant{}
+	err := row.Scan(&v.ID, &v.PhotoID, &v.SizeName, &v.Format, &v.FilePath, &v.FileSize,
+		&v.Width, &v.Height, &v.Quality, &v.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+	return v, nil
+}
+
+// photoColumns – общий список колонок для выборок из photos (порядок важен для scanPhoto).
+const photoColumns = `id, user_id, url, file_path, file_size, mime_type, description, is_public,
+               blurhash, content_hash, width, height, frame_count, duration_ms,
+               COALESCE(thumbhash, ''), COALESCE(dominant_color, ''), palette, phash,
+               likes_count, comments_count, views_count,
+               processing_status, edit, edit_version, created_at, updated_at`
+
+type rowScanner interface {
+	Scan(dest ...any) error
 }
 
-// ListPublic возвращает публичные фото с пагинацией
-func (r *PostgresPhotoRepo) ListPublic(limit, offset int) ([]*domain.Photo, error) {
+func scanPhoto(row rowScanner) (*domain.Photo, error) {
+	var p domain.Photo
+	var edit []byte
+	var hash sql.NullInt64
+	err := row.Scan(&p.ID, &p.UserID, &p.URL, &p.FilePath, &p.FileSize,
+		&p.MimeType, &p.Description, &p.IsPublic, &p.BlurHash, &p.ContentHash,
+		&p.Width, &p.Height, &p.FrameCount, &p.DurationMs,
+		&p.ThumbHash, &p.DominantColor, pq.Array(&p.Palette), &hash, &p.LikesCount, &p.CommentsCount, &p.ViewsCount,
+		&p.ProcessingStatus, &edit, &p.EditVersion, &p.CreatedAt, &p.UpdatedAt)
+	if err != nil {
+		return nil, err
+	}
+	if p.Edit, err = unmarshalEdit(edit); err != nil {
+		return nil, fmt.Errorf("photo %d: %w", p.ID, err)
+	}
+	if hash.Valid {
+		h := phash.Hash(hash.Int64)
+		p.PHash = &h
+	}
+	return &p, nil
+}
+
+// keysetCondition добавляет условие продолжения выборки после курсора.
+// sortColumn – необязательная колонка, по которой сортировка идёт перед (created_at, id);
+// desc задаёт направление сортировки.
+func keysetCondition(prefix, sortColumn string, cursor *domain.Cursor, desc bool, args []any) (string, []any) {
+	if cursor == nil {
+		return "", args
+	}
+	op := ">"
+	if desc {
+		op = "<"
+	}
+	if sortColumn != "" {
+		args = append(args, cursor.Value, cursor.CreatedAt, cursor.ID)
+		return fmt.Sprintf(" AND (%s%s, %screated_at, %sid) %s ($%d, $%d, $%d)",
+			prefix, sortColumn, prefix, prefix, op, len(args)-2, len(args)-1, len(args)), args
+	}
+	args = append(args, cursor.CreatedAt, cursor.ID)
+	return fmt.Sprintf(" AND (%screated_at, %sid) %s ($%d, $%d)", prefix, prefix, op, len(args)-1, len(args)), args
+}
+
+// photoSortColumns сопоставляет сортировку ленты с колонкой-ключом.
+// Для SortRecent колонки нет – сортировка только по (created_at, id).
+var photoSortColumns = map[domain.PhotoSort]string{
+	domain.SortPopular:       "likes_count",
+	domain.SortMostCommented: "comments_count",
+	domain.SortTopWeek:       "likes_count",
+	domain.SortTrending:      "likes_count",
+}
+
+// topWeekWindow – окно, за которое фото попадают в сортировку top_week
+// (и trending с фильтрами).
+const topWeekWindow = 7 * 24 * time.Hour
+
+// photoCursor возвращает функцию, вычисляющую позицию фото в ленте с заданной сортировкой.
+func photoCursor(sort domain.PhotoSort) func(*domain.Photo) domain.Cursor {
+	return func(p *domain.Photo) domain.Cursor {
+		c := domain.Cursor{CreatedAt: p.CreatedAt, ID: p.ID}
+		switch photoSortColumns[sort] {
+		case "likes_count":
+			c.Sort, c.Value = string(sort), int64(p.LikesCount)
+		case "comments_count":
+			c.Sort, c.Value = string(sort), int64(p.CommentsCount)
+		}
+		return c
+	}
+}
+
+// listPhotos выполняет выборку фото по where-условию с keyset-пагинацией.
+// Курсор должен быть выдан для той же сортировки, иначе возвращается domain.ErrInvalidCursor.
+func (r *PostgresPhotoRepo) listPhotos(where string, args []any, sort domain.PhotoSort, limit int, cursor *domain.Cursor) (domain.Page[*domain.Photo], error) {
+	sortColumn := photoSortColumns[sort]
+	expectedSort := ""
+	if sortColumn != "" {
+		expectedSort = string(sort)
+	}
+	if cursor != nil && cursor.Sort != expectedSort {
+		return domain.Page[*domain.Photo]{}, domain.ErrInvalidCursor
+	}
+	cond, args := keysetCondition("", sortColumn, cursor, true, args)
+	orderBy := "created_at DESC, id DESC"
+	if sortColumn != "" {
+		orderBy = sortColumn + " DESC, " + orderBy
+	}
+	args = append(args, limit+1)
 	rows, err := r.db.Query(`
-        SELECT id, user_id, url, file_path, file_size, mime_type, description, is_public,
-               blurhash, content_hash, width, height, likes_count, comments_count, created_at, updated_at
+        SELECT `+photoColumns+`
         FROM photos
-        WHERE is_public = true
-        ORDER BY created_at DESC
-        LIMIT $1 OFFSET $2
-    `, limit, offset)
+        WHERE `+where+cond+`
+        ORDER BY `+orderBy+`
+        LIMIT $`+strconv.Itoa(len(args)), args...)
 	if err != nil {
-		return nil, err
+		return domain.Page[*domain.Photo]{}, err
 	}
 	defer rows.Close()
 
 	var photos []*domain.Photo
-	photosMap := make(map[int64]*domain.Photo)
-	var ids []int64
-
 	for rows.Next() {
-		var p domain.Photo
-		err := rows.Scan(&p.ID, &p.UserID, &p.URL, &p.FilePath, &p.FileSize,
-			&p.MimeType, &p.Description, &p.IsPublic, &p.BlurHash, &p.ContentHash,
-			&p.Width, &p.Height, &p.LikesCount, &p.CommentsCount, &p.CreatedAt, &p.UpdatedAt)
+		p, err := scanPhoto(rows)
 		if err != nil {
-			return nil, err
+			return domain.Page[*domain.Photo]{}, err
 		}
-		photos = append(photos, &p)
-		photosMap[p.ID] = &p
-		ids = append(ids, p.ID)
+		photos = append(photos, p)
+	}
+	if err := rows.Err(); err != nil {
+		return domain.Page[*domain.Photo]{}, err
 	}
 
-	if len(photos) > 0 {
+	page := domain.NewPage(photos, limit, photoCursor(sort))
+	if len(page.Items) > 0 {
+		photosMap := make(map[int64]*domain.Photo, len(page.Items))
+		ids := make([]int64, 0, len(page.Items))
+		for _, p := range page.Items {
+			photosMap[p.ID] = p
+			ids = append(ids, p.ID)
+		}
 		if err := r.enrichPhotosWithVariants(photosMap, ids); err != nil {
 			log.Printf("Warning: could not load variants: %v", err)
 		}
 	}
 
-	return photos, nil
+	return page, nil
+}
+
+// ListPublic возвращает страницу публичных фото с учётом фильтров и сортировки,
+// начиная после cursor (nil – с начала ленты)
+func (r *PostgresPhotoRepo) ListPublic(filter domain.PhotoFilter, limit int, cursor *domain.Cursor) (domain.Page[*domain.Photo], error) {
+	conds := []string{"is_public = true"}
+	var args []any
+	arg := func(v any) string {
+		args = append(args, v)
+		return "$" + strconv.Itoa(len(args))
+	}
+
+	switch filter.Orientation {
+	case domain.OrientationLandscape:
+		conds = append(conds, "display_width > display_height")
+	case domain.OrientationPortrait:
+		conds = append(conds, "display_width < display_height")
+	case domain.OrientationSquare:
+		conds = append(conds, "display_width = display_height")
+	}
+	if filter.MinWidth > 0 {
+		conds = append(conds, "display_width >= "+arg(filter.MinWidth))
+	}
+	if filter.MinHeight > 0 {
+		conds = append(conds, "display_height >= "+arg(filter.MinHeight))
+	}
+	if len(filter.MimeTypes) > 0 {
+		conds = append(conds, "mime_type = ANY("+arg(pq.Array(filter.MimeTypes))+")")
+	}
+	if !filter.From.IsZero() {
+		conds = append(conds, "created_at >= "+arg(filter.From))
+	}
+	if !filter.To.IsZero() {
+		conds = append(conds, "created_at < "+arg(filter.To))
+	}
+	if filter.UserID > 0 {
+		conds = append(conds, "user_id = "+arg(filter.UserID))
+	}
+	if filter.Color != nil {
+		conds = append(conds, colorCondition(*filter.Color, filter.ColorTolerance, arg))
+	}
+	if filter.Sort == domain.SortTopWeek || filter.Sort == domain.SortTrending {
+		conds = append(conds, "created_at >= "+arg(time.Now().UTC().Add(-topWeekWindow)))
+	}
+
+	return r.listPhotos(strings.Join(conds, " AND "), args, filter.Sort, limit, cursor)
 }
 
 // GetByID возвращает фото по ID
 func (r *PostgresPhotoRepo) GetByID(id int64) (*domain.Photo, error) {
-	var p domain.Photo
-	err := r.db.QueryRow(`
-        SELECT id, user_id, url, file_path, file_size, mime_type, description, is_public,
-               blurhash, content_hash, width, height, likes_count, comments_count, created_at, updated_at
+	p, err := scanPhoto(r.db.QueryRow(`
+        SELECT `+photoColumns+`
         FROM photos WHERE id = $1
-    `, id).Scan(&p.ID, &p.UserID, &p.URL, &p.FilePath, &p.FileSize,
-		&p.MimeType, &p.Description, &p.IsPublic, &p.BlurHash, &p.ContentHash,
-		&p.Width, &p.Height, &p.LikesCount, &p.CommentsCount, &p.CreatedAt, &p.UpdatedAt)
+    `, id))
 	if err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
 			return nil, errors.New("photo not found")
@@ -102,54 +289,93 @@ func (r *PostgresPhotoRepo) GetByID(id int64) (*domain.Photo, error) {
 		return nil, err
 	}
 
-	photosMap := map[int64]*domain.Photo{p.ID: &p}
+	photosMap := map[int64]*domain.Photo{p.ID: p}
 	if err := r.enrichPhotosWithVariants(photosMap, []int64{p.ID}); err != nil {
 		log.Printf("Warning: could not load variants for photo %d: %v", p.ID, err)
 	}
 
-	return &p, nil
+	return p, nil
+}
+
+// ListByUser возвращает страницу фото пользователя
+func (r *PostgresPhotoRepo) ListByUser(userID int64, limit int, cursor *domain.Cursor) (domain.Page[*domain.Photo], error) {
+	return r.listPhotos("user_id = $1", []any{userID}, domain.SortRecent, limit, cursor)
 }
 
-// ListByUser возвращает фото пользователя
-func (r *PostgresPhotoRepo) ListByUser(userID int64, limit, offset int) ([]*domain.Photo, error) {
+// GetByIDs возвращает публичные фото по списку ID, сохраняя порядок ids.
+// Отсутствующие и скрытые фото пропускаются.
+func (r *PostgresPhotoRepo) GetByIDs(ids []int64) ([]*domain.Photo, error) {
+	if len(ids) == 0 {
+		return []*domain.Photo{}, nil
+	}
 	rows, err := r.db.Query(`
-        SELECT id, user_id, url, file_path, file_size, mime_type, description, is_public,
-               blurhash, content_hash, width, height, likes_count, comments_count, created_at, updated_at
+        SELECT `+photoColumns+`
         FROM photos
-        WHERE user_id = $1
-        ORDER BY created_at DESC
-        LIMIT $2 OFFSET $3
-    `, userID, limit, offset)
+        WHERE id = ANY($1) AND is_public = true
+    `, pq.Array(ids))
 	if err != nil {
 		return nil, err
 	}
 	defer rows.Close()
 
-	var photos []*domain.Photo
-	photosMap := make(map[int64]*domain.Photo)
-	var ids []int64
-
+	photosMap := make(map[int64]*domain.Photo, len(ids))
 	for rows.Next() {
-		var p domain.Photo
-		if err := rows.Scan(&p.ID, &p.UserID, &p.URL, &p.FilePath, &p.FileSize,
-			&p.MimeType, &p.Description, &p.IsPublic, &p.BlurHash, &p.ContentHash,
-			&p.Width, &p.Height, &p.LikesCount, &p.CommentsCount, &p.CreatedAt, &p.UpdatedAt); err != nil {
+		p, err := scanPhoto(rows)
+		if err != nil {
 			return nil, err
 		}
-		photos = append(photos, &p)
-		photosMap[p.ID] = &p
-		ids = append(ids, p.ID)
+		photosMap[p.ID] = p
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	if err := r.enrichPhotosWithVariants(photosMap, ids); err != nil {
+		log.Printf("Warning: could not load variants: %v", err)
 	}
 
-	if len(photos) > 0 {
-		if err := r.enrichPhotosWithVariants(photosMap, ids); err != nil {
-			log.Printf("Warning: could not load variants: %v", err)
+	photos := make([]*domain.Photo, 0, len(photosMap))
+	for _, id := range ids {
+		if p, ok := photosMap[id]; ok {
+			photos = append(photos, p)
 		}
 	}
-
 	return photos, nil
 }
 
+// TrendingScores считает рейтинг публичных фото по событиям (лайки, комментарии, просмотры) за окно.
+// Вклад каждого события экспоненциально затухает с периодом полураспада окна.
+// Просмотры хранятся дневными агрегатами, поэтому их время условно берётся серединой дня.
+func (r *PostgresPhotoRepo) TrendingScores(window domain.TrendingWindow, likeWeight, commentWeight, viewWeight float64) ([]domain.PhotoScore, error) {
+	since := time.Now().UTC().Add(-window.Period())
+	tau := window.HalfLife().Seconds() / math.Ln2
+	rows, err := r.db.Query(`
+        SELECT e.photo_id, SUM(e.weight * EXP(-GREATEST(EXTRACT(EPOCH FROM (NOW() - e.created_at)), 0) / $2)) AS score
+        FROM (
+            SELECT photo_id, created_at, $3::float8 AS weight FROM photo_likes WHERE created_at >= $1
+            UNION ALL
+            SELECT photo_id, created_at, $4::float8 FROM comments WHERE created_at >= $1
+            UNION ALL
+            SELECT photo_id, day + interval '12 hours', views * $5::float8 FROM photo_view_daily WHERE day >= $1::date
+        ) e
+        JOIN photos p ON p.id = e.photo_id AND p.is_public = true
+        GROUP BY e.photo_id
+    `, since, tau, likeWeight, commentWeight, viewWeight)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var scores []domain.PhotoScore
+	for rows.Next() {
+		var s domain.PhotoScore
+		if err := rows.Scan(&s.PhotoID, &s.Score); err != nil {
+			return nil, err
+		}
+		scores = append(scores, s)
+	}
+	return scores, rows.Err()
+}
+
 // Update обновляет описание и публичность
 func (r *PostgresPhotoRepo) Update(id int64, req domain.UpdatePhotoRequest) (*domain.Photo, error) {
 	_, err := r.db.Exec(`
@@ -162,6 +388,122 @@ func (r *PostgresPhotoRepo) Update(id int64, req domain.UpdatePhotoRequest) (*do
 	return r.GetByID(id)
 }
 
+// SetImageInfo сохраняет сведения об оригинале, вычисленные после загрузки,
+// и индексирует палитру для поиска по цвету, если фото всё ещё в версии
+// правки editVersion (заглушки и палитра строятся по правке); иначе
+// возвращает false.
+func (r *PostgresPhotoRepo) SetImageInfo(id int64, editVersion int, info domain.ImageInfo) (bool, error) {
+	var dominant sql.NullString
+	if len(info.Palette) > 0 {
+		dominant = sql.NullString{String: info.Palette[0], Valid: true}
+	}
+	var hash sql.NullInt64
+	if info.PHash != nil {
+		hash = sql.NullInt64{Int64: int64(*info.PHash), Valid: true}
+	}
+	tx, err := r.db.Begin()
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+	var data []byte
+	err = tx.QueryRow(`
+        SELECT edit FROM photos WHERE id = $1 AND edit_version = $2 FOR UPDATE
+    `, id, editVersion).Scan(&data)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return false, nil
+		}
+		return false, err
+	}
+	edit, err := unmarshalEdit(data)
+	if err != nil {
+		return false, err
+	}
+	displayW, displayH := edit.Size(info.Width, info.Height)
+	_, err = tx.Exec(`
+        UPDATE photos SET width = $1, height = $2, display_width = $3, display_height = $4,
+                          frame_count = $5, duration_ms = $6, blurhash = $7, thumbhash = $8,
+                          dominant_color = $9, palette = $10, phash = COALESCE($11, phash)
+        WHERE id = $12
+    `, info.Width, info.Height, displayW, displayH, info.FrameCount, info.DurationMs, info.BlurHash,
+		info.ThumbHash, dominant, pq.Array(info.Palette), hash, id)
+	if err != nil {
+		return false, err
+	}
+	if err := replaceColors(tx, id, info.Palette); err != nil {
+		return false, err
+	}
+	return true, tx.Commit()
+}
+
+// PhotosWithoutAnalysis возвращает до limit фото с id больше afterID,
+// загруженных до before, для которых ещё не посчитаны ThumbHash или
+// перцептивный хэш (по возрастанию id).
+func (r *PostgresPhotoRepo) PhotosWithoutAnalysis(before time.Time, afterID int64, limit int) ([]*domain.Photo, error) {
+	rows, err := r.db.Query(`
+        SELECT `+photoColumns+`
+        FROM photos
+        WHERE (thumbhash IS NULL OR phash IS NULL) AND created_at < $1 AND id > $2
+        ORDER BY id
+        LIMIT $3
+    `, before, afterID, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var photos []*domain.Photo
+	for rows.Next() {
+		p, err := scanPhoto(rows)
+		if err != nil {
+			return nil, err
+		}
+		photos = append(photos, p)
+	}
+	return photos, rows.Err()
+}
+
+// DeleteVariants удаляет записи обо всех вариантах фото.
+func (r *PostgresPhotoRepo) DeleteVariants(photoID int64) error {
+	_, err := r.db.Exec(`DELETE FROM photo_variants WHERE photo_id = $1`, photoID)
+	return err
+}
+
+// GetAutoQuality возвращает качество, подобранное для q=auto в формате
+// format, или 0, если подбора ещё не было.
+func (r *PostgresPhotoRepo) GetAutoQuality(photoID int64, format string) (int, error) {
+	var quality int
+	err := r.db.QueryRow(`
+        SELECT quality FROM photo_auto_quality WHERE photo_id = $1 AND format = $2
+    `, photoID, format).Scan(&quality)
+	if errors.Is(err, sql.ErrNoRows) {
+		return 0, nil
+	}
+	return quality, err
+}
+
+// SetAutoQuality сохраняет качество, подобранное для q=auto.
+func (r *PostgresPhotoRepo) SetAutoQuality(photoID int64, format string, quality int) error {
+	_, err := r.db.Exec(`
+        INSERT INTO photo_auto_quality (photo_id, format, quality, created_at)
+        VALUES ($1, $2, $3, NOW())
+        ON CONFLICT (photo_id, format) DO UPDATE SET quality = EXCLUDED.quality, created_at = NOW()
+    `, photoID, format, quality)
+	return err
+}
+
+// DeleteAutoQuality забывает подобранное качество во всех форматах.
+func (r *PostgresPhotoRepo) DeleteAutoQuality(photoID int64) error {
+	_, err := r.db.Exec(`DELETE FROM photo_auto_quality WHERE photo_id = $1`, photoID)
+	return err
+}
+
+// SetProcessingStatus обновляет статус фоновой генерации вариантов
+func (r *PostgresPhotoRepo) SetProcessingStatus(id int64, status string) error {
+	_, err := r.db.Exec(`UPDATE photos SET processing_status = $1 WHERE id = $2`, status, id)
+	return err
+}
+
 // Delete удаляет фото
 func (r *PostgresPhotoRepo) Delete(id int64) error {
 	res, err := r.db.Exec(`DELETE FROM photos WHERE id = $1`, id)
@@ -181,7 +523,7 @@ func (r *PostgresPhotoRepo) enrichPhotosWithVariants(photosMap map[int64]*domain
 		return nil
 	}
 	rows, err := r.db.Query(`
-        SELECT id, photo_id, size_name, format, file_path, width, height, quality, created_at
+        SELECT `+variantColumns+`
         FROM photo_variants
         WHERE photo_id = ANY($1)
         ORDER BY photo_id, size_name
@@ -192,9 +534,8 @@ func (r *PostgresPhotoRepo) enrichPhotosWithVariants(photosMap map[int64]*domain
 	defer rows.Close()
 
 	for rows.Next() {
-		v := &domain.PhotoVariant{}
-		if err := rows.Scan(&v.ID, &v.PhotoID, &v.SizeName, &v.Format, &v.FilePath,
-			&v.Width, &v.Height, &v.Quality, &v.CreatedAt); err != nil {
+		v, err := scanVariant(rows)
+		if err != nil {
 			log.Printf("Error scanning variant: %v", err)
 			continue
 		}
@@ -253,28 +594,54 @@ func (r *PostgresPhotoRepo) CreateComment(photoID, userID int64, text string) (i
 	return id, err
 }
 
-func (r *PostgresPhotoRepo) GetComments(photoID int64) ([]Comment, error) {
-	rows, err := r.db.Query(`
-        SELECT c.id, c.user_id, c.text, c.likes_count, c.created_at, u.username
+// GetComment возвращает один комментарий вместе с именем автора
+func (r *PostgresPhotoRepo) GetComment(commentID int64) (*Comment, error) {
+	var c Comment
+	err := r.db.QueryRow(`
+        SELECT c.id, c.user_id, c.photo_id, c.text, c.likes_count, c.created_at, u.username
         FROM comments c
         JOIN users u ON c.user_id = u.id
-        WHERE c.photo_id = $1
-        ORDER BY c.created_at DESC
-    `, photoID)
+        WHERE c.id = $1
+    `, commentID).Scan(&c.ID, &c.UserID, &c.PhotoID, &c.Text, &c.Likes, &c.CreatedAt, &c.Username)
 	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, errors.New("comment not found")
+		}
 		return nil, err
 	}
+	return &c, nil
+}
+
+// GetComments возвращает страницу комментариев к фото (новые сначала)
+func (r *PostgresPhotoRepo) GetComments(photoID int64, limit int, cursor *domain.Cursor) (domain.Page[Comment], error) {
+	cond, args := keysetCondition("c.", "", cursor, true, []any{photoID})
+	args = append(args, limit+1)
+	rows, err := r.db.Query(`
+        SELECT c.id, c.user_id, c.photo_id, c.text, c.likes_count, c.created_at, u.username
+        FROM comments c
+        JOIN users u ON c.user_id = u.id
+        WHERE c.photo_id = $1`+cond+`
+        ORDER BY c.created_at DESC, c.id DESC
+        LIMIT $`+strconv.Itoa(len(args)), args...)
+	if err != nil {
+		return domain.Page[Comment]{}, err
+	}
 	defer rows.Close()
 
 	var comments []Comment
 	for rows.Next() {
 		var c Comment
-		if err := rows.Scan(&c.ID, &c.UserID, &c.Text, &c.Likes, &c.CreatedAt, &c.Username); err != nil {
-			return nil, err
+		if err := rows.Scan(&c.ID, &c.UserID, &c.PhotoID, &c.Text, &c.Likes, &c.CreatedAt, &c.Username); err != nil {
+			return domain.Page[Comment]{}, err
 		}
 		comments = append(comments, c)
 	}
-	return comments, nil
+	if err := rows.Err(); err != nil {
+		return domain.Page[Comment]{}, err
+	}
+	return domain.NewPage(comments, limit, func(c Comment) domain.Cursor {
+		return domain.Cursor{CreatedAt: c.CreatedAt, ID: c.ID}
+	}), nil
 }
 
 func (r *PostgresPhotoRepo) LikeComment(commentID, userID int64) error {
@@ -314,16 +681,19 @@ type PendingPhoto struct {
 	Username    string    `json:"username"`
 }
 
-func (r *PostgresPhotoRepo) GetPendingPhotos() ([]PendingPhoto, error) {
+// GetPendingPhotos возвращает страницу фото на модерации (старые сначала)
+func (r *PostgresPhotoRepo) GetPendingPhotos(limit int, cursor *domain.Cursor) (domain.Page[PendingPhoto], error) {
+	cond, args := keysetCondition("p.", "", cursor, false, nil)
+	args = append(args, limit+1)
 	rows, err := r.db.Query(`
         SELECT p.id, p.user_id, p.url, p.description, p.created_at, u.username
         FROM photos p
         JOIN users u ON p.user_id = u.id
-        WHERE p.is_public = false
-        ORDER BY p.created_at ASC
-    `)
+        WHERE p.is_public = false`+cond+`
+        ORDER BY p.created_at ASC, p.id ASC
+        LIMIT $`+strconv.Itoa(len(args)), args...)
 	if err != nil {
-		return nil, err
+		return domain.Page[PendingPhoto]{}, err
 	}
 	defer rows.Close()
 
@@ -331,11 +701,16 @@ func (r *PostgresPhotoRepo) GetPendingPhotos() ([]PendingPhoto, error) {
 	for rows.Next() {
 		var ph PendingPhoto
 		if err := rows.Scan(&ph.ID, &ph.UserID, &ph.URL, &ph.Description, &ph.CreatedAt, &ph.Username); err != nil {
-			return nil, err
+			return domain.Page[PendingPhoto]{}, err
 		}
 		photos = append(photos, ph)
 	}
-	return photos, nil
+	if err := rows.Err(); err != nil {
+		return domain.Page[PendingPhoto]{}, err
+	}
+	return domain.NewPage(photos, limit, func(ph PendingPhoto) domain.Cursor {
+		return domain.Cursor{CreatedAt: ph.CreatedAt, ID: ph.ID}
+	}), nil
 }
 
 func (r *PostgresPhotoRepo) ApprovePhoto(photoID int64) error {
@@ -343,7 +718,33 @@ func (r *PostgresPhotoRepo) ApprovePhoto(photoID int64) error {
 	return err
 }
 
-func (r *PostgresPhotoRepo) RejectPhoto(photoID int64) error {
-	_, err := r.db.Exec("DELETE FROM photos WHERE id = $1", photoID)
-	return err
+// RejectPhoto удаляет отклонённое фото, сохраняя его хэши в rejected_photos,
+// и сопоставляет их с фото на модерации, чтобы находить повторные загрузки
+// с перцептивным хэшем не дальше maxDistance (см. FindReuploads).
+func (r *PostgresPhotoRepo) RejectPhoto(photoID int64, maxDistance int) error {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	var rejectedID int64
+	err = tx.QueryRow(`
+        INSERT INTO rejected_photos (photo_id, user_id, content_hash, phash, rejected_at)
+        SELECT id, user_id, content_hash, phash, NOW() FROM photos WHERE id = $1
+        RETURNING id
+    `, photoID).Scan(&rejectedID)
+	if err != nil {
+		// фото уже нет – отклонять нечего
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil
+		}
+		return err
+	}
+	if err := matchPending(tx, rejectedID, maxDistance); err != nil {
+		return err
+	}
+	if _, err := tx.Exec("DELETE FROM photos WHERE id = $1", photoID); err != nil {
+		return err
+	}
+	return tx.Commit()
 }
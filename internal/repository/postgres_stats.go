@@ -0,0 +1,137 @@
+package repository
+
+import (
+	"errors"
+	"time"
+
+	"github.com/lib/pq"
+
+	"github.com/freshtea599/PhotoHubServer.git/internal/domain"
+)
+
+// ===================== ПРОСМОТРЫ И СТАТИСТИКА =====================
+
+// SaveViewBuckets записывает дневные агрегаты просмотров и пересчитывает photos.views_count.
+// Значения в бакетах накопительные, поэтому строки перезаписываются, а не суммируются.
+func (r *PostgresPhotoRepo) SaveViewBuckets(buckets []domain.ViewBucket) error {
+	if len(buckets) == 0 {
+		return nil
+	}
+	tx, err := r.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	ids := make([]int64, 0, len(buckets))
+	for _, b := range buckets {
+		// фото могло быть удалено, пока бакет ждал сброса – такие строки просто пропускаются
+		_, err := tx.Exec(`
+            INSERT INTO photo_view_daily (photo_id, day, views, unique_viewers)
+            SELECT $1, $2, $3, $4 WHERE EXISTS (SELECT 1 FROM photos WHERE id = $1)
+            ON CONFLICT (photo_id, day) DO UPDATE
+            SET views = GREATEST(photo_view_daily.views, EXCLUDED.views),
+                unique_viewers = GREATEST(photo_view_daily.unique_viewers, EXCLUDED.unique_viewers)
+        `, b.PhotoID, b.Day, b.Views, b.UniqueViewers)
+		if err != nil {
+			return err
+		}
+		for ref, views := range b.Referrers {
+			_, err := tx.Exec(`
+                INSERT INTO photo_referrer_daily (photo_id, day, referrer, views)
+                SELECT $1, $2, $3, $4 WHERE EXISTS (SELECT 1 FROM photos WHERE id = $1)
+                ON CONFLICT (photo_id, day, referrer) DO UPDATE
+                SET views = GREATEST(photo_referrer_daily.views, EXCLUDED.views)
+            `, b.PhotoID, b.Day, ref, views)
+			if err != nil {
+				return err
+			}
+		}
+		ids = append(ids, b.PhotoID)
+	}
+
+	_, err = tx.Exec(`
+        UPDATE photos p
+        SET views_count = s.total
+        FROM (
+            SELECT photo_id, SUM(views) AS total
+            FROM photo_view_daily
+            WHERE photo_id = ANY($1)
+            GROUP BY photo_id
+        ) s
+        WHERE p.id = s.photo_id
+    `, pq.Array(ids))
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// IsDataError сообщает, что Postgres отверг запись из-за самих данных
+// (класс ошибок 22: слишком длинная строка, недопустимое значение и т.п.),
+// то есть повтор той же записи не поможет.
+func IsDataError(err error) bool {
+	var pqErr *pq.Error
+	return errors.As(err, &pqErr) && pqErr.Code.Class() == "22"
+}
+
+// GetStats возвращает статистику фото за последние days дней (включая сегодня, UTC).
+// Уникальные зрители отдаются только по дням: HLL-счётчики в Redis живут
+// несколько дней, поэтому честно объединить их за весь период нельзя.
+func (r *PostgresPhotoRepo) GetStats(photoID int64, days int) (*domain.PhotoStats, error) {
+	today := time.Now().UTC().Truncate(24 * time.Hour)
+	since := today.AddDate(0, 0, -(days - 1))
+
+	stats := &domain.PhotoStats{PhotoID: photoID, Days: days, Daily: []domain.DailyStat{}, Referrers: []domain.ReferrerStat{}}
+	rows, err := r.db.Query(`
+        SELECT d.day::date, COALESCE(v.views, 0), COALESCE(v.unique_viewers, 0), COALESCE(l.likes, 0)
+        FROM generate_series($2::date, $3::date, interval '1 day') AS d(day)
+        LEFT JOIN photo_view_daily v ON v.photo_id = $1 AND v.day = d.day::date
+        LEFT JOIN (
+            SELECT created_at::date AS day, COUNT(*) AS likes
+            FROM photo_likes
+            WHERE photo_id = $1 AND created_at >= $2
+            GROUP BY created_at::date
+        ) l ON l.day = d.day::date
+        ORDER BY d.day
+    `, photoID, since, today)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var day time.Time
+		var ds domain.DailyStat
+		if err := rows.Scan(&day, &ds.Views, &ds.UniqueViewers, &ds.Likes); err != nil {
+			return nil, err
+		}
+		ds.Date = day.Format(time.DateOnly)
+		stats.Views += ds.Views
+		stats.Likes += ds.Likes
+		stats.Daily = append(stats.Daily, ds)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	refRows, err := r.db.Query(`
+        SELECT referrer, SUM(views) AS views
+        FROM photo_referrer_daily
+        WHERE photo_id = $1 AND day >= $2
+        GROUP BY referrer
+        ORDER BY views DESC, referrer
+        LIMIT 20
+    `, photoID, since)
+	if err != nil {
+		return nil, err
+	}
+	defer refRows.Close()
+	for refRows.Next() {
+		var rs domain.ReferrerStat
+		if err := refRows.Scan(&rs.Referrer, &rs.Views); err != nil {
+			return nil, err
+		}
+		stats.Referrers = append(stats.Referrers, rs)
+	}
+	return stats, refRows.Err()
+}
@@ -0,0 +1,114 @@
+package repository
+
+import (
+	"database/sql"
+	"errors"
+
+	"github.com/freshtea599/PhotoHubServer.git/internal/domain"
+)
+
+// ===================== ВОДЯНЫЕ ЗНАКИ =====================
+
+const watermarkColumns = `id, user_id, photo_id, kind, COALESCE(text, ''), COALESCE(image_path, ''),
+        position, opacity, scale, created_at, updated_at`
+
+func scanWatermark(row rowScanner) (*domain.Watermark, error) {
+	var w domain.Watermark
+	var photoID sql.NullInt64
+	err := row.Scan(&w.ID, &w.UserID, &photoID, &w.Kind, &w.Text, &w.ImagePath,
+		&w.Position, &w.Opacity, &w.Scale, &w.CreatedAt, &w.UpdatedAt)
+	if err != nil {
+		return nil, err
+	}
+	w.PhotoID = photoID.Int64
+	w.HasImage = w.ImagePath != ""
+	return &w, nil
+}
+
+// GetWatermark возвращает настройку пользователя (photoID = 0) или фото.
+// Если настройки нет, возвращается nil, nil.
+func (r *PostgresUserRepo) GetWatermark(userID, photoID int64) (*domain.Watermark, error) {
+	w, err := scanWatermark(r.db.QueryRow(`
+        SELECT `+watermarkColumns+`
+        FROM watermarks
+        WHERE user_id = $1 AND photo_id IS NOT DISTINCT FROM NULLIF($2, 0)
+    `, userID, photoID))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	return w, err
+}
+
+// GetEffectiveWatermark возвращает знак, который действует для фото:
+// настройку фото, а без неё – настройку пользователя по умолчанию.
+// Изображение знака всегда берётся из настройки по умолчанию – там оно
+// загружается. nil, nil – знака нет.
+func (r *PostgresUserRepo) GetEffectiveWatermark(userID, photoID int64) (*domain.Watermark, error) {
+	rows, err := r.db.Query(`
+        SELECT `+watermarkColumns+`
+        FROM watermarks
+        WHERE user_id = $1 AND (photo_id IS NULL OR photo_id = $2)
+    `, userID, photoID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var byDefault, byPhoto *domain.Watermark
+	for rows.Next() {
+		w, err := scanWatermark(rows)
+		if err != nil {
+			return nil, err
+		}
+		if w.PhotoID == 0 {
+			byDefault = w
+		} else {
+			byPhoto = w
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	if byPhoto == nil {
+		return byDefault, nil
+	}
+	if byDefault != nil {
+		byPhoto.ImagePath = byDefault.ImagePath
+		byPhoto.HasImage = byDefault.HasImage
+	}
+	return byPhoto, nil
+}
+
+// SaveWatermark создаёт или обновляет настройку пользователя или фото
+// и заполняет ID и временные метки.
+func (r *PostgresUserRepo) SaveWatermark(w *domain.Watermark) error {
+	conflict := `(user_id) WHERE photo_id IS NULL`
+	if w.PhotoID != 0 {
+		conflict = `(photo_id)`
+	}
+	return r.db.QueryRow(`
+        INSERT INTO watermarks (user_id, photo_id, kind, text, image_path, position, opacity, scale, created_at, updated_at)
+        VALUES ($1, NULLIF($2, 0), $3, NULLIF($4, ''), NULLIF($5, ''), $6, $7, $8, NOW(), NOW())
+        ON CONFLICT `+conflict+` DO UPDATE
+        SET kind = EXCLUDED.kind, text = EXCLUDED.text, image_path = EXCLUDED.image_path,
+            position = EXCLUDED.position, opacity = EXCLUDED.opacity, scale = EXCLUDED.scale,
+            updated_at = NOW()
+        RETURNING id, created_at, updated_at
+    `, w.UserID, w.PhotoID, w.Kind, w.Text, w.ImagePath, w.Position, w.Opacity, w.Scale).
+		Scan(&w.ID, &w.CreatedAt, &w.UpdatedAt)
+}
+
+// DeleteWatermark удаляет настройку пользователя (photoID = 0) или фото
+// и возвращает путь к изображению знака, если оно было.
+func (r *PostgresUserRepo) DeleteWatermark(userID, photoID int64) (string, error) {
+	var imagePath string
+	err := r.db.QueryRow(`
+        DELETE FROM watermarks
+        WHERE user_id = $1 AND photo_id IS NOT DISTINCT FROM NULLIF($2, 0)
+        RETURNING COALESCE(image_path, '')
+    `, userID, photoID).Scan(&imagePath)
+	if errors.Is(err, sql.ErrNoRows) {
+		return "", nil
+	}
+	return imagePath, err
+}
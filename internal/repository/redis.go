@@ -5,11 +5,16 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
+	"log"
+	"strconv"
+	"strings"
 	"time"
 
+	"github.com/google/uuid"
 	"github.com/redis/go-redis/v9"
 
 	"github.com/freshtea599/PhotoHubServer.git/internal/domain"
+	"github.com/freshtea599/PhotoHubServer.git/pkg/transform"
 )
 
 // RedisRepo управляет кэшем метаданных и статусами задач трансформации.
@@ -74,16 +79,18 @@ func (r *RedisRepo) GetJobStatus(ctx context.Context, jobID string) (string, err
 	return val, nil
 }
 
-// CacheVariantKey сохраняет ключ варианта (чтобы знать, есть ли уже готовая копия).
-func (r *RedisRepo) CacheVariantKey(ctx context.Context, photoID int64, sizeName, format string, key string) error {
-	redisKey := fmt.Sprintf("variant:%d:%s:%s", photoID, sizeName, format)
-	return r.client.Set(ctx, redisKey, key, 10*time.Minute).Err()
+// variantKeyTTL – сколько Redis помнит путь к готовому варианту.
+// Источник истины – таблица photo_variants, Redis лишь избавляет от запроса в Postgres.
+const variantKeyTTL = 24 * time.Hour
+
+// CacheVariantKey сохраняет путь к объекту готового варианта.
+func (r *RedisRepo) CacheVariantKey(ctx context.Context, photoID int64, hash, path string) error {
+	return r.client.Set(ctx, fmt.Sprintf("variant:%d:%s", photoID, hash), path, variantKeyTTL).Err()
 }
 
-// GetVariantKey получает ключ варианта из кэша.
-func (r *RedisRepo) GetVariantKey(ctx context.Context, photoID int64, sizeName, format string) (string, error) {
-	redisKey := fmt.Sprintf("variant:%d:%s:%s", photoID, sizeName, format)
-	val, err := r.client.Get(ctx, redisKey).Result()
+// GetVariantKey получает путь к объекту варианта из кэша ("" при промахе).
+func (r *RedisRepo) GetVariantKey(ctx context.Context, photoID int64, hash string) (string, error) {
+	val, err := r.client.Get(ctx, fmt.Sprintf("variant:%d:%s", photoID, hash)).Result()
 	if err != nil {
 		if err == redis.Nil {
 			return "", nil
@@ -92,3 +99,245 @@ func (r *RedisRepo) GetVariantKey(ctx context.Context, photoID int64, sizeName,
 	}
 	return val, nil
 }
+
+// ForgetVariantKey удаляет путь из кэша, если объект по нему оказался недоступен.
+func (r *RedisRepo) ForgetVariantKey(ctx context.Context, photoID int64, hash string) error {
+	return r.client.Del(ctx, fmt.Sprintf("variant:%d:%s", photoID, hash)).Err()
+}
+
+// ForgetVariantKeys удаляет из кэша пути ко всем вариантам фото.
+func (r *RedisRepo) ForgetVariantKeys(ctx context.Context, photoID int64) error {
+	iter := r.client.Scan(ctx, 0, fmt.Sprintf("variant:%d:*", photoID), 100).Iterator()
+	var keys []string
+	for iter.Next(ctx) {
+		keys = append(keys, iter.Val())
+	}
+	if err := iter.Err(); err != nil {
+		return err
+	}
+	if len(keys) == 0 {
+		return nil
+	}
+	return r.client.Del(ctx, keys...).Err()
+}
+
+// watermarkCacheTTL – сколько Redis помнит действующие водяные знаки фото
+// пользователя. Срок отсчитывается от первого запомненного знака, поэтому
+// устаревшее значение живёт не дольше него, даже если сброс разминулся с
+// чтением из Postgres.
+const watermarkCacheTTL = 10 * time.Minute
+
+// CacheWatermark сохраняет действующий водяной знак фото (nil – знака нет).
+// Знаки фото пользователя лежат в одном хэше "watermark:<userID>", чтобы
+// смена знака по умолчанию сбрасывала их разом.
+func (r *RedisRepo) CacheWatermark(ctx context.Context, userID, photoID int64, wm *transform.Watermark) error {
+	data, err := json.Marshal(wm)
+	if err != nil {
+		return fmt.Errorf("marshal watermark: %w", err)
+	}
+	key := fmt.Sprintf("watermark:%d", userID)
+	pipe := r.client.TxPipeline()
+	pipe.HSet(ctx, key, strconv.FormatInt(photoID, 10), data)
+	pipe.ExpireNX(ctx, key, watermarkCacheTTL)
+	_, err = pipe.Exec(ctx)
+	return err
+}
+
+// GetWatermark возвращает запомненный водяной знак фото. found = false –
+// промах кэша; found = true и nil – у фото знака нет.
+func (r *RedisRepo) GetWatermark(ctx context.Context, userID, photoID int64) (wm *transform.Watermark, found bool, err error) {
+	data, err := r.client.HGet(ctx, fmt.Sprintf("watermark:%d", userID), strconv.FormatInt(photoID, 10)).Bytes()
+	if err != nil {
+		if err == redis.Nil {
+			return nil, false, nil
+		}
+		return nil, false, fmt.Errorf("redis get watermark: %w", err)
+	}
+	if err := json.Unmarshal(data, &wm); err != nil {
+		return nil, false, fmt.Errorf("unmarshal watermark: %w", err)
+	}
+	return wm, true, nil
+}
+
+// ForgetWatermarks удаляет из кэша водяные знаки всех фото пользователя.
+func (r *RedisRepo) ForgetWatermarks(ctx context.Context, userID int64) error {
+	return r.client.Del(ctx, fmt.Sprintf("watermark:%d", userID)).Err()
+}
+
+// ReplaceTrending сохраняет новый снимок trending-рейтинга окна и делает его текущим.
+// Снимок хранится как sorted set "trending:<window>:<generation>", указатель на
+// текущий снимок – "trending:<window>". Старые снимки живут ttl, чтобы клиенты
+// могли долистать выдачу по выданному курсору.
+func (r *RedisRepo) ReplaceTrending(ctx context.Context, window domain.TrendingWindow, scores []domain.PhotoScore, ttl time.Duration) (int64, error) {
+	generation := time.Now().UnixMicro()
+	key := fmt.Sprintf("trending:%s:%d", window, generation)
+
+	pipe := r.client.TxPipeline()
+	const batch = 1000
+	for i := 0; i < len(scores); i += batch {
+		end := min(i+batch, len(scores))
+		members := make([]redis.Z, 0, end-i)
+		for _, s := range scores[i:end] {
+			members = append(members, redis.Z{Score: s.Score, Member: s.PhotoID})
+		}
+		pipe.ZAdd(ctx, key, members...)
+	}
+	pipe.Expire(ctx, key, ttl)
+	pipe.Set(ctx, fmt.Sprintf("trending:%s", window), generation, ttl)
+	if _, err := pipe.Exec(ctx); err != nil {
+		return 0, fmt.Errorf("redis replace trending %s: %w", window, err)
+	}
+	return generation, nil
+}
+
+// GetTrending возвращает ID фото из снимка рейтинга в порядке убывания score.
+// generation == 0 означает текущий снимок; возвращается фактически использованное поколение.
+// Если запрошенный снимок уже истёк, возвращается domain.ErrInvalidCursor.
+func (r *RedisRepo) GetTrending(ctx context.Context, window domain.TrendingWindow, generation int64, offset, count int) ([]int64, int64, error) {
+	if generation == 0 {
+		gen, err := r.client.Get(ctx, fmt.Sprintf("trending:%s", window)).Int64()
+		if err != nil {
+			if err == redis.Nil {
+				return nil, 0, nil // рейтинг ещё не посчитан
+			}
+			return nil, 0, fmt.Errorf("redis get trending generation: %w", err)
+		}
+		generation = gen
+	} else {
+		n, err := r.client.Exists(ctx, fmt.Sprintf("trending:%s:%d", window, generation)).Result()
+		if err != nil {
+			return nil, 0, fmt.Errorf("redis check trending snapshot: %w", err)
+		}
+		if n == 0 {
+			return nil, 0, domain.ErrInvalidCursor
+		}
+	}
+
+	key := fmt.Sprintf("trending:%s:%d", window, generation)
+	members, err := r.client.ZRevRange(ctx, key, int64(offset), int64(offset+count-1)).Result()
+	if err != nil {
+		return nil, 0, fmt.Errorf("redis get trending: %w", err)
+	}
+	ids := make([]int64, 0, len(members))
+	for _, m := range members {
+		id, err := strconv.ParseInt(m, 10, 64)
+		if err != nil {
+			continue
+		}
+		ids = append(ids, id)
+	}
+	return ids, generation, nil
+}
+
+// viewKeysTTL – сколько живут дневные счётчики просмотров в Redis.
+// Должно с запасом перекрывать период сброса агрегатов в Postgres.
+const viewKeysTTL = 72 * time.Hour
+
+// RecordView учитывает просмотр фото: общий счётчик, HyperLogLog уникальных
+// зрителей и источник перехода за текущий день (UTC). Бакет помечается
+// «грязным», чтобы фоновая задача перенесла его в Postgres.
+func (r *RedisRepo) RecordView(ctx context.Context, photoID int64, viewerID, referrer string) error {
+	bucket := fmt.Sprintf("%d:%s", photoID, time.Now().UTC().Format(time.DateOnly))
+	pipe := r.client.Pipeline()
+	pipe.Incr(ctx, "views:"+bucket)
+	pipe.Expire(ctx, "views:"+bucket, viewKeysTTL)
+	pipe.PFAdd(ctx, "uv:"+bucket, viewerID)
+	pipe.Expire(ctx, "uv:"+bucket, viewKeysTTL)
+	if referrer != "" {
+		pipe.HIncrBy(ctx, "refs:"+bucket, referrer, 1)
+		pipe.Expire(ctx, "refs:"+bucket, viewKeysTTL)
+	}
+	pipe.SAdd(ctx, "views:dirty", bucket)
+	if _, err := pipe.Exec(ctx); err != nil {
+		return fmt.Errorf("redis record view: %w", err)
+	}
+	return nil
+}
+
+// PopDirtyViewBuckets забирает до count изменившихся дневных бакетов вместе с
+// их текущими (накопительными) значениями. Значения абсолютные, поэтому
+// повторная запись того же бакета в Postgres идемпотентна.
+func (r *RedisRepo) PopDirtyViewBuckets(ctx context.Context, count int64) ([]domain.ViewBucket, error) {
+	members, err := r.client.SPopN(ctx, "views:dirty", count).Result()
+	if err != nil {
+		return nil, fmt.Errorf("redis pop dirty views: %w", err)
+	}
+
+	buckets := make([]domain.ViewBucket, 0, len(members))
+	for i, m := range members {
+		id, day, ok := strings.Cut(m, ":")
+		if !ok {
+			continue
+		}
+		photoID, err := strconv.ParseInt(id, 10, 64)
+		if err != nil {
+			continue
+		}
+		date, err := time.Parse(time.DateOnly, day)
+		if err != nil {
+			continue
+		}
+
+		pipe := r.client.Pipeline()
+		views := pipe.Get(ctx, "views:"+m)
+		unique := pipe.PFCount(ctx, "uv:"+m)
+		refs := pipe.HGetAll(ctx, "refs:"+m)
+		if _, err := pipe.Exec(ctx); err != nil && err != redis.Nil {
+			// возвращаем в очередь этот и все ещё не прочитанные бакеты – они
+			// уже сняты SPOP и иначе не попали бы в Postgres
+			rest := make([]any, 0, len(members)-i)
+			for _, m := range members[i:] {
+				rest = append(rest, m)
+			}
+			if requeueErr := r.client.SAdd(ctx, "views:dirty", rest...).Err(); requeueErr != nil {
+				log.Printf("failed to requeue %d view buckets: %v", len(rest), requeueErr)
+			}
+			return buckets, fmt.Errorf("redis read view bucket %s: %w", m, err)
+		}
+
+		b := domain.ViewBucket{PhotoID: photoID, Day: date, Referrers: map[string]int64{}}
+		b.Views, _ = views.Int64()
+		b.UniqueViewers = unique.Val()
+		for ref, v := range refs.Val() {
+			n, _ := strconv.ParseInt(v, 10, 64)
+			b.Referrers[ref] = n
+		}
+		buckets = append(buckets, b)
+	}
+	return buckets, nil
+}
+
+// RequeueViewBuckets возвращает бакеты в очередь на сброс (например, после ошибки записи в БД).
+func (r *RedisRepo) RequeueViewBuckets(ctx context.Context, buckets []domain.ViewBucket) error {
+	if len(buckets) == 0 {
+		return nil
+	}
+	members := make([]any, 0, len(buckets))
+	for _, b := range buckets {
+		members = append(members, fmt.Sprintf("%d:%s", b.PhotoID, b.Day.Format(time.DateOnly)))
+	}
+	return r.client.SAdd(ctx, "views:dirty", members...).Err()
+}
+
+// unlockScript удаляет ключ блокировки, только если он всё ещё принадлежит владельцу токена.
+var unlockScript = redis.NewScript(`
+if redis.call("GET", KEYS[1]) == ARGV[1] then
+	return redis.call("DEL", KEYS[1])
+end
+return 0`)
+
+// TryLock пытается захватить распределённую блокировку на ttl.
+// Возвращает токен владельца и признак успешного захвата.
+func (r *RedisRepo) TryLock(ctx context.Context, key string, ttl time.Duration) (string, bool, error) {
+	token := uuid.NewString()
+	ok, err := r.client.SetNX(ctx, "lock:"+key, token, ttl).Result()
+	if err != nil {
+		return "", false, fmt.Errorf("redis lock %s: %w", key, err)
+	}
+	return token, ok, nil
+}
+
+// Unlock освобождает блокировку, если она ещё принадлежит владельцу token.
+func (r *RedisRepo) Unlock(ctx context.Context, key, token string) error {
+	return unlockScript.Run(ctx, r.client, []string{"lock:" + key}, token).Err()
+}
@@ -0,0 +1,80 @@
+package http
+
+import (
+	"log"
+	"net/http"
+	"strconv"
+
+	"github.com/labstack/echo/v4"
+
+	"github.com/freshtea599/PhotoHubServer.git/internal/domain"
+	"github.com/freshtea599/PhotoHubServer.git/pkg/phash"
+)
+
+const (
+	// nearDuplicatesLimit – сколько похожих фото показывать в предупреждении
+	// после загрузки (см. GetProcessingStatus).
+	nearDuplicatesLimit = 10
+	// duplicatesScanLimit – сколько последних фото пользователя сравнивает
+	// GetMyDuplicates.
+	duplicatesScanLimit = 5000
+)
+
+// GetMyDuplicates группирует последние duplicatesScanLimit фото пользователя
+// в группы почти-дубликатов по расстоянию Хэмминга между перцептивными
+// хэшами. max_distance (0..32) переопределяет порог DUPLICATE_MAX_DISTANCE.
+// Фото, хэш которых ещё не посчитан, не учитываются.
+func (h *Handlers) GetMyDuplicates(c echo.Context) error {
+	userID, ok := getUserID(c)
+	if !ok {
+		return c.JSON(http.StatusUnauthorized, map[string]string{"error": "unauthorized"})
+	}
+	maxDistance := h.cfg.DuplicateMaxDistance
+	if d := c.QueryParam("max_distance"); d != "" {
+		var err error
+		if maxDistance, err = strconv.Atoi(d); err != nil || maxDistance < 0 || maxDistance > 32 {
+			return c.JSON(http.StatusBadRequest, map[string]string{"error": "invalid max_distance. must be between 0 and 32"})
+		}
+	}
+
+	photos, hashes, err := h.photoRepo.ListHashedByUser(userID, duplicatesScanLimit+1)
+	if err != nil {
+		log.Printf("List hashed photos error: %v", err)
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "failed to fetch photos"})
+	}
+	report := domain.DuplicateReport{Groups: []domain.DuplicateGroup{}}
+	if len(photos) > duplicatesScanLimit {
+		photos, hashes = photos[:duplicatesScanLimit], hashes[:duplicatesScanLimit]
+		report.Truncated = true
+	}
+	report.Scanned = len(photos)
+	for _, idx := range phash.Group(hashes, maxDistance) {
+		group := domain.DuplicateGroup{Photos: make([]domain.DuplicatePhoto, 0, len(idx))}
+		for n, i := range idx {
+			p := photos[i]
+			p.Distance = hashes[i].Distance(hashes[idx[0]])
+			group.Photos = append(group.Photos, p)
+			for _, j := range idx[:n] {
+				group.MaxDistance = max(group.MaxDistance, hashes[i].Distance(hashes[j]))
+			}
+		}
+		report.Groups = append(report.Groups, group)
+	}
+	return c.JSON(http.StatusOK, report)
+}
+
+// GetReuploads возвращает фото на модерации, похожие на ранее отклонённые
+// (тот же файл или перцептивный хэш в пределах DUPLICATE_MAX_DISTANCE на
+// момент анализа фото или отклонения).
+func (h *Handlers) GetReuploads(c echo.Context) error {
+	limit, cursor, err := parsePageParams(c, 50)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "invalid cursor"})
+	}
+	page, err := h.photoRepo.FindReuploads(limit, cursor)
+	if err != nil {
+		log.Printf("Find reuploads error: %v", err)
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "failed to fetch reuploads"})
+	}
+	return c.JSON(http.StatusOK, page)
+}
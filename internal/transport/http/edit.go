@@ -0,0 +1,123 @@
+package http
+
+import (
+	"log"
+	"net/http"
+	"strconv"
+
+	"github.com/labstack/echo/v4"
+
+	"github.com/freshtea599/PhotoHubServer.git/internal/domain"
+	"github.com/freshtea599/PhotoHubServer.git/pkg/transform"
+)
+
+// GetPhotoEdits возвращает владельцу текущую версию правки фото и историю правок.
+func (h *Handlers) GetPhotoEdits(c echo.Context) error {
+	userID, ok := getUserID(c)
+	if !ok {
+		return c.JSON(http.StatusUnauthorized, map[string]string{"error": "unauthorized"})
+	}
+	photoID, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "invalid photo id"})
+	}
+	photo, err := h.photoRepo.GetByID(photoID)
+	if err != nil || photo.UserID != userID {
+		return c.JSON(http.StatusForbidden, map[string]string{"error": "access denied"})
+	}
+	edits, err := h.photoRepo.ListEdits(photoID)
+	if err != nil {
+		log.Printf("List edits error: %v", err)
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "failed to fetch edits"})
+	}
+	return c.JSON(http.StatusOK, domain.PhotoEditHistory{PhotoID: photo.ID, Current: photo.EditVersion, Edits: edits})
+}
+
+// PutPhotoEdit сохраняет новую правку фото – обрезку в координатах оригинала
+// и коррекции (см. transform.Edit) – следующей версией. Пустая правка
+// возвращает фото к оригиналу.
+func (h *Handlers) PutPhotoEdit(c echo.Context) error {
+	userID, ok := getUserID(c)
+	if !ok {
+		return c.JSON(http.StatusUnauthorized, map[string]string{"error": "unauthorized"})
+	}
+	photoID, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "invalid photo id"})
+	}
+	var edit transform.Edit
+	if err := c.Bind(&edit); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "invalid request"})
+	}
+	photo, err := h.photoRepo.GetByID(photoID)
+	if err != nil || photo.UserID != userID {
+		return c.JSON(http.StatusForbidden, map[string]string{"error": "access denied"})
+	}
+
+	edit.Normalize()
+	if err := edit.Validate(photo.Width, photo.Height); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
+	}
+	if edit.IsZero() {
+		return h.saveEdit(c, photo, nil)
+	}
+	return h.saveEdit(c, photo, &edit)
+}
+
+// RevertPhotoEdit возвращает фото к версии правки :version (0 – оригинал).
+// История не переписывается: возврат сохраняется новой версией.
+func (h *Handlers) RevertPhotoEdit(c echo.Context) error {
+	userID, ok := getUserID(c)
+	if !ok {
+		return c.JSON(http.StatusUnauthorized, map[string]string{"error": "unauthorized"})
+	}
+	photoID, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "invalid photo id"})
+	}
+	version, err := strconv.Atoi(c.Param("version"))
+	if err != nil || version < 0 {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "invalid version"})
+	}
+	photo, err := h.photoRepo.GetByID(photoID)
+	if err != nil || photo.UserID != userID {
+		return c.JSON(http.StatusForbidden, map[string]string{"error": "access denied"})
+	}
+	if version == 0 {
+		return h.saveEdit(c, photo, nil)
+	}
+	prev, err := h.photoRepo.GetEdit(photoID, version)
+	if err != nil {
+		log.Printf("Get edit error: %v", err)
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "failed to fetch edit"})
+	}
+	if prev == nil {
+		return c.JSON(http.StatusNotFound, map[string]string{"error": "edit version not found"})
+	}
+	return h.saveEdit(c, photo, prev.Edit)
+}
+
+// saveEdit делает edit текущей правкой фото, удаляет варианты прежней правки
+// и в фоне заново считает BlurHash и предгенерирует варианты. Клиенты,
+// кэширующие варианты как immutable, получают новые по параметру v=edit_version
+// в URL (см. GetSrcset).
+func (h *Handlers) saveEdit(c echo.Context, photo *domain.Photo, edit *transform.Edit) error {
+	version, err := h.photoRepo.SaveEdit(photo.ID, edit)
+	if err != nil {
+		log.Printf("Save edit error: %v", err)
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "failed to save edit"})
+	}
+	if h.imageProcessor != nil {
+		if err := h.imageProcessor.InvalidateVariants(c.Request().Context(), photo.ID); err != nil {
+			log.Printf("Failed to invalidate variants of photo %d: %v", photo.ID, err)
+		}
+		edited := *photo
+		edited.Edit = edit
+		h.imageProcessor.PregenerateVariants(photo.ID, version, h.eagerVariants(c.Request().Context(), &edited))
+	}
+	updated, err := h.photoRepo.GetByID(photo.ID)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "failed to fetch photo"})
+	}
+	return c.JSON(http.StatusOK, updated)
+}
@@ -2,20 +2,22 @@ package http
 
 import (
 	"bytes"
+	"context"
 	"crypto/sha256"
 	"database/sql"
+	"encoding/hex"
+	"errors"
 	"fmt"
-	"image"
-	_ "image/jpeg"
-	_ "image/png"
 	"io"
 	"log"
+	"math"
+	"mime/multipart"
 	"net/http"
-	"path/filepath"
+	"net/url"
 	"strconv"
 	"strings"
+	"time"
 
-	"github.com/buckket/go-blurhash"
 	"github.com/google/uuid"
 	"github.com/labstack/echo/v4"
 
@@ -24,6 +26,8 @@ import (
 	"github.com/freshtea599/PhotoHubServer.git/internal/domain"
 	"github.com/freshtea599/PhotoHubServer.git/internal/repository"
 	"github.com/freshtea599/PhotoHubServer.git/internal/usecase"
+	"github.com/freshtea599/PhotoHubServer.git/pkg/imagetype"
+	"github.com/freshtea599/PhotoHubServer.git/pkg/transform"
 )
 
 type Handlers struct {
@@ -32,6 +36,7 @@ type Handlers struct {
 	userRepo       *repository.PostgresUserRepo
 	photoRepo      *repository.PostgresPhotoRepo
 	minioRepo      *repository.MinioRepo
+	redisRepo      *repository.RedisRepo
 	imageProcessor *usecase.ImageProcessor
 }
 
@@ -41,6 +46,7 @@ func NewHandlers(
 	userRepo *repository.PostgresUserRepo,
 	photoRepo *repository.PostgresPhotoRepo,
 	minioRepo *repository.MinioRepo,
+	redisRepo *repository.RedisRepo,
 	imgProc *usecase.ImageProcessor,
 ) *Handlers {
 	return &Handlers{
@@ -49,6 +55,7 @@ func NewHandlers(
 		userRepo:       userRepo,
 		photoRepo:      photoRepo,
 		minioRepo:      minioRepo,
+		redisRepo:      redisRepo,
 		imageProcessor: imgProc,
 	}
 }
@@ -62,6 +69,27 @@ func getUserID(c echo.Context) (int64, bool) {
 	return id, ok && id > 0
 }
 
+// allowedImageTypes – MIME-типы, которые принимаются при загрузке и допустимы в фильтре ленты.
+var allowedImageTypes = map[string]bool{
+	imagetype.JPEG: true, imagetype.PNG: true, imagetype.WebP: true, imagetype.GIF: true,
+	imagetype.TIFF: true, imagetype.HEIC: true, imagetype.HEIF: true, imagetype.JXL: true,
+}
+
+// parsePageParams читает limit и cursor из query-параметров списочных эндпоинтов.
+func parsePageParams(c echo.Context, defaultLimit int) (int, *domain.Cursor, error) {
+	limit := defaultLimit
+	if l := c.QueryParam("limit"); l != "" {
+		if v, err := strconv.Atoi(l); err == nil && v > 0 && v <= 100 {
+			limit = v
+		}
+	}
+	cursor, err := domain.DecodeCursor(c.QueryParam("cursor"))
+	if err != nil {
+		return 0, nil, err
+	}
+	return limit, cursor, nil
+}
+
 // ---------- Auth ----------
 func (h *Handlers) Register(c echo.Context) error {
 	var req domain.RegisterRequest
@@ -114,110 +142,471 @@ func (h *Handlers) GetMe(c echo.Context) error {
 }
 
 // ---------- Photos ----------
+
+// Ограничения загрузки фото.
+const (
+	maxUploadSize    = 50 << 20  // максимальный размер файла
+	maxFormValueSize = 64 << 10  // максимальный размер текстового поля формы
+	uploadHeadSize   = 512 << 10 // начало файла: формат, размеры из заголовка
+	uploadScanSize   = 64 << 10  // конец файла, проверяемый на полиглот
+)
+
+// uploadError – отказ в загрузке с машиночитаемым кодом для клиента.
+type uploadError struct {
+	status int
+	code   string
+	msg    string
+}
+
+func (e *uploadError) Error() string { return e.msg }
+
+var (
+	errUploadTooLarge = &uploadError{http.StatusRequestEntityTooLarge, "file_too_large", "photo size must not exceed 50MB"}
+	errUploadEmpty    = &uploadError{http.StatusBadRequest, "file_required", "photo file is required"}
+	errUploadType     = &uploadError{http.StatusUnsupportedMediaType, "unsupported_format", "invalid image format. allowed: jpeg, png, webp, gif, tiff, heic, heif, jxl"}
+	errUploadCorrupt  = &uploadError{http.StatusUnprocessableEntity, "corrupt_image", "image is corrupt or truncated"}
+	errUploadPolyglot = &uploadError{http.StatusUnprocessableEntity, "polyglot_file", "file contains non-image content"}
+)
+
+// uploadErrorResponse отвечает на отказ в загрузке кодом и сообщением.
+func uploadErrorResponse(c echo.Context, e *uploadError) error {
+	return c.JSON(e.status, map[string]string{"error": e.msg, "code": e.code})
+}
+
+// storedUpload – оригинал, загруженный в MinIO потоково.
+type storedUpload struct {
+	objectKey   string
+	mimeType    string
+	size        int64
+	contentHash string
+	width       int
+	height      int
+}
+
+// UploadPhoto принимает multipart-форму потоково: файл передаётся в MinIO по
+// мере чтения тела запроса, поэтому память на запрос ограничена началом файла
+// и буфером одной части загрузки, а не размером фото. Ответ содержит
+// processing_url – по нему клиент узнаёт о готовности вариантов и похожих фото.
 func (h *Handlers) UploadPhoto(c echo.Context) error {
 	userID, ok := getUserID(c)
 	if !ok {
 		return c.JSON(http.StatusUnauthorized, map[string]string{"error": "unauthorized"})
 	}
 
-	file, err := c.FormFile("photo")
+	ctx := c.Request().Context()
+	// запас сверх размера файла – на остальные поля формы и заголовки частей
+	c.Request().Body = http.MaxBytesReader(c.Response(), c.Request().Body, maxUploadSize+1<<20)
+	mr, err := c.Request().MultipartReader()
 	if err != nil {
-		return c.JSON(http.StatusBadRequest, map[string]string{"error": "photo file is required"})
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "multipart form expected"})
 	}
-	if file.Size > 50*1024*1024 {
-		return c.JSON(http.StatusBadRequest, map[string]string{"error": "photo size must not exceed 50MB"})
+
+	var (
+		upload      *storedUpload
+		description string
+		isPublic    bool
+	)
+	// при любой ошибке после загрузки оригинала он удаляется
+	committed := false
+	defer func() {
+		if upload != nil && !committed {
+			_ = h.minioRepo.DeleteOriginal(context.Background(), upload.objectKey)
+		}
+	}()
+
+	for {
+		part, err := mr.NextPart()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			var maxErr *http.MaxBytesError
+			if errors.As(err, &maxErr) {
+				return uploadErrorResponse(c, errUploadTooLarge)
+			}
+			return c.JSON(http.StatusBadRequest, map[string]string{"error": "malformed multipart body"})
+		}
+		switch part.FormName() {
+		case "photo":
+			if upload != nil {
+				part.Close()
+				return c.JSON(http.StatusBadRequest, map[string]string{"error": "only one photo per request"})
+			}
+			upload, err = h.storeUpload(ctx, part)
+		case "description":
+			description, err = readFormValue(part)
+		case "is_public":
+			var v string
+			v, err = readFormValue(part)
+			isPublic = v == "true"
+		}
+		part.Close()
+		if err != nil {
+			var upErr *uploadError
+			if errors.As(err, &upErr) {
+				return uploadErrorResponse(c, upErr)
+			}
+			var maxErr *http.MaxBytesError
+			if errors.As(err, &maxErr) {
+				return uploadErrorResponse(c, errUploadTooLarge)
+			}
+			log.Printf("Upload error: %v", err)
+			return c.JSON(http.StatusInternalServerError, map[string]string{"error": "failed to store file"})
+		}
 	}
-	allowedTypes := map[string]bool{"image/jpeg": true, "image/png": true, "image/webp": true}
-	mimeType := file.Header.Get("Content-Type")
-	if !allowedTypes[mimeType] {
-		return c.JSON(http.StatusBadRequest, map[string]string{"error": "invalid image format. allowed: jpeg, png, webp"})
+	if upload == nil {
+		return uploadErrorResponse(c, errUploadEmpty)
 	}
 
-	src, err := file.Open()
-	if err != nil {
-		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "failed to open file"})
+	photo := &domain.Photo{
+		UserID:      userID,
+		URL:         "/media/originals/" + upload.objectKey,
+		FilePath:    upload.objectKey,
+		FileSize:    sql.NullInt64{Int64: upload.size, Valid: true},
+		MimeType:    upload.mimeType,
+		Description: description,
+		IsPublic:    isPublic,
+		ContentHash: upload.contentHash,
+		Width:       upload.width, // с учётом EXIF-ориентации (0 – определятся при анализе); кадры уточняются после анализа
+		Height:      upload.height,
+		FrameCount:  1,
+
+		ProcessingStatus: domain.ProcessingPending,
 	}
-	defer src.Close()
 
-	fileBytes, err := io.ReadAll(src)
+	savedPhoto, err := h.photoRepo.Create(photo)
 	if err != nil {
-		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "failed to read file"})
+		log.Printf("DB create error: %v", err)
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "failed to save metadata"})
 	}
+	committed = true
 
-	// Вычисляем ширину, высоту и BlurHash
-	var width, height int
-	var blurHashStr string
-	img, _, decodeErr := image.Decode(bytes.NewReader(fileBytes))
-	if decodeErr == nil {
-		bounds := img.Bounds()
-		width, height = bounds.Dx(), bounds.Dy()
-		blurHashStr, _ = blurhash.Encode(4, 3, img)
-	} else {
-		log.Printf("Warning: failed to decode image for dimensions/blurhash: %v", decodeErr)
+	// заглушки, палитра, перцептивный хэш и варианты считаются в фоне, чтобы не
+	// декодировать оригинал в обработчике; похожие фото сообщает GetProcessingStatus
+	if h.imageProcessor != nil {
+		h.imageProcessor.PregenerateVariants(savedPhoto.ID, savedPhoto.EditVersion, h.eagerVariants(ctx, savedPhoto))
 	}
 
-	ext := strings.ToLower(filepath.Ext(file.Filename))
-	if ext == "" {
-		switch mimeType {
-		case "image/jpeg":
-			ext = ".jpg"
-		case "image/png":
-			ext = ".png"
-		case "image/webp":
-			ext = ".webp"
+	return c.JSON(http.StatusCreated, domain.UploadResult{
+		Photo:         savedPhoto,
+		ProcessingURL: fmt.Sprintf("/api/photos/%d/processing", savedPhoto.ID),
+	})
+}
+
+// storeUpload потоково загружает файл из части формы в MinIO, попутно считая
+// его размер и sha256. Формат определяется по сигнатуре файла, а не по
+// Content-Type клиента или расширению. До загрузки размеры читаются из
+// заголовка и сверяются с лимитами, чтобы маленький файл с огромным объявленным
+// разрешением не дошёл до декодирования. Если заголовок лежит дальше начала
+// файла (IFD в конце TIFF, meta после mdat в HEIF), размеры определит и
+// сверит с лимитами libvips при анализе. Полиглоты отсекаются по метаданным
+// в начале файла и данным после конца изображения.
+func (h *Handlers) storeUpload(ctx context.Context, part *multipart.Part) (*storedUpload, error) {
+	head := make([]byte, uploadHeadSize)
+	n, err := io.ReadFull(part, head)
+	if err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
+		return nil, err
+	}
+	if n == 0 {
+		return nil, errUploadEmpty
+	}
+	head = head[:n]
+
+	mimeType := imagetype.Detect(head)
+	if !allowedImageTypes[mimeType] {
+		return nil, errUploadType
+	}
+	width, height, err := imagetype.DisplayDimensions(head, mimeType)
+	switch {
+	case err == nil:
+		if err := h.checkSourceLimits(width, height); err != nil {
+			return nil, err
 		}
+	case errors.Is(err, imagetype.ErrHeaderBeyond) && len(head) == uploadHeadSize:
+		width, height = 0, 0
+	default:
+		return nil, errUploadCorrupt
+	}
+	upload := &storedUpload{
+		mimeType:  mimeType,
+		objectKey: uuid.New().String() + imagetype.Extension(mimeType),
+		width:     width,
+		height:    height,
 	}
-	objectKey := uuid.New().String() + ext
 
-	err = h.minioRepo.PutOriginal(c.Request().Context(), objectKey, bytes.NewReader(fileBytes), file.Size, mimeType)
+	hasher := sha256.New()
+	tail := &tailBuffer{size: uploadScanSize}
+	body := &limitedReader{r: io.MultiReader(bytes.NewReader(head), part), remaining: maxUploadSize}
+	if err := h.minioRepo.PutOriginal(ctx, upload.objectKey, io.TeeReader(body, io.MultiWriter(hasher, tail)), -1, mimeType); err != nil {
+		// клиент мог получить часть объекта – удаляем на всякий случай
+		_ = h.minioRepo.DeleteOriginal(context.Background(), upload.objectKey)
+		if body.exceeded {
+			return nil, errUploadTooLarge
+		}
+		return nil, err
+	}
+	upload.size = maxUploadSize - body.remaining
+	if imagetype.Polyglot(mimeType, head, tail.buf, upload.size) {
+		_ = h.minioRepo.DeleteOriginal(context.Background(), upload.objectKey)
+		return nil, errUploadPolyglot
+	}
+	upload.contentHash = hex.EncodeToString(hasher.Sum(nil))
+	return upload, nil
+}
+
+// checkSourceLimits сверяет размеры из заголовка с лимитами исходника.
+// Для анимации площадь всех кадров проверит libvips при обработке.
+func (h *Handlers) checkSourceLimits(width, height int) error {
+	if limit := h.cfg.ImageMaxSourceDimension; limit > 0 && (width > limit || height > limit) {
+		return &uploadError{http.StatusUnprocessableEntity, "dimensions_too_large",
+			fmt.Sprintf("image dimensions %dx%d exceed the limit of %d pixels per side", width, height, limit)}
+	}
+	if limit := h.cfg.ImageMaxPixels; limit > 0 && width*height > limit {
+		return &uploadError{http.StatusUnprocessableEntity, "megapixels_too_large",
+			fmt.Sprintf("image area %dx%d exceeds the limit of %d megapixels", width, height, limit/1_000_000)}
+	}
+	return nil
+}
+
+// tailBuffer хранит последние size байт записанного потока.
+type tailBuffer struct {
+	buf  []byte
+	size int
+}
+
+func (t *tailBuffer) Write(p []byte) (int, error) {
+	n := len(p)
+	if len(p) >= t.size {
+		t.buf = append(t.buf[:0], p[len(p)-t.size:]...)
+		return n, nil
+	}
+	if over := len(t.buf) + len(p) - t.size; over > 0 {
+		t.buf = append(t.buf[:0], t.buf[over:]...)
+	}
+	t.buf = append(t.buf, p...)
+	return n, nil
+}
+
+// limitedReader отдаёт не больше remaining байт и возвращает ошибку,
+// если поток длиннее – в отличие от io.LimitReader, который молча обрезает.
+type limitedReader struct {
+	r         io.Reader
+	remaining int64
+	exceeded  bool
+}
+
+func (l *limitedReader) Read(p []byte) (int, error) {
+	if l.remaining <= 0 {
+		// проверяем, что поток действительно закончился
+		var probe [1]byte
+		if n, _ := l.r.Read(probe[:]); n > 0 {
+			l.exceeded = true
+			return 0, errUploadTooLarge
+		}
+		return 0, io.EOF
+	}
+	if int64(len(p)) > l.remaining {
+		p = p[:l.remaining]
+	}
+	n, err := l.r.Read(p)
+	l.remaining -= int64(n)
+	return n, err
+}
+
+// readFormValue читает текстовое поле формы с ограничением размера.
+func readFormValue(part *multipart.Part) (string, error) {
+	data, err := io.ReadAll(io.LimitReader(part, maxFormValueSize))
 	if err != nil {
-		log.Printf("MinIO put error: %v", err)
-		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "failed to store file"})
+		return "", err
 	}
+	return string(data), nil
+}
 
-	hash := fmt.Sprintf("%x", sha256.Sum256(fileBytes))
-	photo := &domain.Photo{
-		UserID:      userID,
-		URL:         "/media/originals/" + objectKey,
-		FilePath:    objectKey,
-		FileSize:    sql.NullInt64{Int64: file.Size, Valid: true},
-		MimeType:    mimeType,
-		Description: c.FormValue("description"),
-		IsPublic:    c.FormValue("is_public") == "true",
-		BlurHash:    blurHashStr,
-		ContentHash: hash,
-		Width:       width,
-		Height:      height,
+func (h *Handlers) ListPhotos(c echo.Context) error {
+	filter, err := parsePhotoFilter(c)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
+	}
+	limit, cursor, err := parsePageParams(c, 20)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "invalid cursor"})
+	}
+	if filter.Sort == domain.SortTrending && !filter.HasConditions() {
+		return h.trendingPage(c, domain.TrendingWeek, limit, cursor)
 	}
+	page, err := h.photoRepo.ListPublic(filter, limit, cursor)
+	if err != nil {
+		if errors.Is(err, domain.ErrInvalidCursor) {
+			return c.JSON(http.StatusBadRequest, map[string]string{"error": "invalid cursor"})
+		}
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "failed to fetch photos"})
+	}
+	return c.JSON(http.StatusOK, page)
+}
 
-	savedPhoto, err := h.photoRepo.Create(photo)
+// parsePhotoFilter разбирает и валидирует параметры сортировки и фильтрации ленты.
+func parsePhotoFilter(c echo.Context) (domain.PhotoFilter, error) {
+	filter := domain.PhotoFilter{Sort: domain.SortRecent}
+
+	switch sort := domain.PhotoSort(c.QueryParam("sort")); sort {
+	case "":
+	case domain.SortRecent, domain.SortPopular, domain.SortMostCommented, domain.SortTrending, domain.SortTopWeek:
+		filter.Sort = sort
+	default:
+		return filter, errors.New("invalid sort. allowed: recent, popular, most_commented, trending, top_week")
+	}
+
+	switch o := domain.Orientation(c.QueryParam("orientation")); o {
+	case "":
+	case domain.OrientationLandscape, domain.OrientationPortrait, domain.OrientationSquare:
+		filter.Orientation = o
+	default:
+		return filter, errors.New("invalid orientation. allowed: landscape, portrait, square")
+	}
+
+	var err error
+	if filter.MinWidth, err = parseDimension(c.QueryParam("min_width")); err != nil {
+		return filter, errors.New("invalid min_width")
+	}
+	if filter.MinHeight, err = parseDimension(c.QueryParam("min_height")); err != nil {
+		return filter, errors.New("invalid min_height")
+	}
+
+	if m := c.QueryParam("mime"); m != "" {
+		for _, mt := range strings.Split(m, ",") {
+			mt = strings.TrimSpace(strings.ToLower(mt))
+			if !allowedImageTypes[mt] {
+				return filter, fmt.Errorf("invalid mime type %q", mt)
+			}
+			filter.MimeTypes = append(filter.MimeTypes, mt)
+		}
+	}
+
+	if filter.From, err = parseDateParam(c.QueryParam("from"), false); err != nil {
+		return filter, errors.New("invalid from date. use YYYY-MM-DD or RFC3339")
+	}
+	if filter.To, err = parseDateParam(c.QueryParam("to"), true); err != nil {
+		return filter, errors.New("invalid to date. use YYYY-MM-DD or RFC3339")
+	}
+	if !filter.From.IsZero() && !filter.To.IsZero() && !filter.From.Before(filter.To) {
+		return filter, errors.New("from must be earlier than to")
+	}
+
+	if u := c.QueryParam("user_id"); u != "" {
+		if filter.UserID, err = strconv.ParseInt(u, 10, 64); err != nil || filter.UserID <= 0 {
+			return filter, errors.New("invalid user_id")
+		}
+	}
+
+	// color=#RRGGBB (# в URL кодируется как %23, его можно опустить) –
+	// фото с близким цветом в палитре; tolerance – допуск ΔE
+	if col := c.QueryParam("color"); col != "" {
+		rgb, err := transform.ParseColor(col)
+		if err != nil {
+			return filter, errors.New("invalid color. use RRGGBB")
+		}
+		lab := rgb.Lab()
+		filter.Color = &lab
+		filter.ColorTolerance = domain.DefaultColorTolerance
+	}
+	if t := c.QueryParam("tolerance"); t != "" {
+		if filter.Color == nil {
+			return filter, errors.New("tolerance requires color")
+		}
+		filter.ColorTolerance, err = strconv.ParseFloat(t, 64)
+		if err != nil || !(filter.ColorTolerance > 0 && filter.ColorTolerance <= domain.MaxColorTolerance) {
+			return filter, fmt.Errorf("invalid tolerance. must be between 0 and %g", domain.MaxColorTolerance)
+		}
+	}
+	return filter, nil
+}
+
+// parseDimension разбирает необязательный размер в пикселях (0 – не задан).
+func parseDimension(v string) (int, error) {
+	if v == "" {
+		return 0, nil
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil || n < 0 || n > 100000 {
+		return 0, errors.New("invalid dimension")
+	}
+	return n, nil
+}
+
+// parseDateParam разбирает дату в формате YYYY-MM-DD или RFC3339.
+// Для верхней границы (endOfDay) дата без времени включает весь день.
+func parseDateParam(v string, endOfDay bool) (time.Time, error) {
+	if v == "" {
+		return time.Time{}, nil
+	}
+	if t, err := time.Parse(time.RFC3339, v); err == nil {
+		return t.UTC(), nil
+	}
+	t, err := time.Parse(time.DateOnly, v)
 	if err != nil {
-		_ = h.minioRepo.DeleteOriginal(c.Request().Context(), objectKey)
-		log.Printf("DB create error: %v", err)
-		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "failed to save metadata"})
+		return time.Time{}, err
+	}
+	if endOfDay {
+		t = t.AddDate(0, 0, 1)
 	}
+	return t, nil
+}
 
-	return c.JSON(http.StatusCreated, savedPhoto)
+// GetTrendingPhotos отдаёт trending-рейтинг из снимка в Redis.
+// Курсор фиксирует снимок и смещение, поэтому пересчёт рейтинга во время
+// листания не приводит к дублям и пропускам.
+func (h *Handlers) GetTrendingPhotos(c echo.Context) error {
+	window := domain.TrendingWindow(c.QueryParam("window"))
+	if window == "" {
+		window = domain.TrendingDay
+	}
+	if window.Period() == 0 {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "invalid window. allowed: day, week, month"})
+	}
+	limit, cursor, err := parsePageParams(c, 20)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "invalid cursor"})
+	}
+	return h.trendingPage(c, window, limit, cursor)
 }
 
-func (h *Handlers) ListPhotos(c echo.Context) error {
-	limit := 20
+// trendingPage отдаёт страницу trending-рейтинга окна window.
+func (h *Handlers) trendingPage(c echo.Context, window domain.TrendingWindow, limit int, cursor *domain.Cursor) error {
+	sortKey := "trending_" + string(window)
+	var generation int64
 	offset := 0
-	if l := c.QueryParam("limit"); l != "" {
-		if v, err := strconv.Atoi(l); err == nil && v > 0 && v <= 100 {
-			limit = v
+	if cursor != nil {
+		if cursor.Sort != sortKey || cursor.Value < 0 {
+			return c.JSON(http.StatusBadRequest, map[string]string{"error": "invalid cursor"})
 		}
+		generation, offset = cursor.CreatedAt.UnixMicro(), int(cursor.Value)
 	}
-	if o := c.QueryParam("offset"); o != "" {
-		if v, err := strconv.Atoi(o); err == nil && v >= 0 {
-			offset = v
+
+	ids, generation, err := h.redisRepo.GetTrending(c.Request().Context(), window, generation, offset, limit+1)
+	if err != nil {
+		if errors.Is(err, domain.ErrInvalidCursor) {
+			return c.JSON(http.StatusBadRequest, map[string]string{"error": "cursor expired"})
 		}
+		log.Printf("trending fetch error: %v", err)
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "failed to fetch trending photos"})
 	}
-	photos, err := h.photoRepo.ListPublic(limit, offset)
+
+	page := domain.Page[*domain.Photo]{Items: []*domain.Photo{}}
+	if len(ids) > limit {
+		ids = ids[:limit]
+		page.NextCursor = domain.Cursor{
+			Sort:      sortKey,
+			Value:     int64(offset + limit),
+			CreatedAt: time.UnixMicro(generation),
+			ID:        ids[limit-1],
+		}.Encode()
+	}
+	photos, err := h.photoRepo.GetByIDs(ids)
 	if err != nil {
 		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "failed to fetch photos"})
 	}
-	return c.JSON(http.StatusOK, photos)
+	page.Items = photos
+	return c.JSON(http.StatusOK, page)
 }
 
 func (h *Handlers) GetMyPhotos(c echo.Context) error {
@@ -225,23 +614,150 @@ func (h *Handlers) GetMyPhotos(c echo.Context) error {
 	if !ok {
 		return c.JSON(http.StatusUnauthorized, map[string]string{"error": "unauthorized"})
 	}
-	limit := 50
-	offset := 0
-	if l := c.QueryParam("limit"); l != "" {
-		if v, err := strconv.Atoi(l); err == nil && v > 0 && v <= 100 {
-			limit = v
+	limit, cursor, err := parsePageParams(c, 50)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "invalid cursor"})
+	}
+	page, err := h.photoRepo.ListByUser(userID, limit, cursor)
+	if err != nil {
+		if errors.Is(err, domain.ErrInvalidCursor) {
+			return c.JSON(http.StatusBadRequest, map[string]string{"error": "invalid cursor"})
+		}
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "failed to fetch photos"})
+	}
+	return c.JSON(http.StatusOK, page)
+}
+
+// GetPhoto возвращает метаданные фото для страницы просмотра и учитывает просмотр.
+// Приватные фото доступны только владельцу.
+func (h *Handlers) GetPhoto(c echo.Context) error {
+	photoID, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil || photoID <= 0 {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "invalid photo id"})
+	}
+	photo, err := h.photoRepo.GetByID(photoID)
+	if err != nil {
+		return c.JSON(http.StatusNotFound, map[string]string{"error": "photo not found"})
+	}
+	userID, _ := getUserID(c)
+	if !photo.IsPublic && photo.UserID != userID {
+		return c.JSON(http.StatusNotFound, map[string]string{"error": "photo not found"})
+	}
+	h.recordView(c, photo)
+	return c.JSON(http.StatusOK, photo)
+}
+
+// GetProcessingStatus отдаёт владельцу прогресс предгенерации вариантов фото.
+func (h *Handlers) GetProcessingStatus(c echo.Context) error {
+	userID, ok := getUserID(c)
+	if !ok {
+		return c.JSON(http.StatusUnauthorized, map[string]string{"error": "unauthorized"})
+	}
+	photoID, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "invalid photo id"})
+	}
+	photo, err := h.photoRepo.GetByID(photoID)
+	if err != nil || photo.UserID != userID {
+		return c.JSON(http.StatusForbidden, map[string]string{"error": "access denied"})
+	}
+
+	expected := h.eagerVariants(c.Request().Context(), photo)
+	existing := make(map[string]*domain.PhotoVariant, len(photo.Variants))
+	for _, v := range photo.Variants {
+		existing[v.SizeName+"/"+v.Format] = v
+	}
+	report := domain.ProcessingReport{
+		PhotoID:  photo.ID,
+		Status:   photo.ProcessingStatus,
+		Expected: len(expected),
+		Variants: []*domain.PhotoVariant{},
+	}
+	for _, opts := range expected {
+		if v, ok := existing[opts.Key()+"/"+opts.Format]; ok {
+			report.Ready++
+			report.Variants = append(report.Variants, v)
+		}
+	}
+	if photo.PHash != nil {
+		report.NearDuplicates, err = h.photoRepo.NearDuplicates(userID, photo.ID, *photo.PHash, h.cfg.DuplicateMaxDistance, nearDuplicatesLimit)
+		if err != nil {
+			log.Printf("Near duplicates lookup error: %v", err)
+			return c.JSON(http.StatusInternalServerError, map[string]string{"error": "failed to fetch near duplicates"})
 		}
 	}
-	if o := c.QueryParam("offset"); o != "" {
-		if v, err := strconv.Atoi(o); err == nil && v >= 0 {
-			offset = v
+	return c.JSON(http.StatusOK, report)
+}
+
+// GetPhotoStats отдаёт владельцу статистику просмотров и лайков за последние days дней.
+func (h *Handlers) GetPhotoStats(c echo.Context) error {
+	userID, ok := getUserID(c)
+	if !ok {
+		return c.JSON(http.StatusUnauthorized, map[string]string{"error": "unauthorized"})
+	}
+	photoID, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "invalid photo id"})
+	}
+	days := 30
+	if d := c.QueryParam("days"); d != "" {
+		v, err := strconv.Atoi(d)
+		if err != nil || v < 1 || v > 365 {
+			return c.JSON(http.StatusBadRequest, map[string]string{"error": "days must be between 1 and 365"})
 		}
+		days = v
 	}
-	photos, err := h.photoRepo.ListByUser(userID, limit, offset)
+	photo, err := h.photoRepo.GetByID(photoID)
+	if err != nil || photo.UserID != userID {
+		return c.JSON(http.StatusForbidden, map[string]string{"error": "access denied"})
+	}
+	stats, err := h.photoRepo.GetStats(photoID, days)
 	if err != nil {
-		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "failed to fetch photos"})
+		log.Printf("stats error: %v", err)
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "failed to fetch stats"})
+	}
+	return c.JSON(http.StatusOK, stats)
+}
+
+// recordView учитывает просмотр фото в Redis. Просмотры владельца не считаются.
+// Анонимный зритель идентифицируется хешем IP и User-Agent.
+func (h *Handlers) recordView(c echo.Context, photo *domain.Photo) {
+	viewerID := ""
+	if userID, ok := getUserID(c); ok {
+		if userID == photo.UserID {
+			return
+		}
+		viewerID = "u:" + strconv.FormatInt(userID, 10)
+	} else {
+		sum := sha256.Sum256([]byte(c.RealIP() + "|" + c.Request().UserAgent()))
+		viewerID = fmt.Sprintf("a:%x", sum[:8])
+	}
+
+	referrer := "direct"
+	if ref := c.Request().Referer(); ref != "" {
+		if u, err := url.Parse(ref); err == nil && validHostname(u.Hostname()) {
+			referrer = strings.ToLower(u.Hostname())
+		}
+	}
+
+	if err := h.redisRepo.RecordView(c.Request().Context(), photo.ID, viewerID, referrer); err != nil {
+		log.Printf("record view error: %v", err)
 	}
-	return c.JSON(http.StatusOK, photos)
+}
+
+// validHostname проверяет, что host из Referer похож на доменное имя или
+// IP-адрес: не длиннее 253 символов и только из букв, цифр, '.', '-' и ':'
+// (IPv6). Остальные источники считаются прямыми заходами.
+func validHostname(host string) bool {
+	if host == "" || len(host) > 253 {
+		return false
+	}
+	for _, r := range host {
+		if !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '.' || r == '-' || r == ':') {
+			return false
+		}
+	}
+	return true
 }
 
 func (h *Handlers) UpdatePhoto(c echo.Context) error {
@@ -300,22 +816,18 @@ func (h *Handlers) GetImageVariant(c echo.Context) error {
 	}
 
 	// Получаем параметры
-	width, _ := strconv.Atoi(c.QueryParam("width"))
-	quality, _ := strconv.Atoi(c.QueryParam("q"))
-	if quality < 1 || quality > 100 {
-		quality = 80
+	opts, err := parseTransformOptions(c, h.cfg.ImageQuality, h.cfg.ImageEncoding)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
 	}
-	format := c.QueryParam("format")
-	if format == "" {
-		accept := c.Request().Header.Get("Accept")
-		switch {
-		case strings.Contains(accept, "avif"):
-			format = "avif"
-		case strings.Contains(accept, "webp"):
-			format = "webp"
-		default:
-			format = "jpeg"
-		}
+	// высокий effort кратно замедляет кодирование – для произвольных запросов он
+	// ограничен; умолчание из IMAGE_ENCODING проверяется при загрузке конфигурации
+	if c.QueryParam("effort") != "" && opts.Encoding.Effort > h.cfg.ImageMaxEffort {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": fmt.Sprintf("effort must not exceed %d", h.cfg.ImageMaxEffort)})
+	}
+	dpr, err := parseDPR(c)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
 	}
 
 	// Получаем метаданные фото
@@ -323,50 +835,508 @@ func (h *Handlers) GetImageVariant(c echo.Context) error {
 	if err != nil {
 		return c.JSON(http.StatusNotFound, map[string]string{"error": "photo not found"})
 	}
+	// параметры запроса применяются к фото с его текущей правкой
+	opts.Edit = photo.Edit
 
-	// Если width == 0 -> отдаём оригинал (для модального окна)
-	if width <= 0 {
-		reader, err := h.minioRepo.GetOriginal(c.Request().Context(), photo.FilePath)
-		if err != nil {
-			return c.JSON(http.StatusNotFound, map[string]string{"error": "original file not found"})
+	// В строгом режиме допускаются только параметры пресетов: каждая их
+	// вариация – новый вариант, который нужно сгенерировать и хранить.
+	// Поэтому и dpr округляется, а с пресетами сравнивается итоговый размер
+	if h.cfg.ImagePresetsOnly {
+		dpr = presetDPR(dpr)
+	}
+	opts.ApplyDPR(dpr, photo.Width, photo.Height)
+	if h.cfg.ImagePresetsOnly && !h.matchesPreset(opts, dpr, photo) {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "ad-hoc transformations are disabled, use /api/photos/:id/variant/:preset"})
+	}
+	// lossless и near_lossless кодируются много дольше и дают большие файлы –
+	// в произвольных запросах они допускаются только для умеренных размеров
+	if (c.QueryParam("lossless") != "" || c.QueryParam("near_lossless") != "") && (opts.Encoding.Lossless || opts.Encoding.NearLossless) {
+		if width, height := opts.EstimateSize(photo.Width, photo.Height); width == 0 || height == 0 || max(width, height) > h.cfg.ImageLosslessMaxSize {
+			return c.JSON(http.StatusBadRequest, map[string]string{"error": fmt.Sprintf("lossless output must not exceed %dpx", h.cfg.ImageLosslessMaxSize)})
 		}
-		defer reader.Close()
-		data, err := io.ReadAll(reader)
-		if err != nil {
-			return c.JSON(http.StatusInternalServerError, map[string]string{"error": "failed to read original"})
+	}
+
+	// Без размеров, обрезки, выбора кадра и коррекций -> отдаём оригинал (для модального окна)
+	if opts.Width == 0 && opts.Height == 0 && opts.Crop == nil && opts.Frame == 0 && opts.Adjust.IsZero() {
+		if err := h.applyWatermark(c, photo, &opts); err != nil {
+			return watermarkError(c, err)
 		}
-		c.Response().Header().Set("Cache-Control", "public, max-age=31536000, immutable")
-		if photo.ContentHash != "" {
-			c.Response().Header().Set("X-Content-Hash", photo.ContentHash)
+		if opts.Watermark == nil && opts.Edit == nil && imagetype.BrowserSafe(photo.MimeType) {
+			return h.serveOriginal(c, photo)
 		}
-		return c.Blob(http.StatusOK, photo.MimeType, data)
+		// HEIC, TIFF и JPEG XL браузеры показать не могут, а правку и знак нужно
+		// применить – отдаём фото в полном размере, перекодированным в веб-формат
+		h.recordView(c, photo)
+		if opts.Format == "" {
+			opts.Format = h.negotiateFormat(c, opts, photo)
+		}
+		return h.serveVariant(c, photo, opts)
+	}
+
+	if err := h.applyWatermark(c, photo, &opts); err != nil {
+		return watermarkError(c, err)
 	}
+	if opts.Format == "" {
+		opts.Format = h.negotiateFormat(c, opts, photo)
+	}
+	return h.serveVariant(c, photo, opts)
+}
 
+// GetPresetVariant отдаёт вариант фото по имени пресета из конфигурации.
+func (h *Handlers) GetPresetVariant(c echo.Context) error {
+	photoID, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil || photoID <= 0 {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "invalid photo id"})
+	}
+	preset, ok := h.cfg.ImagePresets[c.Param("preset")]
+	if !ok {
+		return c.JSON(http.StatusNotFound, map[string]string{"error": "unknown preset"})
+	}
+
+	format := preset.Format
+	if format == config.FormatAuto {
+		if format, err = parseFormat(c); err != nil {
+			return c.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
+		}
+	}
+	dpr, err := parseDPR(c)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
+	}
+
+	photo, err := h.photoRepo.GetByID(photoID)
+	if err != nil {
+		return c.JSON(http.StatusNotFound, map[string]string{"error": "photo not found"})
+	}
+	opts := preset.Options(format)
+	opts.Edit = photo.Edit
+	// color=p3 – сохранить широкий охват (Display P3) вместо перевода в sRGB
+	if color := c.QueryParam("color"); color != "" {
+		opts.Color = transform.Color(strings.ToLower(color))
+		if err := opts.Validate(); err != nil {
+			return c.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
+		}
+	}
+	if h.cfg.ImagePresetsOnly {
+		dpr = presetDPR(dpr)
+	}
+	opts.ApplyDPR(dpr, photo.Width, photo.Height)
+	if err := h.applyWatermark(c, photo, &opts); err != nil {
+		return watermarkError(c, err)
+	}
+	if opts.Format == "" {
+		opts.Format = h.negotiateFormat(c, opts, photo)
+	}
+	return h.serveVariant(c, photo, opts)
+}
+
+// GetSrcset возвращает готовые наборы URL для srcset по каждому формату,
+// рассчитанные по размерам фото с учётом правки и настроенным breakpoints.
+func (h *Handlers) GetSrcset(c echo.Context) error {
+	photoID, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil || photoID <= 0 {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "invalid photo id"})
+	}
+	photo, err := h.photoRepo.GetByID(photoID)
+	if err != nil {
+		return c.JSON(http.StatusNotFound, map[string]string{"error": "photo not found"})
+	}
+	userID, _ := getUserID(c)
+	if !photo.IsPublic && photo.UserID != userID {
+		return c.JSON(http.StatusNotFound, map[string]string{"error": "photo not found"})
+	}
+
+	// Ширины не больше фото (с учётом правки); само фото замыкает набор, если оно меньше крайнего breakpoint
+	width, height := photo.DisplaySize()
+	var widths []int
+	for _, w := range h.cfg.ImageSrcsetWidths {
+		if width > 0 && w >= width {
+			widths = append(widths, width)
+			break
+		}
+		widths = append(widths, w)
+	}
+	// immutable кэшируются только URL с версией правки – после правки у них новые URL
+	version := "&v=" + strconv.Itoa(photo.EditVersion)
+
+	result := domain.Srcset{
+		PhotoID:       photo.ID,
+		Width:         width,
+		Height:        height,
+		BlurHash:      photo.BlurHash,
+		ThumbHash:     photo.ThumbHash,
+		DominantColor: photo.DominantColor,
+		Sources:       []domain.SrcsetSource{},
+	}
+	for _, format := range []string{"avif", "webp", "jpeg"} {
+		src := domain.SrcsetSource{Format: format, Type: "image/" + format, Candidates: []domain.SrcsetCandidate{}}
+		parts := make([]string, 0, len(widths))
+		for _, w := range widths {
+			u := fmt.Sprintf("/api/photos/%d/variant?width=%d&format=%s%s", photo.ID, w, format, version)
+			src.Candidates = append(src.Candidates, domain.SrcsetCandidate{URL: u, Width: w})
+			parts = append(parts, fmt.Sprintf("%s %dw", u, w))
+		}
+		src.Srcset = strings.Join(parts, ", ")
+		result.Sources = append(result.Sources, src)
+	}
+
+	// Для браузеров без srcset – jpeg наибольшей ширины, не превышающей large-пресет
+	if len(widths) > 0 {
+		fallback := widths[0]
+		for _, w := range widths {
+			if w <= h.cfg.ImageLargeSize {
+				fallback = w
+			}
+		}
+		result.Fallback = fmt.Sprintf("/api/photos/%d/variant?width=%d&format=jpeg%s", photo.ID, fallback, version)
+	}
+	return c.JSON(http.StatusOK, result)
+}
+
+// serveOriginal потоково отдаёт оригинал фото с поддержкой Range и учитывает просмотр.
+func (h *Handlers) serveOriginal(c echo.Context, photo *domain.Photo) error {
+	etag := originalETag(photo)
+	if notModified(c, etag) {
+		setMediaHeaders(c, photo, etag)
+		return c.NoContent(http.StatusNotModified)
+	}
+	obj, err := h.minioRepo.GetOriginal(c.Request().Context(), photo.FilePath)
+	if err != nil {
+		return c.JSON(http.StatusNotFound, map[string]string{"error": "original file not found"})
+	}
+	defer obj.Close()
+	// оригинал запрашивается при открытии фото в модальном окне – это и есть просмотр;
+	// докачка частями (Range) просмотром не считается
+	if c.Request().Header.Get("Range") == "" {
+		h.recordView(c, photo)
+	}
+	setMediaHeaders(c, photo, etag)
+	return serveObject(c, obj, photo.MimeType)
+}
+
+// serveVariant генерирует (или берёт из хранилища) вариант фото и отдаёт его.
+// Если клиент уже имеет актуальную копию (If-None-Match), вариант даже не открывается.
+func (h *Handlers) serveVariant(c echo.Context, photo *domain.Photo, opts transform.Options) error {
 	// Если imageProcessor не инициализирован, отдаём ошибку
 	if h.imageProcessor == nil {
 		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "image processor not available"})
 	}
 
-	// Для запросов с шириной > 0 используем JIT-трансформацию
-	// Принудительно задаём высоту на основе ширины, сохраняя пропорции (вычисляем позже)
-	data, contentType, err := h.imageProcessor.GetVariant(
-		c.Request().Context(),
-		photoID,
-		fmt.Sprintf("%dw", width),
-		width,
-		format,
-		quality,
-	)
+	// выбранный формат сообщается клиенту, даже если он был согласован по Accept
+	c.Response().Header().Set("X-Image-Format", opts.Format)
+	etag := variantETag(photo, opts)
+	if notModified(c, etag) {
+		setMediaHeaders(c, photo, etag)
+		return c.NoContent(http.StatusNotModified)
+	}
+
+	obj, err := h.imageProcessor.GetVariant(c.Request().Context(), photo, opts)
 	if err != nil {
+		if errors.Is(err, transform.ErrInvalidOptions) {
+			return c.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
+		}
 		log.Printf("JIT variant error: %v", err)
 		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "failed to generate variant"})
 	}
+	defer obj.Close()
+	setMediaHeaders(c, photo, etag)
+	return serveObject(c, obj, obj.ContentType)
+}
+
+// versionedURL сообщает, что URL закреплён за текущей правкой фото
+// параметром v=edit_version: содержимое по такому URL не меняется.
+func versionedURL(c echo.Context, photo *domain.Photo) bool {
+	return c.QueryParam("v") == strconv.Itoa(photo.EditVersion)
+}
+
+// originalETag – сильный ETag оригинала: sha256 его содержимого.
+func originalETag(photo *domain.Photo) string {
+	if photo.ContentHash == "" {
+		return ""
+	}
+	return `"` + photo.ContentHash + `"`
+}
+
+// variantETag – сильный ETag варианта: хэш оригинала плюс хэш параметров
+// трансформации (включая формат), поэтому он меняется вместе с любым из них.
+func variantETag(photo *domain.Photo, opts transform.Options) string {
+	if len(photo.ContentHash) < 16 {
+		return ""
+	}
+	return `"` + photo.ContentHash[:16] + "-" + opts.Hash() + `"`
+}
 
-	c.Response().Header().Set("Cache-Control", "public, max-age=31536000, immutable")
+// notModified проверяет If-None-Match против ETag ресурса.
+func notModified(c echo.Context, etag string) bool {
+	inm := c.Request().Header.Get("If-None-Match")
+	if etag == "" || inm == "" {
+		return false
+	}
+	for _, candidate := range strings.Split(inm, ",") {
+		candidate = strings.TrimSpace(candidate)
+		// для If-None-Match допускается слабое сравнение
+		if candidate == "*" || strings.TrimPrefix(candidate, "W/") == etag {
+			return true
+		}
+	}
+	return false
+}
+
+// setMediaHeaders выставляет заголовки кэширования медиа-ответа.
+// Ответ по URL с текущей версией правки не меняется и помечается immutable,
+// без неё – перепроверяется по ETag, если обработчик не задал другую
+// политику (см. applyWatermark). Last-Modified не выставляется: правка и
+// смена водяного знака меняют ответ без изменения дат фото, поэтому
+// ответ проверяется только по ETag.
+func setMediaHeaders(c echo.Context, photo *domain.Photo, etag string) {
+	header := c.Response().Header()
+	switch cc, ok := c.Get(ctxCacheControl).(string); {
+	case ok:
+		header.Set("Cache-Control", cc)
+	case versionedURL(c, photo):
+		header.Set("Cache-Control", "public, max-age=31536000, immutable")
+	default:
+		header.Set("Cache-Control", "public, no-cache")
+	}
 	if photo.ContentHash != "" {
-		c.Response().Header().Set("X-Content-Hash", photo.ContentHash)
+		header.Set("X-Content-Hash", photo.ContentHash)
+	}
+	if etag != "" {
+		header.Set("ETag", etag)
+	}
+}
+
+// serveObject потоково отдаёт объект через http.ServeContent: он выставляет
+// Content-Length, обрабатывает Range/If-Range и условные заголовки. Время
+// изменения не передаётся, чтобы If-Modified-Since и If-Range по дате не
+// подтверждали устаревший ответ (см. setMediaHeaders).
+func serveObject(c echo.Context, obj *repository.Object, contentType string) error {
+	c.Response().Header().Set(echo.HeaderContentType, contentType)
+	http.ServeContent(c.Response(), c.Request(), "", time.Time{}, obj)
+	return nil
+}
+
+// matchesPreset сообщает, совпадает ли запрос целиком – размеры, fit,
+// качество, обрезка, кадр, коррекции и настройки кодировщика – с каким-либо
+// пресетом, шириной srcset или полноразмерной версией фото. Последние две –
+// с качеством и кодировщиком по умолчанию, как их выдаёт GetSrcset. Формат
+// должен совпадать с форматом пресета, если тот фиксирован; color=p3
+// допускается, как и у /variant/:preset. opts сравниваются уже с
+// применённым dpr, поэтому он применяется и к кандидатам.
+func (h *Handlers) matchesPreset(opts transform.Options, dpr float64, photo *domain.Photo) bool {
+	defaults := transform.Options{Quality: h.cfg.ImageQuality, Encoding: h.cfg.ImageEncoding}
+	candidates := []transform.Options{defaults}
+	for _, w := range h.cfg.ImageSrcsetWidths {
+		o := defaults
+		o.Width = w
+		candidates = append(candidates, o)
+	}
+	for _, p := range h.cfg.ImagePresets {
+		if p.Format == config.FormatAuto || p.Format == opts.Format {
+			candidates = append(candidates, p.Options(opts.Format))
+		}
+	}
+	for _, o := range candidates {
+		o.Format, o.Color, o.Edit = opts.Format, opts.Color, opts.Edit
+		o.Normalize()
+		o.ApplyDPR(dpr, photo.Width, photo.Height)
+		// Key учитывает только настройки кодировщика формата, а без format
+		// он ещё не выбран – поэтому настройки сравниваются целиком
+		if o.Key() == opts.Key() && o.Encoding == opts.Encoding {
+			return true
+		}
+	}
+	return false
+}
+
+// maxPresetDPR – наибольшая плотность в режиме IMAGE_PRESETS_ONLY.
+const maxPresetDPR = 3
+
+// presetDPR округляет dpr до 1, 2 или 3: в режиме IMAGE_PRESETS_ONLY
+// произвольный dpr давал бы произвольный размер и новый вариант.
+func presetDPR(dpr float64) float64 {
+	return min(math.Round(dpr), maxPresetDPR)
+}
+
+// parseDPR разбирает необязательный параметр dpr (плотность пикселей экрана).
+func parseDPR(c echo.Context) (float64, error) {
+	v := c.QueryParam("dpr")
+	if v == "" {
+		return 1, nil
+	}
+	dpr, err := strconv.ParseFloat(v, 64)
+	if err != nil || dpr < 1 || dpr > transform.MaxDPR {
+		return 0, fmt.Errorf("dpr must be between 1 and %g", transform.MaxDPR)
+	}
+	return dpr, nil
+}
+
+// parseTransformOptions разбирает параметры трансформации из query:
+// width, height, fit, gravity, crop=x,y,w,h, q, frame, color, format, коррекции
+// (см. parseAdjustments) и настройки кодировщика (см. parseEncoding). Без
+// format поле Format остаётся пустым: формат согласуется по Accept, когда
+// известен размер результата.
+func parseTransformOptions(c echo.Context, defaultQuality int, defaultEncoding transform.Encoding) (transform.Options, error) {
+	opts := transform.Options{
+		Fit:     transform.Fit(c.QueryParam("fit")),
+		Gravity: transform.Gravity(c.QueryParam("gravity")),
+		Quality: defaultQuality,
+		Color:   transform.Color(strings.ToLower(c.QueryParam("color"))),
+	}
+	var err error
+	if opts.Width, err = parseDimension(c.QueryParam("width")); err != nil {
+		return opts, errors.New("invalid width")
+	}
+	if opts.Height, err = parseDimension(c.QueryParam("height")); err != nil {
+		return opts, errors.New("invalid height")
+	}
+	if q := c.QueryParam("q"); q != "" {
+		if opts.Quality, err = transform.ParseQuality(q); err != nil {
+			return opts, errors.New("invalid quality")
+		}
+	}
+	if cr := c.QueryParam("crop"); cr != "" {
+		if opts.Crop, err = transform.ParseCrop(cr); err != nil {
+			return opts, err
+		}
+	}
+	// frame=N – статичный постер из N-го кадра анимации
+	if f := c.QueryParam("frame"); f != "" {
+		if opts.Frame, err = strconv.Atoi(f); err != nil || opts.Frame < 1 {
+			return opts, errors.New("invalid frame")
+		}
+	}
+	if opts.Format, err = parseFormat(c); err != nil {
+		return opts, err
+	}
+	if opts.Adjust, err = parseAdjustments(c); err != nil {
+		return opts, err
+	}
+	if opts.Encoding, err = parseEncoding(c, defaultEncoding); err != nil {
+		return opts, err
+	}
+
+	opts.Normalize()
+	return opts, opts.Validate()
+}
+
+// parseAdjustments разбирает коррекции из query: rotate=90|180|270,
+// flip=h|v|hv, grayscale=true, brightness, contrast, saturation (-100..100),
+// blur и sharpen (sigma), bg=RRGGBB. Порядок параметров не важен –
+// коррекции применяются в фиксированном порядке.
+func parseAdjustments(c echo.Context) (transform.Adjustments, error) {
+	a := transform.Adjustments{Background: c.QueryParam("bg")}
+	ints := []struct {
+		name string
+		dst  *int
+	}{
+		{"rotate", &a.Rotate}, {"brightness", &a.Brightness},
+		{"contrast", &a.Contrast}, {"saturation", &a.Saturation},
+	}
+	for _, p := range ints {
+		if v := c.QueryParam(p.name); v != "" {
+			n, err := strconv.Atoi(v)
+			if err != nil {
+				return a, fmt.Errorf("invalid %s", p.name)
+			}
+			*p.dst = n
+		}
+	}
+	floats := []struct {
+		name string
+		dst  *float64
+	}{
+		{"blur", &a.Blur}, {"sharpen", &a.Sharpen},
+	}
+	for _, p := range floats {
+		if v := c.QueryParam(p.name); v != "" {
+			f, err := strconv.ParseFloat(v, 64)
+			if err != nil || math.IsNaN(f) {
+				return a, fmt.Errorf("invalid %s", p.name)
+			}
+			*p.dst = f
+		}
+	}
+	switch flip := strings.ToLower(c.QueryParam("flip")); flip {
+	case "":
+	case "h":
+		a.FlipH = true
+	case "v":
+		a.FlipV = true
+	case "hv", "vh":
+		a.FlipH, a.FlipV = true, true
+	default:
+		return a, errors.New("invalid flip. allowed: h, v, hv")
+	}
+	if g := c.QueryParam("grayscale"); g != "" {
+		v, err := strconv.ParseBool(g)
+		if err != nil {
+			return a, errors.New("invalid grayscale")
+		}
+		a.Grayscale = v
+	}
+	return a, nil
+}
+
+// parseEncoding разбирает настройки кодировщика из query поверх умолчаний e:
+// progressive, trellis, lossless, near_lossless (true/false), quant (0..8),
+// subsample=auto|420|444, effort и depth=8|10|12. Каждый формат использует
+// только свои настройки (см. transform.Encoding.For).
+func parseEncoding(c echo.Context, e transform.Encoding) (transform.Encoding, error) {
+	bools := []struct {
+		name string
+		dst  *bool
+	}{
+		{"progressive", &e.Progressive}, {"trellis", &e.Trellis},
+		{"lossless", &e.Lossless}, {"near_lossless", &e.NearLossless},
+	}
+	for _, p := range bools {
+		if v := c.QueryParam(p.name); v != "" {
+			b, err := strconv.ParseBool(v)
+			if err != nil {
+				return e, fmt.Errorf("invalid %s", p.name)
+			}
+			*p.dst = b
+		}
+	}
+	ints := []struct {
+		name string
+		dst  *int
+	}{
+		{"quant", &e.QuantTable}, {"effort", &e.Effort}, {"depth", &e.BitDepth},
+	}
+	for _, p := range ints {
+		if v := c.QueryParam(p.name); v != "" {
+			n, err := strconv.Atoi(v)
+			if err != nil {
+				return e, fmt.Errorf("invalid %s", p.name)
+			}
+			*p.dst = n
+		}
+	}
+	if s := c.QueryParam("subsample"); s != "" {
+		var err error
+		if e.Subsample, err = transform.ParseSubsample(s); err != nil {
+			return e, err
+		}
+	}
+	return e, nil
+}
+
+// parseFormat возвращает формат из параметра format или пустую строку,
+// если он не задан и формат нужно согласовать по Accept (negotiateFormat).
+func parseFormat(c echo.Context) (string, error) {
+	switch format := strings.ToLower(c.QueryParam("format")); format {
+	case "":
+		return "", nil
+	case "jpg":
+		return transform.FormatJPEG, nil
+	default:
+		if !transform.IsOutputFormat(format) {
+			return "", fmt.Errorf("invalid format. allowed: jpg, %s", strings.Join(transform.OutputFormats, ", "))
+		}
+		return format, nil
 	}
-	return c.Blob(http.StatusOK, contentType, data)
 }
 
 // ---------- Likes for photos ----------
@@ -424,11 +1394,15 @@ func (h *Handlers) GetComments(c echo.Context) error {
 	if err != nil {
 		return c.JSON(http.StatusBadRequest, map[string]string{"error": "invalid photo id"})
 	}
-	comments, err := h.photoRepo.GetComments(photoID)
+	limit, cursor, err := parsePageParams(c, 50)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "invalid cursor"})
+	}
+	page, err := h.photoRepo.GetComments(photoID, limit, cursor)
 	if err != nil {
 		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "failed to fetch comments"})
 	}
-	return c.JSON(http.StatusOK, comments)
+	return c.JSON(http.StatusOK, page)
 }
 
 func (h *Handlers) CreateComment(c echo.Context) error {
@@ -450,16 +1424,11 @@ func (h *Handlers) CreateComment(c echo.Context) error {
 	if err != nil {
 		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "failed to create comment"})
 	}
-	comments, err := h.photoRepo.GetComments(photoID)
+	comment, err := h.photoRepo.GetComment(commentID)
 	if err != nil {
-		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "failed to fetch comments"})
-	}
-	for _, comm := range comments { // ← переименовано с "c" на "comm"
-		if comm.ID == commentID {
-			return c.JSON(http.StatusCreated, comm)
-		}
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "comment not found"})
 	}
-	return c.JSON(http.StatusInternalServerError, map[string]string{"error": "comment not found"})
+	return c.JSON(http.StatusCreated, comment)
 }
 
 // ---------- Comment likes ----------
@@ -516,11 +1485,15 @@ func (h *Handlers) ReportComment(c echo.Context) error {
 
 // ---------- Admin ----------
 func (h *Handlers) GetPendingPhotos(c echo.Context) error {
-	photos, err := h.photoRepo.GetPendingPhotos()
+	limit, cursor, err := parsePageParams(c, 50)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "invalid cursor"})
+	}
+	page, err := h.photoRepo.GetPendingPhotos(limit, cursor)
 	if err != nil {
 		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "failed to fetch pending photos"})
 	}
-	return c.JSON(http.StatusOK, photos)
+	return c.JSON(http.StatusOK, page)
 }
 
 func (h *Handlers) ApprovePhoto(c echo.Context) error {
@@ -539,7 +1512,7 @@ func (h *Handlers) RejectPhoto(c echo.Context) error {
 	if err != nil {
 		return c.JSON(http.StatusBadRequest, map[string]string{"error": "invalid id"})
 	}
-	if err := h.photoRepo.RejectPhoto(photoID); err != nil {
+	if err := h.photoRepo.RejectPhoto(photoID, h.cfg.DuplicateMaxDistance); err != nil {
 		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "failed to reject"})
 	}
 	return c.NoContent(http.StatusOK)
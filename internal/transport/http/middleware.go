@@ -5,6 +5,7 @@ import (
 	"strings"
 
 	"github.com/freshtea599/PhotoHubServer.git/internal/auth"
+	"github.com/freshtea599/PhotoHubServer.git/internal/repository"
 	"github.com/labstack/echo/v4"
 )
 
@@ -41,11 +42,48 @@ func JWTMiddleware(jwtManager *auth.JWTManager) echo.MiddlewareFunc {
 	}
 }
 
+// AdminMiddleware пропускает только администраторов (users.is_admin).
+// Ставится после JWTMiddleware: флаг читается из базы на каждый запрос,
+// поэтому снятие прав действует сразу, а не после истечения токена.
+func AdminMiddleware(userRepo *repository.PostgresUserRepo) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			userID, ok := getUserID(c)
+			if !ok {
+				return c.JSON(http.StatusUnauthorized, map[string]string{"error": "unauthorized"})
+			}
+			user, err := userRepo.GetByID(userID)
+			if err != nil || !user.IsAdmin {
+				return c.JSON(http.StatusForbidden, map[string]string{"error": "admin access required"})
+			}
+			return next(c)
+		}
+	}
+}
+
+// OptionalJWTMiddleware выставляет user_id, если передан валидный токен,
+// но не отклоняет анонимные запросы (для публичных эндпоинтов).
+func OptionalJWTMiddleware(jwtManager *auth.JWTManager) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			parts := strings.SplitN(c.Request().Header.Get("Authorization"), " ", 2)
+			if len(parts) == 2 && parts[0] == "Bearer" {
+				if claims, err := jwtManager.ValidateToken(parts[1]); err == nil {
+					c.Set("user_id", claims.UserID)
+					c.Set("email", claims.Email)
+				}
+			}
+			return next(c)
+		}
+	}
+}
+
 func CORSMiddleware(next echo.HandlerFunc) echo.HandlerFunc {
 	return func(c echo.Context) error {
 		c.Response().Header().Set("Access-Control-Allow-Origin", "*")
 		c.Response().Header().Set("Access-Control-Allow-Methods", "GET, POST, PUT, DELETE, OPTIONS")
-		c.Response().Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization, X-Content-Hash")
+		c.Response().Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization, X-Content-Hash, If-None-Match, Range")
+		c.Response().Header().Set("Access-Control-Expose-Headers", "ETag, Content-Range, Accept-Ranges, X-Content-Hash, X-Image-Format")
 		if c.Request().Method == "OPTIONS" {
 			return c.NoContent(http.StatusNoContent)
 		}
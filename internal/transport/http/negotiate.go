@@ -0,0 +1,119 @@
+package http
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/labstack/echo/v4"
+
+	"github.com/freshtea599/PhotoHubServer.git/internal/domain"
+	"github.com/freshtea599/PhotoHubServer.git/pkg/transform"
+)
+
+// acceptRange – один элемент заголовка Accept, например "image/*;q=0.8".
+type acceptRange struct {
+	mediaType string // "image/avif", "image/*" или "*/*"
+	q         float64
+}
+
+// parseAccept разбирает заголовок Accept. Элементы с некорректным q пропускаются.
+func parseAccept(header string) []acceptRange {
+	var ranges []acceptRange
+	for _, item := range strings.Split(header, ",") {
+		params := strings.Split(item, ";")
+		mediaType := strings.ToLower(strings.TrimSpace(params[0]))
+		if mediaType == "" {
+			continue
+		}
+		r := acceptRange{mediaType: mediaType, q: 1}
+		valid := true
+		for _, p := range params[1:] {
+			key, val, _ := strings.Cut(strings.TrimSpace(p), "=")
+			if strings.ToLower(strings.TrimSpace(key)) != "q" {
+				continue
+			}
+			q, err := strconv.ParseFloat(strings.TrimSpace(val), 64)
+			if err != nil || q < 0 || q > 1 {
+				valid = false
+				break
+			}
+			r.q = q
+		}
+		if valid {
+			ranges = append(ranges, r)
+		}
+	}
+	return ranges
+}
+
+// formatQuality возвращает q, с которым клиент принимает формат: берётся
+// самое специфичное совпадение (точный тип, затем image/*, затем */*).
+// Маски не подразумевают поддержку современных форматов: Safari присылает
+// "image/*" и без поддержки AVIF, поэтому webp/avif/jxl засчитываются только
+// при явном упоминании, а jpeg, png и gif – по любой маске.
+func formatQuality(ranges []acceptRange, format string) float64 {
+	mime := transform.MimeType(format)
+	universal := format == transform.FormatJPEG || format == transform.FormatPNG || format == transform.FormatGIF
+	best, specificity := 0.0, 0
+	for _, r := range ranges {
+		s := 0
+		switch {
+		case r.mediaType == mime:
+			s = 3
+		case universal && r.mediaType == "image/*":
+			s = 2
+		case universal && r.mediaType == "*/*":
+			s = 1
+		}
+		if s > specificity || (s == specificity && s > 0 && r.q > best) {
+			best, specificity = r.q, s
+		}
+	}
+	return best
+}
+
+// negotiateFormat выбирает формат результата по Accept: из форматов,
+// которые принимает клиент и которые не превышают лимит площади, берётся
+// формат с наибольшим q, при равных q – более предпочтительный для сервера.
+// Для анимации рассматриваются только форматы с анимацией, чтобы она не
+// превратилась в статичный кадр. Если ничего не подошло, отдаётся jpeg
+// (для анимации – gif). Выбор отражается в Vary.
+func (h *Handlers) negotiateFormat(c echo.Context, opts transform.Options, photo *domain.Photo) string {
+	c.Response().Header().Add(echo.HeaderVary, echo.HeaderAccept)
+	animated := photo.Animated() && opts.Frame == 0
+
+	ranges := parseAccept(c.Request().Header.Get(echo.HeaderAccept))
+	pixels := opts.EstimatePixels(photo.Width, photo.Height)
+
+	type candidate struct {
+		format string
+		q      float64
+		rank   int
+	}
+	var candidates []candidate
+	for rank, format := range h.cfg.ImageFormatPreference {
+		if animated && !transform.Animatable(format) {
+			continue
+		}
+		if limit, ok := h.cfg.ImageFormatMaxPixels[format]; ok && pixels > limit {
+			continue
+		}
+		if q := formatQuality(ranges, format); q > 0 {
+			candidates = append(candidates, candidate{format: format, q: q, rank: rank})
+		}
+	}
+	if len(candidates) == 0 {
+		if animated {
+			return transform.FormatGIF
+		}
+		return transform.FormatJPEG
+	}
+	sort.SliceStable(candidates, func(i, j int) bool {
+		if candidates[i].q != candidates[j].q {
+			return candidates[i].q > candidates[j].q
+		}
+		return candidates[i].rank < candidates[j].rank
+	})
+	return candidates[0].format
+}
@@ -33,13 +33,18 @@ func NewServer(
 ) *Server {
 	e := echo.New()
 	e.Use(CORSMiddleware)
-	h := NewHandlers(cfg, jwtManager, userRepo, photoRepo, minioRepo, imgProc)
+	redisRepo := repository.NewRedisRepo(redisClient)
+	h := NewHandlers(cfg, jwtManager, userRepo, photoRepo, minioRepo, redisRepo, imgProc)
 
 	// Публичные
 	e.POST("/api/register", h.Register)
 	e.POST("/api/login", h.Login)
 	e.GET("/api/photos", h.ListPhotos)
-	e.GET("/api/photos/:id/variant", h.GetImageVariant)
+	e.GET("/api/photos/trending", h.GetTrendingPhotos)
+	e.GET("/api/photos/:id", h.GetPhoto, OptionalJWTMiddleware(jwtManager))
+	e.GET("/api/photos/:id/variant", h.GetImageVariant, OptionalJWTMiddleware(jwtManager))
+	e.GET("/api/photos/:id/variant/:preset", h.GetPresetVariant, OptionalJWTMiddleware(jwtManager))
+	e.GET("/api/photos/:id/srcset", h.GetSrcset, OptionalJWTMiddleware(jwtManager))
 
 	// Защищённые
 	api := e.Group("/api")
@@ -47,8 +52,11 @@ func NewServer(
 	api.GET("/auth/me", h.GetMe)
 	api.POST("/upload", h.UploadPhoto)
 	api.GET("/photos/mine", h.GetMyPhotos)
+	api.GET("/photos/mine/duplicates", h.GetMyDuplicates)
 	api.PUT("/photos/:id", h.UpdatePhoto)
 	api.DELETE("/photos/:id", h.DeletePhoto)
+	api.GET("/photos/:id/stats", h.GetPhotoStats)
+	api.GET("/photos/:id/processing", h.GetProcessingStatus)
 	api.POST("/photos/:id/like", h.LikePhoto)
 	api.DELETE("/photos/:id/like", h.UnlikePhoto)
 	api.GET("/photos/:id/like", h.IsPhotoLiked)
@@ -57,11 +65,22 @@ func NewServer(
 	api.POST("/comments/:id/like", h.LikeComment)
 	api.DELETE("/comments/:id/like", h.UnlikeComment)
 	api.POST("/comments/:id/report", h.ReportComment)
+	api.GET("/watermark", h.GetWatermark)
+	api.PUT("/watermark", h.PutWatermark)
+	api.DELETE("/watermark", h.DeleteWatermark)
+	api.POST("/watermark/image", h.UploadWatermarkImage)
+	api.GET("/photos/:id/watermark", h.GetPhotoWatermark)
+	api.PUT("/photos/:id/watermark", h.PutPhotoWatermark)
+	api.DELETE("/photos/:id/watermark", h.DeletePhotoWatermark)
+	api.GET("/photos/:id/edits", h.GetPhotoEdits)
+	api.PUT("/photos/:id/edit", h.PutPhotoEdit)
+	api.POST("/photos/:id/edits/:version/revert", h.RevertPhotoEdit)
 
-	// Админские (проверку is_admin добавить позже)
+	// Админские
 	admin := e.Group("/admin")
-	admin.Use(JWTMiddleware(jwtManager))
+	admin.Use(JWTMiddleware(jwtManager), AdminMiddleware(userRepo))
 	admin.GET("/photos/pending", h.GetPendingPhotos)
+	admin.GET("/photos/reuploads", h.GetReuploads)
 	admin.POST("/photos/:id/approve", h.ApprovePhoto)
 	admin.POST("/photos/:id/reject", h.RejectPhoto)
 
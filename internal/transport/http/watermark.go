@@ -0,0 +1,413 @@
+package http
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+
+	"github.com/google/uuid"
+	"github.com/labstack/echo/v4"
+
+	"github.com/freshtea599/PhotoHubServer.git/internal/domain"
+	"github.com/freshtea599/PhotoHubServer.git/pkg/imagetype"
+	"github.com/freshtea599/PhotoHubServer.git/pkg/transform"
+)
+
+// Ограничения изображения водяного знака.
+const (
+	maxWatermarkSize      = 2 << 20 // размер файла
+	maxWatermarkDimension = 4096    // ширина и высота
+)
+
+// Значения по умолчанию для новых настроек знака.
+const (
+	defaultWatermarkPosition = transform.GravitySouthEast
+	defaultWatermarkOpacity  = 0.5
+	defaultWatermarkScale    = 0.25
+)
+
+// ctxCacheControl – политика кэширования медиа-ответа, если она отличается
+// от политики по умолчанию (см. setMediaHeaders).
+const ctxCacheControl = "cache_control"
+
+// watermarkImageTypes – форматы, в которых принимается изображение знака.
+var watermarkImageTypes = map[string]bool{
+	imagetype.PNG: true, imagetype.WebP: true, imagetype.JPEG: true, imagetype.GIF: true,
+}
+
+// errWatermarkRestricted – запрос к фото со знаком задаёт обрезку или
+// коррекции: из таких фрагментов можно было бы собрать изображение без знака.
+var errWatermarkRestricted = errors.New("crop and adjustments are not available for watermarked photos")
+
+// applyWatermark добавляет к параметрам водяной знак фото, если он настроен
+// и запрос не от владельца. Обрезка и коррекции в таком запросе запрещены
+// (errWatermarkRestricted). Знак не накладывается только на уменьшенные копии
+// фото целиком, у которых ширина фото в этом масштабе меньше
+// WATERMARK_MIN_WIDTH; если размеры фото ещё не известны, знак накладывается
+// всегда. Ответ по такому фото зависит от пользователя: он варьируется по
+// Authorization, владельцу отдаётся как private, остальным – без immutable,
+// чтобы смена знака дошла до клиентов.
+func (h *Handlers) applyWatermark(c echo.Context, photo *domain.Photo, opts *transform.Options) error {
+	spec, err := h.effectiveWatermark(c.Request().Context(), photo)
+	if err != nil {
+		return err
+	}
+	if spec == nil {
+		return nil
+	}
+	c.Response().Header().Add(echo.HeaderVary, echo.HeaderAuthorization)
+	versioned := versionedURL(c, photo)
+	if userID, ok := getUserID(c); ok && userID == photo.UserID {
+		if versioned {
+			c.Set(ctxCacheControl, "private, max-age=31536000, immutable")
+		} else {
+			c.Set(ctxCacheControl, "private, no-cache")
+		}
+		return nil
+	}
+	if opts.Crop != nil || !opts.Adjust.IsZero() {
+		return errWatermarkRestricted
+	}
+	if versioned {
+		c.Set(ctxCacheControl, "public, max-age=3600")
+	} else {
+		c.Set(ctxCacheControl, "public, no-cache")
+	}
+	if h.watermarkFits(photo, *opts) {
+		opts.Watermark = spec
+	}
+	return nil
+}
+
+// watermarkFits сообщает, накладывается ли знак на вариант opts: ширина фото
+// в масштабе варианта не меньше WATERMARK_MIN_WIDTH или размеры фото ещё не
+// известны.
+func (h *Handlers) watermarkFits(photo *domain.Photo, opts transform.Options) bool {
+	width, _ := photo.DisplaySize()
+	scale := opts.Scale(photo.Width, photo.Height)
+	return scale <= 0 || float64(width)*scale >= float64(h.cfg.WatermarkMinWidth)
+}
+
+// eagerVariants возвращает параметры предгенерируемых вариантов фото с его
+// текущей правкой и тем знаком, который получит посторонний зритель (см.
+// applyWatermark), – иначе публичные запросы в эти варианты не попадали бы.
+// Если знак прочитать не удалось, варианты строятся без него.
+func (h *Handlers) eagerVariants(ctx context.Context, photo *domain.Photo) []transform.Options {
+	variants := h.cfg.EagerVariants(photo.Edit)
+	spec, err := h.effectiveWatermark(ctx, photo)
+	if err != nil {
+		log.Printf("Watermark lookup error for eager variants of photo %d: %v", photo.ID, err)
+		return variants
+	}
+	if spec == nil {
+		return variants
+	}
+	for i := range variants {
+		if h.watermarkFits(photo, variants[i]) {
+			variants[i].Watermark = spec
+		}
+	}
+	return variants
+}
+
+// watermarkError отвечает на ошибку applyWatermark.
+func watermarkError(c echo.Context, err error) error {
+	if errors.Is(err, errWatermarkRestricted) {
+		return c.JSON(http.StatusForbidden, map[string]string{"error": err.Error()})
+	}
+	log.Printf("Watermark lookup error: %v", err)
+	return c.JSON(http.StatusInternalServerError, map[string]string{"error": "failed to load watermark"})
+}
+
+// effectiveWatermark возвращает параметры знака, действующего для фото (nil –
+// знака нет). Знак нужен на каждый запрос варианта, поэтому он кэшируется в
+// Redis; при недоступности Redis читается из Postgres.
+func (h *Handlers) effectiveWatermark(ctx context.Context, photo *domain.Photo) (*transform.Watermark, error) {
+	spec, found, err := h.redisRepo.GetWatermark(ctx, photo.UserID, photo.ID)
+	if err != nil {
+		log.Printf("Redis watermark cache error: %v", err)
+	}
+	if found {
+		return spec, nil
+	}
+	wm, err := h.userRepo.GetEffectiveWatermark(photo.UserID, photo.ID)
+	if err != nil {
+		return nil, err
+	}
+	spec = wm.Options()
+	if err := h.redisRepo.CacheWatermark(ctx, photo.UserID, photo.ID, spec); err != nil {
+		log.Printf("Redis watermark cache error: %v", err)
+	}
+	return spec, nil
+}
+
+// forgetWatermarks сбрасывает кэш знаков фото пользователя после изменения
+// его настроек.
+func (h *Handlers) forgetWatermarks(ctx context.Context, userID int64) {
+	if err := h.redisRepo.ForgetWatermarks(ctx, userID); err != nil {
+		log.Printf("Redis watermark cache error: %v", err)
+	}
+}
+
+// GetWatermark возвращает водяной знак пользователя по умолчанию.
+func (h *Handlers) GetWatermark(c echo.Context) error {
+	userID, ok := getUserID(c)
+	if !ok {
+		return c.JSON(http.StatusUnauthorized, map[string]string{"error": "unauthorized"})
+	}
+	return h.respondWatermark(c, userID, 0)
+}
+
+// PutWatermark задаёт водяной знак пользователя по умолчанию: текст или
+// загруженное ранее изображение (POST /api/watermark/image).
+func (h *Handlers) PutWatermark(c echo.Context) error {
+	userID, ok := getUserID(c)
+	if !ok {
+		return c.JSON(http.StatusUnauthorized, map[string]string{"error": "unauthorized"})
+	}
+	return h.saveWatermark(c, userID, 0)
+}
+
+// DeleteWatermark удаляет водяной знак пользователя по умолчанию вместе с его изображением.
+func (h *Handlers) DeleteWatermark(c echo.Context) error {
+	userID, ok := getUserID(c)
+	if !ok {
+		return c.JSON(http.StatusUnauthorized, map[string]string{"error": "unauthorized"})
+	}
+	imagePath, err := h.userRepo.DeleteWatermark(userID, 0)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "failed to delete watermark"})
+	}
+	h.forgetWatermarks(c.Request().Context(), userID)
+	if imagePath != "" {
+		if err := h.minioRepo.DeleteOriginal(c.Request().Context(), imagePath); err != nil {
+			log.Printf("Failed to delete watermark image: %v", err)
+		}
+	}
+	return c.NoContent(http.StatusOK)
+}
+
+// UploadWatermarkImage загружает изображение водяного знака (поле image)
+// и делает знак пользователя по умолчанию графическим. Остальные настройки
+// сохраняются, у нового знака берутся значения по умолчанию.
+func (h *Handlers) UploadWatermarkImage(c echo.Context) error {
+	userID, ok := getUserID(c)
+	if !ok {
+		return c.JSON(http.StatusUnauthorized, map[string]string{"error": "unauthorized"})
+	}
+	c.Request().Body = http.MaxBytesReader(c.Response(), c.Request().Body, maxWatermarkSize+1<<20)
+	file, err := c.FormFile("image")
+	if err != nil {
+		var maxErr *http.MaxBytesError
+		if errors.As(err, &maxErr) {
+			return c.JSON(http.StatusRequestEntityTooLarge, map[string]string{"error": "watermark must not exceed 2MB"})
+		}
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "image file is required"})
+	}
+	if file.Size > maxWatermarkSize {
+		return c.JSON(http.StatusRequestEntityTooLarge, map[string]string{"error": "watermark must not exceed 2MB"})
+	}
+	src, err := file.Open()
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "image file is required"})
+	}
+	data, err := io.ReadAll(src)
+	src.Close()
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "failed to read image"})
+	}
+
+	mimeType := imagetype.Detect(data)
+	if !watermarkImageTypes[mimeType] {
+		return c.JSON(http.StatusUnsupportedMediaType, map[string]string{"error": "invalid watermark format. allowed: png, webp, jpeg, gif"})
+	}
+	width, height, err := imagetype.Dimensions(data, mimeType)
+	if err != nil || imagetype.Polyglot(mimeType, data, data[max(len(data)-uploadScanSize, 0):], int64(len(data))) {
+		return c.JSON(http.StatusUnprocessableEntity, map[string]string{"error": "image is corrupt or contains non-image content"})
+	}
+	if width > maxWatermarkDimension || height > maxWatermarkDimension {
+		return c.JSON(http.StatusUnprocessableEntity, map[string]string{"error": "watermark dimensions must not exceed 4096x4096"})
+	}
+
+	ctx := c.Request().Context()
+	wm, err := h.userRepo.GetWatermark(userID, 0)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "failed to load watermark"})
+	}
+	if wm == nil {
+		wm = newWatermark(userID, 0)
+	}
+	oldPath := wm.ImagePath
+
+	name := strconv.FormatInt(userID, 10) + "/" + uuid.New().String() + imagetype.Extension(mimeType)
+	if wm.ImagePath, err = h.minioRepo.PutWatermark(ctx, name, data, mimeType); err != nil {
+		log.Printf("Watermark upload error: %v", err)
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "failed to store watermark"})
+	}
+	wm.Kind, wm.Text, wm.HasImage = domain.WatermarkImage, "", true
+	if err := h.userRepo.SaveWatermark(wm); err != nil {
+		_ = h.minioRepo.DeleteOriginal(ctx, wm.ImagePath)
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "failed to save watermark"})
+	}
+	h.forgetWatermarks(ctx, userID)
+	if oldPath != "" {
+		if err := h.minioRepo.DeleteOriginal(ctx, oldPath); err != nil {
+			log.Printf("Failed to delete old watermark image: %v", err)
+		}
+	}
+	return c.JSON(http.StatusOK, wm)
+}
+
+// GetPhotoWatermark возвращает настройку водяного знака отдельного фото.
+func (h *Handlers) GetPhotoWatermark(c echo.Context) error {
+	userID, ok := getUserID(c)
+	if !ok {
+		return c.JSON(http.StatusUnauthorized, map[string]string{"error": "unauthorized"})
+	}
+	photoID, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "invalid photo id"})
+	}
+	photo, err := h.photoRepo.GetByID(photoID)
+	if err != nil || photo.UserID != userID {
+		return c.JSON(http.StatusForbidden, map[string]string{"error": "access denied"})
+	}
+	return h.respondWatermark(c, userID, photoID)
+}
+
+// PutPhotoWatermark задаёт водяной знак отдельного фото вместо знака по
+// умолчанию. kind = none отключает знак для этого фото.
+func (h *Handlers) PutPhotoWatermark(c echo.Context) error {
+	userID, ok := getUserID(c)
+	if !ok {
+		return c.JSON(http.StatusUnauthorized, map[string]string{"error": "unauthorized"})
+	}
+	photoID, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "invalid photo id"})
+	}
+	photo, err := h.photoRepo.GetByID(photoID)
+	if err != nil || photo.UserID != userID {
+		return c.JSON(http.StatusForbidden, map[string]string{"error": "access denied"})
+	}
+	return h.saveWatermark(c, userID, photoID)
+}
+
+// DeletePhotoWatermark возвращает фото к знаку пользователя по умолчанию.
+func (h *Handlers) DeletePhotoWatermark(c echo.Context) error {
+	userID, ok := getUserID(c)
+	if !ok {
+		return c.JSON(http.StatusUnauthorized, map[string]string{"error": "unauthorized"})
+	}
+	photoID, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "invalid photo id"})
+	}
+	photo, err := h.photoRepo.GetByID(photoID)
+	if err != nil || photo.UserID != userID {
+		return c.JSON(http.StatusForbidden, map[string]string{"error": "access denied"})
+	}
+	if _, err := h.userRepo.DeleteWatermark(userID, photoID); err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "failed to delete watermark"})
+	}
+	h.forgetWatermarks(c.Request().Context(), userID)
+	return c.NoContent(http.StatusOK)
+}
+
+// respondWatermark отдаёт настройку пользователя (photoID = 0) или фото.
+func (h *Handlers) respondWatermark(c echo.Context, userID, photoID int64) error {
+	wm, err := h.userRepo.GetWatermark(userID, photoID)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "failed to load watermark"})
+	}
+	if wm == nil {
+		return c.JSON(http.StatusNotFound, map[string]string{"error": "watermark not set"})
+	}
+	return c.JSON(http.StatusOK, wm)
+}
+
+// saveWatermark применяет WatermarkRequest к настройке пользователя
+// (photoID = 0) или фото. Незаданные position, opacity и scale берутся из
+// текущей настройки или значений по умолчанию. Изображение знака у фото не
+// хранится – используется загруженное в настройку по умолчанию.
+func (h *Handlers) saveWatermark(c echo.Context, userID, photoID int64) error {
+	var req domain.WatermarkRequest
+	if err := c.Bind(&req); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "invalid request"})
+	}
+	wm, err := h.userRepo.GetWatermark(userID, photoID)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "failed to load watermark"})
+	}
+	if wm == nil {
+		wm = newWatermark(userID, photoID)
+	}
+	wm.Kind, wm.Text = req.Kind, req.Text
+	if req.Position != "" {
+		wm.Position = req.Position
+	}
+	if req.Opacity != 0 {
+		wm.Opacity = req.Opacity
+	}
+	if req.Scale != 0 {
+		wm.Scale = req.Scale
+	}
+
+	spec := transform.Watermark{
+		Text:    wm.Text,
+		Image:   "-", // проверяются только параметры размещения
+		Gravity: transform.Gravity(wm.Position),
+		Opacity: wm.Opacity,
+		Scale:   wm.Scale,
+	}
+	switch wm.Kind {
+	case domain.WatermarkText:
+		spec.Image = ""
+	case domain.WatermarkImage:
+		wm.Text, spec.Text = "", ""
+		hasImage := wm.ImagePath != ""
+		if photoID != 0 {
+			def, err := h.userRepo.GetWatermark(userID, 0)
+			if err != nil {
+				return c.JSON(http.StatusInternalServerError, map[string]string{"error": "failed to load watermark"})
+			}
+			hasImage = def != nil && def.ImagePath != ""
+		}
+		if !hasImage {
+			return c.JSON(http.StatusBadRequest, map[string]string{"error": "upload a watermark image first: POST /api/watermark/image"})
+		}
+	case domain.WatermarkNone:
+		if photoID == 0 {
+			return c.JSON(http.StatusBadRequest, map[string]string{"error": "kind none is only allowed for a photo, use DELETE /api/watermark"})
+		}
+		wm.Text, spec = "", transform.Watermark{}
+	default:
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "invalid kind. allowed: text, image, none"})
+	}
+	if spec != (transform.Watermark{}) {
+		if err := spec.Validate(); err != nil {
+			return c.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
+		}
+	}
+
+	if err := h.userRepo.SaveWatermark(wm); err != nil {
+		log.Printf("Watermark save error: %v", err)
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "failed to save watermark"})
+	}
+	h.forgetWatermarks(c.Request().Context(), userID)
+	return c.JSON(http.StatusOK, wm)
+}
+
+// newWatermark – настройка знака со значениями по умолчанию.
+func newWatermark(userID, photoID int64) *domain.Watermark {
+	return &domain.Watermark{
+		UserID:   userID,
+		PhotoID:  photoID,
+		Position: string(defaultWatermarkPosition),
+		Opacity:  defaultWatermarkOpacity,
+		Scale:    defaultWatermarkScale,
+	}
+}
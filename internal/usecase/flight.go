@@ -0,0 +1,60 @@
+// backend/internal/usecase/flight.go
+package usecase
+
+import (
+	"context"
+	"sync"
+
+	"github.com/freshtea599/PhotoHubServer.git/internal/domain"
+)
+
+// flightGroup объединяет одновременные вызовы с одинаковым ключом:
+// функция выполняется один раз, все ожидающие получают её результат.
+type flightGroup struct {
+	mu    sync.Mutex
+	calls map[string]*flightCall
+}
+
+type flightCall struct {
+	done     chan struct{}
+	boost    chan struct{} // закрывается, когда к вызову присоединяется вызывающий с PriorityHigh
+	priority domain.JobPriority
+	data     []byte
+	err      error
+}
+
+func newFlightGroup() *flightGroup {
+	return &flightGroup{calls: make(map[string]*flightCall)}
+}
+
+// Do выполняет fn для key, если такой вызов ещё не идёт, иначе ждёт текущий.
+// fn запускается в отдельной горутине и не зависит от ctx вызывающего: отмена
+// одного запроса не обрывает работу для остальных ожидающих. Если к вызову
+// с более низким приоритетом присоединяется вызывающий с PriorityHigh,
+// закрывается канал boost, переданный fn, – fn поднимает приоритет работы.
+func (g *flightGroup) Do(ctx context.Context, key string, priority domain.JobPriority, fn func(boost <-chan struct{}) ([]byte, error)) ([]byte, error) {
+	g.mu.Lock()
+	call, ok := g.calls[key]
+	if !ok {
+		call = &flightCall{done: make(chan struct{}), boost: make(chan struct{}), priority: priority}
+		g.calls[key] = call
+		go func() {
+			call.data, call.err = fn(call.boost)
+			g.mu.Lock()
+			delete(g.calls, key)
+			g.mu.Unlock()
+			close(call.done)
+		}()
+	} else if priority == domain.PriorityHigh && call.priority != domain.PriorityHigh {
+		call.priority = domain.PriorityHigh
+		close(call.boost)
+	}
+	g.mu.Unlock()
+
+	select {
+	case <-call.done:
+		return call.data, call.err
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
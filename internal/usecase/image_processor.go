@@ -3,24 +3,57 @@ package usecase
 import (
 	"bytes"
 	"context"
+	"encoding/base64"
 	"fmt"
+	"image"
+	"image/draw"
+	"image/png"
 	"io"
 	"log"
+	"math"
+	"sync"
 	"time"
 
+	"github.com/buckket/go-blurhash"
 	"github.com/google/uuid"
 
 	"github.com/freshtea599/PhotoHubServer.git/internal/domain"
 	"github.com/freshtea599/PhotoHubServer.git/internal/repository"
+	"github.com/freshtea599/PhotoHubServer.git/pkg/thumbhash"
+	"github.com/freshtea599/PhotoHubServer.git/pkg/transform"
 	vipsproc "github.com/freshtea599/PhotoHubServer.git/pkg/vips"
 )
 
+const (
+	// variantLockPoll – период опроса, пока вариант генерирует другой инстанс.
+	variantLockPoll = 200 * time.Millisecond
+	// jitTimeout – сколько клиент ждёт генерации варианта по запросу.
+	jitTimeout = 30 * time.Second
+	// placeholderSourceSize – наибольшая сторона превью, по которому
+	// считаются заглушки и палитра (больше ThumbHash не принимает).
+	placeholderSourceSize = thumbhash.MaxSize
+	// blurHashComponents – число компонент BlurHash по длинной стороне.
+	blurHashComponents = 4
+	// placeholderBackfillBatch – сколько фото дозаполнение берёт за один запрос.
+	placeholderBackfillBatch = 100
+)
+
 type ImageProcessor struct {
 	pool      *WorkerPool
+	flights   *flightGroup
 	redisRepo *repository.RedisRepo
 	minioRepo *repository.MinioRepo
 	photoRepo *repository.PostgresPhotoRepo
 	vipsProc  *vipsproc.Processor
+	// ширина эталонной копии, по которой подбирается качество для q=auto
+	autoQualityWidth int
+	// порог расстояния между хэшами при сверке с отклонёнными фото
+	duplicateMaxDistance int
+
+	// фоновые задачи (предгенерация вариантов), которые нужно дождаться при остановке
+	wg     sync.WaitGroup
+	ctx    context.Context
+	cancel context.CancelFunc
 }
 
 func NewImageProcessor(
@@ -29,96 +62,433 @@ func NewImageProcessor(
 	minioRepo *repository.MinioRepo,
 	redisRepo *repository.RedisRepo,
 	photoRepo *repository.PostgresPhotoRepo,
+	autoQualityWidth int,
+	duplicateMaxDistance int,
 ) (*ImageProcessor, error) {
+	ctx, cancel := context.WithCancel(context.Background())
 	ip := &ImageProcessor{
-		redisRepo: redisRepo,
-		minioRepo: minioRepo,
-		photoRepo: photoRepo,
-		vipsProc:  vipsProc,
+		flights:              newFlightGroup(),
+		redisRepo:            redisRepo,
+		minioRepo:            minioRepo,
+		photoRepo:            photoRepo,
+		vipsProc:             vipsProc,
+		autoQualityWidth:     autoQualityWidth,
+		duplicateMaxDistance: duplicateMaxDistance,
+		ctx:                  ctx,
+		cancel:               cancel,
 	}
 	ip.pool = NewWorkerPool(numWorkers, ip.processJob)
 	ip.pool.Start()
 	return ip, nil
 }
 
+// Shutdown останавливает фоновые задачи, затем пул воркеров.
 func (ip *ImageProcessor) Shutdown() {
+	ip.cancel()
+	ip.wg.Wait()
 	ip.pool.Shutdown()
 }
 
+// GetVariant возвращает поток с вариантом фото для заданных параметров трансформации,
+// генерируя вариант при отсутствии в хранилище. opts должны быть нормализованы и провалидированы.
+// Готовый вариант читается из MinIO потоково; вызывающий должен закрыть Object.
 func (ip *ImageProcessor) GetVariant(
 	ctx context.Context,
-	photoID int64,
-	sizeName string,
-	width int,
-	format string,
-	quality int,
-) ([]byte, string, error) {
-	// Проверка кэша Redis
-	cachedKey, err := ip.redisRepo.GetVariantKey(ctx, photoID, sizeName, format)
-	if err == nil && cachedKey != "" {
-		reader, err := ip.minioRepo.GetVariant(ctx, cachedKey)
-		if err == nil {
-			defer reader.Close()
-			data, err := io.ReadAll(reader)
-			if err == nil {
-				return data, mimeTypeForFormat(format), nil
+	photo *domain.Photo,
+	opts transform.Options,
+) (*repository.Object, error) {
+	// Готовый вариант (Redis, затем Postgres)
+	if obj, ok := ip.openCached(ctx, photo.ID, opts); ok {
+		return obj, nil
+	}
+
+	// Генерируем с высоким приоритетом – клиент ждёт ответа
+	ctx, cancel := context.WithTimeout(ctx, jitTimeout)
+	defer cancel()
+
+	data, err := ip.produce(ctx, photo.ID, photo.EditVersion, opts, domain.PriorityHigh, jitTimeout)
+	if err != nil {
+		return nil, err
+	}
+	return repository.NewBytesObject(data, transform.MimeType(opts.Format)), nil
+}
+
+// openCached открывает готовый вариант в MinIO. Путь к объекту берётся из
+// Redis, а при промахе – из photo_variants, после чего Redis заполняется снова.
+func (ip *ImageProcessor) openCached(ctx context.Context, photoID int64, opts transform.Options) (*repository.Object, bool) {
+	hash := opts.Hash()
+	if path, err := ip.redisRepo.GetVariantKey(ctx, photoID, hash); err == nil && path != "" {
+		if obj, err := ip.minioRepo.GetVariant(ctx, path); err == nil {
+			return obj, true
+		}
+		log.Printf("cached variant not found in MinIO: %s", path)
+		if err := ip.redisRepo.ForgetVariantKey(ctx, photoID, hash); err != nil {
+			log.Printf("Redis cache error: %v", err)
+		}
+	}
+
+	variant, err := ip.photoRepo.GetVariant(photoID, opts.Key(), opts.Format)
+	if err != nil {
+		log.Printf("DB variant lookup error: %v", err)
+		return nil, false
+	}
+	if variant == nil {
+		return nil, false
+	}
+	obj, err := ip.minioRepo.GetVariant(ctx, variant.FilePath)
+	if err != nil {
+		log.Printf("variant %s not found in MinIO, will regenerate: %v", variant.FilePath, err)
+		return nil, false
+	}
+	if err := ip.redisRepo.CacheVariantKey(ctx, photoID, hash, variant.FilePath); err != nil {
+		log.Printf("Redis cache error: %v", err)
+	}
+	return obj, true
+}
+
+// variantPath – детерминированный путь объекта варианта в бакете variants:
+// одинаковые параметры в одной версии правки всегда попадают в один и тот же
+// объект. Версия в пути не даёт устаревшей генерации затереть или удалить
+// объект текущей правки с теми же параметрами (например, после отката).
+func variantPath(photoID int64, editVersion int, opts transform.Options) string {
+	return fmt.Sprintf("%d/v%d/%s.%s", photoID, editVersion, opts.Hash(), opts.Format)
+}
+
+// produce гарантирует, что одинаковые варианты генерируются ровно один раз:
+// внутри процесса одновременные запросы объединяются через flightGroup,
+// между инстансами – через блокировку в Redis по ключу варианта.
+// timeout ограничивает саму генерацию и не зависит от ctx вызывающего.
+// Если фоновой генерации начинает ждать клиент, её задача поднимается до
+// высокого приоритета, а время на неё сокращается до jitTimeout.
+// editVersion – версия правки фото, для которой запрошен вариант (см. generate).
+func (ip *ImageProcessor) produce(ctx context.Context, photoID int64, editVersion int, opts transform.Options, priority domain.JobPriority, timeout time.Duration) ([]byte, error) {
+	key := fmt.Sprintf("variant:%d:%s", photoID, opts.Hash())
+	return ip.flights.Do(ctx, key, priority, func(boost <-chan struct{}) ([]byte, error) {
+		fctx, cancel := context.WithTimeout(ip.ctx, timeout)
+		defer cancel()
+		if timeout > jitTimeout {
+			go func() {
+				select {
+				case <-boost:
+					time.AfterFunc(jitTimeout, cancel)
+				case <-fctx.Done():
+				}
+			}()
+		}
+
+		for {
+			// вариант мог появиться, пока другой инстанс держал блокировку
+			if obj, ok := ip.openCached(fctx, photoID, opts); ok {
+				defer obj.Close()
+				return io.ReadAll(obj)
+			}
+			token, locked, err := ip.redisRepo.TryLock(fctx, key, timeout)
+			if err != nil {
+				log.Printf("variant lock unavailable, generating without it: %v", err)
+				return ip.generate(fctx, photoID, editVersion, opts, priority, boost)
+			}
+			if locked {
+				data, err := ip.generate(fctx, photoID, editVersion, opts, priority, boost)
+				if unlockErr := ip.redisRepo.Unlock(context.Background(), key, token); unlockErr != nil {
+					log.Printf("failed to release variant lock %s: %v", key, unlockErr)
+				}
+				return data, err
+			}
+			// вариант генерирует другой инстанс – ждём результата или освобождения блокировки
+			select {
+			case <-time.After(variantLockPoll):
+			case <-fctx.Done():
+				return nil, fctx.Err()
+			}
+		}
+	})
+}
+
+// PregenerateVariants в фоне определяет размеры, заглушки и палитру фото и генерирует его варианты
+// через очередь низкого приоритета, чтобы первый зритель не ждал JIT-трансформации.
+// Статус обработки фото обновляется по ходу: processing -> ready
+// (или failed, если часть вариантов не удалась). editVersion – версия правки,
+// к которой относятся variants.
+func (ip *ImageProcessor) PregenerateVariants(photoID int64, editVersion int, variants []transform.Options) {
+	ip.wg.Add(1)
+	go func() {
+		defer ip.wg.Done()
+		if err := ip.photoRepo.SetProcessingStatus(photoID, domain.ProcessingInProgress); err != nil {
+			log.Printf("failed to update processing status of photo %d: %v", photoID, err)
+		}
+
+		if err := ip.analyze(photoID, editVersion); err != nil {
+			log.Printf("analysis of photo %d failed: %v", photoID, err)
+		}
+
+		failed := 0
+		for _, opts := range variants {
+			if ip.ctx.Err() != nil {
+				// сервер останавливается – недоделанные варианты сгенерируются по запросу
+				return
+			}
+			// низкоприоритетная задача может долго стоять в очереди за JIT-запросами
+			_, err := ip.produce(ip.ctx, photoID, editVersion, opts, domain.PriorityLow, 10*time.Minute)
+			if err != nil {
+				failed++
+				log.Printf("eager variant %s/%s for photo %d failed: %v", opts.Key(), opts.Format, photoID, err)
+			}
+		}
+
+		status := domain.ProcessingReady
+		if failed > 0 {
+			status = domain.ProcessingFailed
+		}
+		if err := ip.photoRepo.SetProcessingStatus(photoID, status); err != nil {
+			log.Printf("failed to update processing status of photo %d: %v", photoID, err)
+		}
+	}()
+}
+
+// InvalidateVariants удаляет все готовые варианты фото: записи в
+// photo_variants, объекты в MinIO и пути в Redis, а также подобранное для
+// q=auto качество. Вызывается, когда варианты перестают соответствовать фото
+// (например, после правки).
+func (ip *ImageProcessor) InvalidateVariants(ctx context.Context, photoID int64) error {
+	if err := ip.photoRepo.DeleteVariants(photoID); err != nil {
+		return fmt.Errorf("delete variant records: %w", err)
+	}
+	if err := ip.photoRepo.DeleteAutoQuality(photoID); err != nil {
+		return fmt.Errorf("delete auto quality: %w", err)
+	}
+	if err := ip.minioRepo.DeleteVariants(ctx, photoID); err != nil {
+		return fmt.Errorf("delete variant objects: %w", err)
+	}
+	if err := ip.redisRepo.ForgetVariantKeys(ctx, photoID); err != nil {
+		return fmt.Errorf("forget variant keys: %w", err)
+	}
+	return nil
+}
+
+// BackfillPlaceholders в фоне считает заглушки, палитру и перцептивный хэш
+// фото, загруженных до их появления (ThumbHash или хэш ещё не посчитан). Фото обрабатываются по одному
+// задачами низкого приоритета, поэтому JIT-запросы вариантов не ждут.
+// Новые загрузки и правки считаются в PregenerateVariants и здесь не трогаются.
+func (ip *ImageProcessor) BackfillPlaceholders() {
+	ip.wg.Add(1)
+	go func() {
+		defer ip.wg.Done()
+		started := time.Now()
+		var lastID int64
+		done, failed := 0, 0
+		for ip.ctx.Err() == nil {
+			photos, err := ip.photoRepo.PhotosWithoutAnalysis(started, lastID, placeholderBackfillBatch)
+			if err != nil {
+				log.Printf("placeholder backfill: failed to list photos: %v", err)
+				return
+			}
+			if len(photos) == 0 {
+				break
+			}
+			for _, p := range photos {
+				if ip.ctx.Err() != nil {
+					return
+				}
+				// фото с ошибкой пропускается, чтобы не повторять его бесконечно
+				if err := ip.analyze(p.ID, p.EditVersion); err != nil {
+					failed++
+					log.Printf("placeholder backfill: photo %d failed: %v", p.ID, err)
+				} else {
+					done++
+				}
+				lastID = p.ID
+			}
+		}
+		if done > 0 || failed > 0 {
+			log.Printf("placeholder backfill finished: %d photos updated, %d failed", done, failed)
+		}
+	}()
+}
+
+// BackfillColorIndex в фоне индексирует для поиска по цвету палитры фото,
+// посчитанные до появления индекса. Палитра уже есть в photos.palette,
+// поэтому изображения не декодируются.
+func (ip *ImageProcessor) BackfillColorIndex() {
+	ip.wg.Add(1)
+	go func() {
+		defer ip.wg.Done()
+		var lastID int64
+		done := 0
+		for ip.ctx.Err() == nil {
+			photos, err := ip.photoRepo.PhotosWithUnindexedColors(lastID, placeholderBackfillBatch)
+			if err != nil {
+				log.Printf("color index backfill: failed to list photos: %v", err)
+				return
+			}
+			if len(photos) == 0 {
+				break
+			}
+			for _, p := range photos {
+				// фото, чью палитру успели пересчитать, проиндексировал сам пересчёт
+				if indexed, err := ip.photoRepo.SetColors(p.ID, p.Palette); err != nil {
+					log.Printf("color index backfill: photo %d failed: %v", p.ID, err)
+				} else if indexed {
+					done++
+				}
+				lastID = p.ID
 			}
 		}
-		log.Printf("cached variant not found in MinIO, will regenerate: %s", cachedKey)
+		if done > 0 {
+			log.Printf("color index backfill finished: %d photos indexed", done)
+		}
+	}()
+}
+
+// analyze определяет размеры, число кадров, заглушки (BlurHash, ThumbHash) и
+// палитру оригинала через libvips: пул строит маленькое превью первого кадра
+// с прозрачностью и сообщает сведения об источнике. Так поддерживаются
+// форматы, которые не умеет декодировать Go (HEIC, TIFF, JPEG XL, WebP), и
+// полный оригинал не держится в памяти обработчика загрузки. Результаты
+// сохраняются, только если фото всё ещё в версии правки editVersion.
+func (ip *ImageProcessor) analyze(photoID int64, editVersion int) error {
+	ctx, cancel := context.WithTimeout(ip.ctx, 10*time.Minute)
+	defer cancel()
+
+	result, err := ip.pool.Submit(ctx, domain.Job{
+		ID:          uuid.New(),
+		Kind:        domain.JobAnalyze,
+		PhotoID:     photoID,
+		EditVersion: editVersion,
+		Options:     transform.Options{Width: placeholderSourceSize},
+		Priority:    domain.PriorityLow,
+		CreatedAt:   time.Now().Unix(),
+	})
+	if err != nil {
+		return fmt.Errorf("job submission failed: %w", err)
+	}
+	if result.Err != nil {
+		return result.Err
 	}
+	img, err := png.Decode(bytes.NewReader(result.Data))
+	if err != nil {
+		return fmt.Errorf("decode preview: %w", err)
+	}
+	thumb, err := thumbhash.Encode(img)
+	if err != nil {
+		return fmt.Errorf("encode thumbhash: %w", err)
+	}
+	// BlurHash не хранит пропорции и прозрачность: число компонент
+	// подбирается по сторонам, прозрачные области заливаются белым
+	xc, yc := blurHashGrid(img.Bounds().Dx(), img.Bounds().Dy())
+	hash, err := blurhash.Encode(xc, yc, flatten(img))
+	if err != nil {
+		return fmt.Errorf("encode blurhash: %w", err)
+	}
+	saved, err := ip.photoRepo.SetImageInfo(photoID, editVersion, domain.ImageInfo{
+		Width:      result.SourceWidth,
+		Height:     result.SourceHeight,
+		FrameCount: max(result.SourceFrames, 1),
+		DurationMs: result.SourceDurationMs,
+		BlurHash:   hash,
+		ThumbHash:  base64.StdEncoding.EncodeToString(thumb),
+		Palette:    result.Palette,
+		PHash:      result.PHash,
+	})
+	if err != nil {
+		return err
+	}
+	if !saved {
+		log.Printf("photo %d was edited during analysis, discarding results for edit version %d", photoID, editVersion)
+		return nil
+	}
+	// хэш посчитан впервые – фото на модерации сверяется с отклонёнными
+	if result.PHash != nil {
+		if err := ip.photoRepo.MatchRejected(photoID, ip.duplicateMaxDistance); err != nil {
+			return fmt.Errorf("match rejected photos: %w", err)
+		}
+	}
+	return nil
+}
 
-	// Проверка существования фото
-	if _, err := ip.photoRepo.GetByID(photoID); err != nil {
-		return nil, "", fmt.Errorf("photo not found: %w", err)
+// blurHashGrid возвращает число компонент BlurHash по горизонтали и вертикали
+// для изображения width×height: blurHashComponents по длинной стороне и
+// пропорционально меньше по короткой.
+func blurHashGrid(width, height int) (int, int) {
+	short := func(side, long int) int {
+		return max(1, int(math.Round(float64(blurHashComponents*side)/float64(long))))
 	}
+	if width >= height {
+		return blurHashComponents, short(height, width)
+	}
+	return short(width, height), blurHashComponents
+}
 
-	// Создаём задачу с высоким приоритетом
+// flatten накладывает изображение на белый фон.
+func flatten(img image.Image) image.Image {
+	b := img.Bounds()
+	out := image.NewRGBA(b)
+	draw.Draw(out, b, image.White, image.Point{}, draw.Src)
+	draw.Draw(out, b, img, b.Min, draw.Over)
+	return out
+}
+
+// generate выполняет трансформацию в пуле с заданным приоритетом и сохраняет
+// результат в MinIO, Postgres и кэш Redis. Закрытие boost поднимает задачу,
+// ещё стоящую в очереди, до высокого приоритета. Если за время генерации
+// правка фото сменилась (версия уже не editVersion), результат отдаётся
+// ждущему клиенту, но не сохраняется: варианты прежней правки уже удалены
+// InvalidateVariants и не должны появиться снова.
+func (ip *ImageProcessor) generate(ctx context.Context, photoID int64, editVersion int, opts transform.Options, priority domain.JobPriority, boost <-chan struct{}) ([]byte, error) {
 	job := domain.Job{
 		ID:        uuid.New(),
 		PhotoID:   photoID,
-		Width:     width,
-		Format:    format,
-		Quality:   quality,
-		Priority:  domain.PriorityHigh,
+		Options:   opts,
+		Priority:  priority,
 		CreatedAt: time.Now().Unix(),
+		Boost:     boost,
 	}
 
-	ctx, cancel := context.WithTimeout(ctx, 30*time.Second)
-	defer cancel()
-
 	result, err := ip.pool.Submit(ctx, job)
 	if err != nil {
-		return nil, "", fmt.Errorf("job submission failed: %w", err)
+		return nil, fmt.Errorf("job submission failed: %w", err)
 	}
 	if result.Err != nil {
-		return nil, "", fmt.Errorf("processing error: %w", result.Err)
+		return nil, fmt.Errorf("processing error: %w", result.Err)
 	}
 
 	// Сохраняем результат в MinIO
-	variantKey := fmt.Sprintf("%d/%s/%s/%s", photoID, sizeName, format, job.ID.String())
-	err = ip.minioRepo.PutVariant(ctx, variantKey, bytes.NewReader(result.Data), int64(len(result.Data)), mimeTypeForFormat(format))
+	path := variantPath(photoID, editVersion, opts)
+	err = ip.minioRepo.PutVariant(ctx, path, bytes.NewReader(result.Data), int64(len(result.Data)), transform.MimeType(opts.Format))
 	if err != nil {
 		log.Printf("failed to save variant to MinIO: %v", err)
-	} else {
-		// Сохраняем запись в БД
-		variant := &domain.PhotoVariant{
-			PhotoID:  photoID,
-			SizeName: sizeName,
-			Format:   format,
-			FilePath: variantKey,
-			Width:    width,
-			Quality:  quality,
-		}
-		if dbErr := ip.photoRepo.CreateVariant(variant); dbErr != nil {
-			log.Printf("DB variant save error: %v", dbErr)
+		return result.Data, nil
+	}
+
+	// Сохраняем запись в БД – по ней вариант находится после истечения кэша Redis
+	variant := &domain.PhotoVariant{
+		PhotoID:  photoID,
+		SizeName: opts.Key(),
+		Format:   opts.Format,
+		FilePath: path,
+		FileSize: int64(len(result.Data)),
+		Width:    result.Width,
+		Height:   result.Height,
+		Quality:  result.Quality,
+	}
+	saved, err := ip.photoRepo.CreateVariant(variant, editVersion)
+	if err != nil {
+		log.Printf("DB variant save error: %v", err)
+	}
+	if !saved {
+		if err == nil {
+			log.Printf("photo %d was edited during generation, discarding variant %s", photoID, path)
 		}
-		// Кэшируем ключ в Redis
-		if cacheErr := ip.redisRepo.CacheVariantKey(ctx, photoID, sizeName, format, variantKey); cacheErr != nil {
-			log.Printf("Redis cache error: %v", cacheErr)
+		if err := ip.minioRepo.DeleteVariant(context.Background(), path); err != nil {
+			log.Printf("failed to delete discarded variant %s: %v", path, err)
 		}
+		return result.Data, nil
+	}
+	// Кэшируем путь в Redis
+	if err := ip.redisRepo.CacheVariantKey(ctx, photoID, opts.Hash(), path); err != nil {
+		log.Printf("Redis cache error: %v", err)
 	}
 
-	return result.Data, mimeTypeForFormat(format), nil
+	return result.Data, nil
 }
 
 func (ip *ImageProcessor) processJob(job domain.Job) domain.JobResult {
@@ -130,35 +500,105 @@ func (ip *ImageProcessor) processJob(job domain.Job) domain.JobResult {
 		return domain.JobResult{Job: job, Err: fmt.Errorf("photo not found: %w", err)}
 	}
 
-	reader, err := ip.minioRepo.GetOriginal(ctx, photo.FilePath)
+	original, err := ip.minioRepo.GetOriginal(ctx, photo.FilePath)
 	if err != nil {
 		return domain.JobResult{Job: job, Err: fmt.Errorf("failed to read original: %w", err)}
 	}
-	defer reader.Close()
+	defer original.Close()
 
-	originalBytes, err := io.ReadAll(reader)
-	if err != nil {
+	// libvips декодирует из буфера; буфер выделяется сразу под размер объекта,
+	// а число одновременно прочитанных оригиналов ограничено числом воркеров
+	buf := bytes.NewBuffer(make([]byte, 0, original.Size))
+	if _, err := buf.ReadFrom(original); err != nil {
 		return domain.JobResult{Job: job, Err: fmt.Errorf("failed to read original data: %w", err)}
 	}
 
-	data, err := ip.vipsProc.Transform(originalBytes, job.Width, job.Format, job.Quality)
+	var result *vipsproc.Result
+	switch job.Kind {
+	case domain.JobAnalyze:
+		// заглушки, палитра и превью строятся по правке задачи, перцептивный
+		// хэш оригинала – один раз
+		if photo.EditVersion != job.EditVersion {
+			return domain.JobResult{Job: job, Err: fmt.Errorf("photo %d was edited: analysis of edit version %d is outdated", photo.ID, job.EditVersion)}
+		}
+		result, err = ip.vipsProc.Analyze(buf.Bytes(), job.Options.Width, photo.Edit, photo.PHash == nil)
+	default:
+		var watermark []byte
+		if wm := job.Options.Watermark; wm != nil && wm.Image != "" {
+			if watermark, err = ip.minioRepo.GetWatermark(ctx, wm.Image); err != nil {
+				return domain.JobResult{Job: job, Err: err}
+			}
+		}
+		result, err = ip.transform(job, buf.Bytes(), watermark)
+	}
 	if err != nil {
 		return domain.JobResult{Job: job, Err: fmt.Errorf("vips transform error: %w", err)}
 	}
 
 	return domain.JobResult{
-		Job:  job,
-		Data: data,
+		Job:          job,
+		Data:         result.Data,
+		Width:        result.Width,
+		Height:       result.Height,
+		Quality:      result.Quality,
+		SourceWidth:  result.SourceWidth,
+		SourceHeight: result.SourceHeight,
+
+		SourceFrames:     result.SourceFrames,
+		SourceDurationMs: result.SourceDurationMs,
+		Palette:          result.Palette,
+		PHash:            result.PHash,
 	}
 }
 
-func mimeTypeForFormat(format string) string {
-	switch format {
-	case "webp":
-		return "image/webp"
-	case "avif":
-		return "image/avif"
-	default:
-		return "image/jpeg"
+// transform выполняет трансформацию задачи. Для q=auto качество подбирается
+// один раз на фото и формат: найденное значение сохраняется и дальше
+// используется для всех размеров, поэтому поиск не повторяется. Поиск идёт
+// по эталонной копии – фото с текущей правкой шириной autoQualityWidth без
+// обрезки, коррекций и знака, – а не по первому запрошенному варианту:
+// превью, размытая или обрезанная копия дали бы качество, неверное для
+// остальных.
+func (ip *ImageProcessor) transform(job domain.Job, data, watermark []byte) (*vipsproc.Result, error) {
+	opts := job.Options
+	if opts.Quality != transform.QualityAuto {
+		return ip.vipsProc.Transform(data, opts, watermark)
+	}
+	quality, err := ip.photoRepo.GetAutoQuality(job.PhotoID, opts.Format)
+	if err != nil {
+		log.Printf("auto quality lookup for photo %d failed: %v", job.PhotoID, err)
+	}
+	if quality > 0 {
+		opts.Quality = quality
+		return ip.vipsProc.Transform(data, opts, watermark)
+	}
+
+	reference := transform.Options{
+		Width:    ip.autoQualityWidth,
+		Format:   opts.Format,
+		Quality:  transform.QualityAuto,
+		Encoding: opts.Encoding,
+		Edit:     opts.Edit,
+	}
+	// у анимации качество подбирается по первому кадру
+	if transform.Animatable(opts.Format) {
+		reference.Frame = 1
+	}
+	reference.Normalize()
+	result, err := ip.vipsProc.Transform(data, reference, nil)
+	if err != nil {
+		return nil, fmt.Errorf("auto quality reference: %w", err)
+	}
+	if result.Quality > 0 {
+		if err := ip.photoRepo.SetAutoQuality(job.PhotoID, opts.Format, result.Quality); err != nil {
+			log.Printf("failed to save auto quality for photo %d: %v", job.PhotoID, err)
+		}
+	}
+	if opts.Key() == reference.Key() {
+		return result, nil
+	}
+	// png, gif и lossless кодируются без поиска – качество им не нужно
+	if result.Quality > 0 {
+		opts.Quality = result.Quality
 	}
+	return ip.vipsProc.Transform(data, opts, watermark)
 }
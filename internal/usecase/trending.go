@@ -0,0 +1,86 @@
+// backend/internal/usecase/trending.go
+package usecase
+
+import (
+	"context"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/freshtea599/PhotoHubServer.git/internal/domain"
+	"github.com/freshtea599/PhotoHubServer.git/internal/repository"
+)
+
+// Веса событий в trending-рейтинге.
+const (
+	trendingLikeWeight    = 1.0
+	trendingCommentWeight = 3.0
+	trendingViewWeight    = 0.05
+)
+
+// TrendingRanker периодически пересчитывает trending-рейтинг по всем окнам
+// и публикует его в Redis.
+type TrendingRanker struct {
+	photoRepo *repository.PostgresPhotoRepo
+	redisRepo *repository.RedisRepo
+	interval  time.Duration
+
+	wg     sync.WaitGroup
+	ctx    context.Context
+	cancel context.CancelFunc
+}
+
+// NewTrendingRanker создаёт задачу пересчёта рейтинга с заданным интервалом.
+func NewTrendingRanker(photoRepo *repository.PostgresPhotoRepo, redisRepo *repository.RedisRepo, interval time.Duration) *TrendingRanker {
+	if interval <= 0 {
+		interval = 5 * time.Minute
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	return &TrendingRanker{
+		photoRepo: photoRepo,
+		redisRepo: redisRepo,
+		interval:  interval,
+		ctx:       ctx,
+		cancel:    cancel,
+	}
+}
+
+// Start запускает пересчёт сразу и далее по таймеру.
+func (tr *TrendingRanker) Start() {
+	tr.wg.Add(1)
+	go func() {
+		defer tr.wg.Done()
+		ticker := time.NewTicker(tr.interval)
+		defer ticker.Stop()
+		for {
+			tr.Refresh(tr.ctx)
+			select {
+			case <-ticker.C:
+			case <-tr.ctx.Done():
+				return
+			}
+		}
+	}()
+}
+
+// Shutdown останавливает фоновый пересчёт и ждёт его завершения.
+func (tr *TrendingRanker) Shutdown() {
+	tr.cancel()
+	tr.wg.Wait()
+}
+
+// Refresh пересчитывает рейтинг по всем окнам. Ошибки логируются,
+// чтобы сбой одного окна не блокировал остальные.
+func (tr *TrendingRanker) Refresh(ctx context.Context) {
+	for _, window := range domain.TrendingWindows {
+		scores, err := tr.photoRepo.TrendingScores(window, trendingLikeWeight, trendingCommentWeight, trendingViewWeight)
+		if err != nil {
+			log.Printf("trending %s: failed to compute scores: %v", window, err)
+			continue
+		}
+		// Снимок живёт несколько интервалов, чтобы курсоры не протухали во время листания.
+		if _, err := tr.redisRepo.ReplaceTrending(ctx, window, scores, 3*tr.interval); err != nil {
+			log.Printf("trending %s: failed to publish scores: %v", window, err)
+		}
+	}
+}
@@ -0,0 +1,115 @@
+// backend/internal/usecase/view_stats.go
+package usecase
+
+import (
+	"context"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/freshtea599/PhotoHubServer.git/internal/domain"
+	"github.com/freshtea599/PhotoHubServer.git/internal/repository"
+)
+
+// viewFlushBatch – сколько дневных бакетов переносится в Postgres за один проход.
+const viewFlushBatch = 500
+
+// ViewStatsFlusher периодически переносит счётчики просмотров из Redis
+// в дневные агрегаты Postgres.
+type ViewStatsFlusher struct {
+	photoRepo *repository.PostgresPhotoRepo
+	redisRepo *repository.RedisRepo
+	interval  time.Duration
+
+	wg     sync.WaitGroup
+	ctx    context.Context
+	cancel context.CancelFunc
+}
+
+// NewViewStatsFlusher создаёт задачу сброса статистики с заданным интервалом.
+func NewViewStatsFlusher(photoRepo *repository.PostgresPhotoRepo, redisRepo *repository.RedisRepo, interval time.Duration) *ViewStatsFlusher {
+	if interval <= 0 {
+		interval = time.Minute
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	return &ViewStatsFlusher{
+		photoRepo: photoRepo,
+		redisRepo: redisRepo,
+		interval:  interval,
+		ctx:       ctx,
+		cancel:    cancel,
+	}
+}
+
+// Start запускает периодический сброс.
+func (f *ViewStatsFlusher) Start() {
+	f.wg.Add(1)
+	go func() {
+		defer f.wg.Done()
+		ticker := time.NewTicker(f.interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				f.Flush(f.ctx)
+			case <-f.ctx.Done():
+				return
+			}
+		}
+	}()
+}
+
+// Shutdown останавливает фоновую задачу и делает финальный сброс,
+// чтобы не потерять просмотры, накопленные с последнего прохода.
+func (f *ViewStatsFlusher) Shutdown() {
+	f.cancel()
+	f.wg.Wait()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	f.Flush(ctx)
+}
+
+// Flush переносит все «грязные» бакеты пачками по viewFlushBatch.
+func (f *ViewStatsFlusher) Flush(ctx context.Context) {
+	for {
+		buckets, err := f.redisRepo.PopDirtyViewBuckets(ctx, viewFlushBatch)
+		if err != nil {
+			log.Printf("view stats: failed to read buckets: %v", err)
+		}
+		if len(buckets) == 0 {
+			return
+		}
+		if err := f.photoRepo.SaveViewBuckets(buckets); err != nil {
+			log.Printf("view stats: failed to save %d buckets: %v", len(buckets), err)
+			if !f.saveSeparately(ctx, buckets) {
+				return
+			}
+		}
+		if len(buckets) < viewFlushBatch || err != nil {
+			return
+		}
+	}
+}
+
+// saveSeparately записывает бакеты по одному после ошибки записи пачки.
+// Бакет, который Postgres отвергает из-за данных, отбрасывается – иначе он
+// возвращался бы в очередь вечно и блокировал сброс остальных. При любой
+// другой ошибке оставшиеся бакеты возвращаются в очередь и сброс прерывается
+// (возвращается false).
+func (f *ViewStatsFlusher) saveSeparately(ctx context.Context, buckets []domain.ViewBucket) bool {
+	for i, b := range buckets {
+		err := f.photoRepo.SaveViewBuckets([]domain.ViewBucket{b})
+		switch {
+		case err == nil:
+		case repository.IsDataError(err):
+			log.Printf("view stats: dropping bucket %d:%s: %v", b.PhotoID, b.Day.Format(time.DateOnly), err)
+		default:
+			log.Printf("view stats: failed to save bucket %d:%s: %v", b.PhotoID, b.Day.Format(time.DateOnly), err)
+			if rqErr := f.redisRepo.RequeueViewBuckets(ctx, buckets[i:]); rqErr != nil {
+				log.Printf("view stats: failed to requeue buckets: %v", rqErr)
+			}
+			return false
+		}
+	}
+	return true
+}
@@ -3,12 +3,17 @@ package usecase
 
 import (
 	"context"
+	"errors"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/freshtea599/PhotoHubServer.git/internal/domain"
 )
 
+// errPoolStopped возвращается Submit после остановки пула.
+var errPoolStopped = errors.New("worker pool stopped")
+
 // WorkerPool управляет фиксированным пулом горутин для обработки изображений.
 type WorkerPool struct {
 	highJobs chan domain.Job
@@ -46,53 +51,112 @@ func (wp *WorkerPool) Start() {
 	}
 }
 
-// Shutdown завершает работу пула (закрывает каналы и ждёт завершения воркеров).
+// Shutdown завершает работу пула и ждёт завершения воркеров. Каналы задач не
+// закрываются: Submit может вызываться из горутин, которые пул не отслеживает,
+// – после остановки он сразу возвращает ошибку.
 func (wp *WorkerPool) Shutdown() {
 	wp.cancel()
-	// Закрываем каналы, чтобы воркеры вышли из select.
-	close(wp.highJobs)
-	close(wp.midJobs)
-	close(wp.lowJobs)
 	wp.wg.Wait()
 }
 
 // Submit отправляет задачу в пул и ожидает результат.
 // Возвращает результат или ошибку, если истекло время ожидания.
+// Если у задачи задан Boost, после его закрытия копия задачи ставится в
+// очередь высокого приоритета – выполнится та копия, что раньше попадёт
+// к воркеру.
 func (wp *WorkerPool) Submit(ctx context.Context, job domain.Job) (domain.JobResult, error) {
 	// Создаём канал для получения результата (каждая задача несёт свой канал).
 	resultChan := make(chan domain.JobResult, 1)
 	job.ResultChan = resultChan
+	boost := job.Boost
+	if job.Priority == domain.PriorityHigh {
+		boost = nil
+	}
+	if boost != nil {
+		job.Claimed = new(atomic.Bool)
+	}
 
 	// Отправляем задачу в соответствующий приоритетный канал.
+	queue := wp.lowJobs
 	switch job.Priority {
 	case domain.PriorityHigh:
+		queue = wp.highJobs
+	case domain.PriorityMedium:
+		queue = wp.midJobs
+	}
+	select {
+	case queue <- job:
+	case <-boost:
+		// очередь переполнена, а клиент уже ждёт – сразу в высокий приоритет
+		boost = nil
+		job.Priority = domain.PriorityHigh
 		select {
 		case wp.highJobs <- job:
 		case <-ctx.Done():
 			return domain.JobResult{}, ctx.Err()
+		case <-wp.ctx.Done():
+			return domain.JobResult{}, errPoolStopped
 		}
-	case domain.PriorityMedium:
+	case <-ctx.Done():
+		return domain.JobResult{}, ctx.Err()
+	case <-wp.ctx.Done():
+		return domain.JobResult{}, errPoolStopped
+	}
+
+	// Ожидаем результат с таймаутом. Если вызывающий не задал дедлайн,
+	// ждём не дольше 30 секунд (защита от зависания).
+	if _, ok := ctx.Deadline(); !ok {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, 30*time.Second)
+		defer cancel()
+	}
+	for {
 		select {
-		case wp.midJobs <- job:
+		case res := <-resultChan:
+			return res, nil
+		case <-boost:
+			boost = nil
+			job.Priority = domain.PriorityHigh
+			select {
+			case wp.highJobs <- job:
+			case res := <-resultChan:
+				return res, nil
+			case <-ctx.Done():
+				return domain.JobResult{}, ctx.Err()
+			case <-wp.ctx.Done():
+				return domain.JobResult{}, errPoolStopped
+			}
 		case <-ctx.Done():
 			return domain.JobResult{}, ctx.Err()
+		case <-wp.ctx.Done():
+			return domain.JobResult{}, errPoolStopped
 		}
-	default:
+	}
+}
+
+// next возвращает следующую задачу: очереди проверяются строго по убыванию
+// приоритета, и только если все пусты, воркер ждёт первую пришедшую.
+// false – пул останавливается.
+func (wp *WorkerPool) next() (domain.Job, bool) {
+	if wp.ctx.Err() != nil {
+		return domain.Job{}, false
+	}
+	for _, queue := range []chan domain.Job{wp.highJobs, wp.midJobs, wp.lowJobs} {
 		select {
-		case wp.lowJobs <- job:
-		case <-ctx.Done():
-			return domain.JobResult{}, ctx.Err()
+		case job := <-queue:
+			return job, true
+		default:
 		}
 	}
-
-	// Ожидаем результат с таймаутом.
 	select {
-	case res := <-resultChan:
-		return res, nil
-	case <-ctx.Done():
-		return domain.JobResult{}, ctx.Err()
-	case <-time.After(30 * time.Second): // защита от зависания
-		return domain.JobResult{}, context.DeadlineExceeded
+	case job := <-wp.highJobs:
+		return job, true
+	case job := <-wp.midJobs:
+		return job, true
+	case job := <-wp.lowJobs:
+		return job, true
+	case <-wp.ctx.Done():
+		return domain.Job{}, false
 	}
 }
 
@@ -100,27 +164,15 @@ func (wp *WorkerPool) Submit(ctx context.Context, job domain.Job) (domain.JobRes
 func (wp *WorkerPool) worker() {
 	defer wp.wg.Done()
 	for {
-		var job domain.Job
-		var ok bool
-		select {
-		case job, ok = <-wp.highJobs:
-		case job, ok = <-wp.midJobs:
-			if !ok {
-				// если mid закрыт, пробуем low (или выходим по ctx)
-				select {
-				case job, ok = <-wp.lowJobs:
-				case <-wp.ctx.Done():
-					return
-				}
-			}
-		case job, ok = <-wp.lowJobs:
-		case <-wp.ctx.Done():
-			return
-		}
+		job, ok := wp.next()
 		if !ok {
-			// канал закрыт – завершаемся
+			// пул остановлен – завершаемся
 			return
 		}
+		if job.Claimed != nil && !job.Claimed.CompareAndSwap(false, true) {
+			// другая копия задачи уже выполнена или выполняется
+			continue
+		}
 		// Выполняем задачу
 		result := wp.handler(job)
 		// Отправляем результат обратно в канал, переданный в задаче
@@ -0,0 +1,240 @@
+package imagetype
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"image"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+
+	"golang.org/x/image/webp"
+)
+
+// ErrNoDimensions – размеры не удалось прочитать из заголовка: файл повреждён,
+// обрезан или заголовок не поместился в переданный фрагмент.
+var ErrNoDimensions = errors.New("image dimensions not found in header")
+
+// ErrHeaderBeyond уточняет ErrNoDimensions: структура с размерами лежит за
+// пределами переданного фрагмента. Так бывает у целых файлов: libtiff пишет
+// IFD в конец TIFF, а у HEIF бокс meta может идти после mdat.
+var ErrHeaderBeyond = errors.New("image header is beyond the given data")
+
+// Dimensions читает ширину и высоту изображения из начала файла, не декодируя
+// пиксели. Для HEIC/HEIF возвращается наибольший из размеров элементов
+// (у сеточных изображений это полный размер), что подходит для проверки лимитов.
+func Dimensions(head []byte, mime string) (width, height int, err error) {
+	var cfg image.Config
+	r := bytes.NewReader(head)
+	switch mime {
+	case JPEG:
+		cfg, err = jpeg.DecodeConfig(r)
+	case PNG:
+		cfg, err = png.DecodeConfig(r)
+	case GIF:
+		cfg, err = gif.DecodeConfig(r)
+	case WebP:
+		cfg, err = webp.DecodeConfig(r)
+	case TIFF:
+		width, height, err = tiffDimensions(head)
+		cfg = image.Config{Width: width, Height: height}
+	case HEIC, HEIF, AVIF:
+		width, height, err = heifDimensions(head)
+		cfg = image.Config{Width: width, Height: height}
+	case JXL:
+		width, height, err = jxlDimensions(head)
+		cfg = image.Config{Width: width, Height: height}
+	default:
+		return 0, 0, fmt.Errorf("%w: unsupported type %q", ErrNoDimensions, mime)
+	}
+	if errors.Is(err, ErrHeaderBeyond) {
+		return 0, 0, fmt.Errorf("%w: %w", ErrNoDimensions, err)
+	}
+	if err != nil {
+		return 0, 0, fmt.Errorf("%w: %v", ErrNoDimensions, err)
+	}
+	if cfg.Width <= 0 || cfg.Height <= 0 {
+		return 0, 0, ErrNoDimensions
+	}
+	return cfg.Width, cfg.Height, nil
+}
+
+// tiffDimensions читает теги ImageWidth и ImageLength из IFD0. Остальные
+// теги (в том числе массивы смещений полос, которые могут лежать где угодно
+// в файле) не нужны и не читаются.
+func tiffDimensions(data []byte) (int, int, error) {
+	if len(data) < 8 {
+		return 0, 0, ErrNoDimensions
+	}
+	var order binary.ByteOrder = binary.LittleEndian
+	if data[0] == 'M' {
+		order = binary.BigEndian
+	}
+	ifd := uint64(order.Uint32(data[4:8]))
+	if ifd < 8 {
+		return 0, 0, ErrNoDimensions
+	}
+	if ifd+2 > uint64(len(data)) {
+		return 0, 0, ErrHeaderBeyond
+	}
+	count := uint64(order.Uint16(data[ifd:]))
+	if ifd+2+count*12 > uint64(len(data)) {
+		return 0, 0, ErrHeaderBeyond
+	}
+	var width, height int
+	for i := range count {
+		entry := data[ifd+2+i*12:]
+		var v int
+		switch order.Uint16(entry[2:]) {
+		case 3: // SHORT
+			v = int(order.Uint16(entry[8:]))
+		case 4: // LONG
+			v = int(order.Uint32(entry[8:]))
+		}
+		switch order.Uint16(entry) {
+		case 256:
+			width = v
+		case 257:
+			height = v
+		}
+	}
+	return width, height, nil
+}
+
+// eachBox перебирает боксы ISO BMFF в data. fn возвращает false, чтобы остановить обход.
+// Обрезанный последний бокс считается ошибкой.
+func eachBox(data []byte, fn func(typ string, body []byte) bool) error {
+	for len(data) >= 8 {
+		size := uint64(binary.BigEndian.Uint32(data[:4]))
+		typ := string(data[4:8])
+		hdr := uint64(8)
+		switch size {
+		case 0: // до конца файла
+			size = uint64(len(data))
+		case 1: // 64-битный размер
+			if len(data) < 16 {
+				return ErrNoDimensions
+			}
+			size, hdr = binary.BigEndian.Uint64(data[8:16]), 16
+		}
+		if size < hdr || size > uint64(len(data)) {
+			return ErrNoDimensions
+		}
+		if !fn(typ, data[hdr:size]) {
+			return nil
+		}
+		data = data[size:]
+	}
+	return nil
+}
+
+// findBox возвращает содержимое первого бокса типа typ.
+func findBox(data []byte, typ string) []byte {
+	var found []byte
+	_ = eachBox(data, func(t string, body []byte) bool {
+		if t == typ {
+			found = body
+			return false
+		}
+		return true
+	})
+	return found
+}
+
+// heifDimensions ищет свойства ispe (размеры элементов) в meta/iprp/ipco.
+func heifDimensions(data []byte) (int, int, error) {
+	meta := findBox(data, "meta")
+	if len(meta) < 4 {
+		// обход оборвался на боксе, который не поместился целиком, – meta
+		// может оказаться в нём или после него
+		if eachBox(data, func(string, []byte) bool { return true }) != nil {
+			return 0, 0, ErrHeaderBeyond
+		}
+		return 0, 0, ErrNoDimensions
+	}
+	// meta – FullBox: 4 байта версии и флагов
+	ipco := findBox(findBox(meta[4:], "iprp"), "ipco")
+	var width, height int
+	err := eachBox(ipco, func(typ string, body []byte) bool {
+		if typ == "ispe" && len(body) >= 12 {
+			w, h := int(binary.BigEndian.Uint32(body[4:8])), int(binary.BigEndian.Uint32(body[8:12]))
+			if w*h > width*height {
+				width, height = w, h
+			}
+		}
+		return true
+	})
+	if err != nil || width == 0 {
+		return 0, 0, ErrNoDimensions
+	}
+	return width, height, nil
+}
+
+// jxlDimensions разбирает SizeHeader кодового потока JPEG XL (голого или в контейнере).
+func jxlDimensions(data []byte) (int, int, error) {
+	if bytes.HasPrefix(data, jxlContainer) {
+		var stream []byte
+		_ = eachBox(data, func(typ string, body []byte) bool {
+			switch typ {
+			case "jxlc":
+				stream = body
+				return false
+			case "jxlp": // частичный поток: 4 байта порядкового номера
+				if len(body) > 4 {
+					stream = body[4:]
+				}
+				return false
+			}
+			return true
+		})
+		data = stream
+	}
+	if !bytes.HasPrefix(data, jxlCodestream) {
+		return 0, 0, ErrNoDimensions
+	}
+	br := &bitReader{data: data[2:]}
+	size := func(small bool) uint32 {
+		if small {
+			return (br.read(5) + 1) * 8
+		}
+		bits := [4]uint{9, 13, 18, 30}[br.read(2)]
+		return br.read(bits) + 1
+	}
+	small := br.read(1) == 1
+	height := size(small)
+	var width uint32
+	// соотношения сторон, закодированные полем ratio (1..7)
+	ratios := [8][2]uint64{{}, {1, 1}, {12, 10}, {4, 3}, {3, 2}, {16, 9}, {5, 4}, {2, 1}}
+	if ratio := br.read(3); ratio == 0 {
+		width = size(small)
+	} else {
+		width = uint32(uint64(height) * ratios[ratio][0] / ratios[ratio][1])
+	}
+	if br.overflow {
+		return 0, 0, ErrNoDimensions
+	}
+	return int(width), int(height), nil
+}
+
+// bitReader читает биты от младшего к старшему, как того требует JPEG XL.
+type bitReader struct {
+	data     []byte
+	pos      uint
+	overflow bool
+}
+
+func (b *bitReader) read(n uint) uint32 {
+	var v uint32
+	for i := uint(0); i < n; i++ {
+		byteIdx := b.pos / 8
+		if int(byteIdx) >= len(b.data) {
+			b.overflow = true
+			return 0
+		}
+		v |= uint32(b.data[byteIdx]>>(b.pos%8)&1) << i
+		b.pos++
+	}
+	return v
+}
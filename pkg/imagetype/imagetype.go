@@ -0,0 +1,87 @@
+// Package imagetype определяет формат изображения по сигнатуре (magic bytes),
+// не доверяя расширению файла и заголовку Content-Type от клиента.
+package imagetype
+
+import "bytes"
+
+// MIME-типы поддерживаемых входных форматов.
+const (
+	JPEG = "image/jpeg"
+	PNG  = "image/png"
+	WebP = "image/webp"
+	GIF  = "image/gif"
+	TIFF = "image/tiff"
+	HEIC = "image/heic"
+	HEIF = "image/heif"
+	AVIF = "image/avif"
+	JXL  = "image/jxl"
+)
+
+var (
+	jxlContainer  = []byte("\x00\x00\x00\x0cJXL \r\n\x87\n")
+	jxlCodestream = []byte("\xff\x0a")
+)
+
+// Detect возвращает MIME-тип изображения по первым байтам файла
+// или пустую строку, если формат не распознан.
+func Detect(head []byte) string {
+	switch {
+	case bytes.HasPrefix(head, []byte("\xff\xd8\xff")):
+		return JPEG
+	case bytes.HasPrefix(head, []byte("\x89PNG\r\n\x1a\n")):
+		return PNG
+	case bytes.HasPrefix(head, []byte("GIF87a")), bytes.HasPrefix(head, []byte("GIF89a")):
+		return GIF
+	case len(head) >= 12 && bytes.Equal(head[:4], []byte("RIFF")) && bytes.Equal(head[8:12], []byte("WEBP")):
+		return WebP
+	case bytes.HasPrefix(head, []byte("II*\x00")), bytes.HasPrefix(head, []byte("MM\x00*")):
+		return TIFF
+	case bytes.HasPrefix(head, jxlContainer), bytes.HasPrefix(head, jxlCodestream):
+		return JXL
+	case len(head) >= 12 && bytes.Equal(head[4:8], []byte("ftyp")):
+		// контейнер ISO BMFF: формат определяется основным брендом
+		switch string(head[8:12]) {
+		case "heic", "heix", "heim", "heis", "hevc", "hevx":
+			return HEIC
+		case "mif1", "msf1":
+			return HEIF
+		case "avif", "avis":
+			return AVIF
+		}
+	}
+	return ""
+}
+
+// Extension возвращает расширение файла (с точкой) для MIME-типа.
+func Extension(mime string) string {
+	switch mime {
+	case JPEG:
+		return ".jpg"
+	case PNG:
+		return ".png"
+	case WebP:
+		return ".webp"
+	case GIF:
+		return ".gif"
+	case TIFF:
+		return ".tiff"
+	case HEIC:
+		return ".heic"
+	case HEIF:
+		return ".heif"
+	case AVIF:
+		return ".avif"
+	case JXL:
+		return ".jxl"
+	}
+	return ""
+}
+
+// BrowserSafe сообщает, могут ли браузеры показать формат без перекодирования.
+func BrowserSafe(mime string) bool {
+	switch mime {
+	case JPEG, PNG, WebP, GIF, AVIF:
+		return true
+	}
+	return false
+}
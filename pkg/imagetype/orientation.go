@@ -0,0 +1,131 @@
+package imagetype
+
+import (
+	"bytes"
+	"encoding/binary"
+)
+
+// Orientation возвращает значение EXIF Orientation (1..8) из начала файла.
+// Для HEIC/HEIF/AVIF поворот берётся из свойства irot. Если ориентация не
+// указана или не читается, возвращается 1 (без поворота).
+func Orientation(head []byte, mime string) int {
+	o := 0
+	switch mime {
+	case JPEG:
+		o = tiffOrientation(jpegExif(head))
+	case TIFF:
+		o = tiffOrientation(head)
+	case WebP:
+		o = tiffOrientation(webpExif(head))
+	case HEIC, HEIF, AVIF:
+		o = heifOrientation(head)
+	}
+	if o < 1 || o > 8 {
+		return 1
+	}
+	return o
+}
+
+// DisplayDimensions – размеры изображения после поворота по ориентации,
+// то есть такие, какими их увидит пользователь и отдаст процессор.
+func DisplayDimensions(head []byte, mime string) (width, height int, err error) {
+	width, height, err = Dimensions(head, mime)
+	if err != nil {
+		return 0, 0, err
+	}
+	// 5..8 – поворот на 90° (с отражением или без): стороны меняются местами
+	if Orientation(head, mime) >= 5 {
+		width, height = height, width
+	}
+	return width, height, nil
+}
+
+var exifHeader = []byte("Exif\x00\x00")
+
+// jpegExif возвращает TIFF-структуру из сегмента APP1 Exif.
+func jpegExif(data []byte) []byte {
+	if len(data) < 4 || data[0] != 0xFF || data[1] != 0xD8 {
+		return nil
+	}
+	data = data[2:]
+	for len(data) >= 4 && data[0] == 0xFF {
+		marker := data[1]
+		if marker == 0xDA || marker == 0xD9 { // начало скана или конец файла
+			return nil
+		}
+		size := int(binary.BigEndian.Uint16(data[2:4]))
+		if size < 2 || 2+size > len(data) {
+			return nil
+		}
+		if body := data[4 : 2+size]; marker == 0xE1 && bytes.HasPrefix(body, exifHeader) {
+			return body[len(exifHeader):]
+		}
+		data = data[2+size:]
+	}
+	return nil
+}
+
+// webpExif возвращает TIFF-структуру из чанка EXIF контейнера RIFF.
+func webpExif(data []byte) []byte {
+	if len(data) < 12 {
+		return nil
+	}
+	data = data[12:]
+	for len(data) >= 8 {
+		size := int(binary.LittleEndian.Uint32(data[4:8]))
+		if size < 0 || 8+size > len(data) {
+			return nil
+		}
+		if string(data[:4]) == "EXIF" {
+			// некоторые кодировщики оставляют префикс как в JPEG
+			return bytes.TrimPrefix(data[8:8+size], exifHeader)
+		}
+		data = data[8+size+size%2:] // чанки выровнены по чётной границе
+	}
+	return nil
+}
+
+// tiffOrientation ищет тег Orientation (0x0112) в IFD0 TIFF-структуры.
+func tiffOrientation(data []byte) int {
+	if len(data) < 8 {
+		return 0
+	}
+	var order binary.ByteOrder
+	switch string(data[:2]) {
+	case "II":
+		order = binary.LittleEndian
+	case "MM":
+		order = binary.BigEndian
+	default:
+		return 0
+	}
+	ifd := int(order.Uint32(data[4:8]))
+	if ifd < 8 || ifd+2 > len(data) {
+		return 0
+	}
+	count := int(order.Uint16(data[ifd:]))
+	for i := 0; i < count; i++ {
+		entry := ifd + 2 + i*12
+		if entry+12 > len(data) {
+			return 0
+		}
+		if order.Uint16(data[entry:]) == 0x0112 {
+			return int(order.Uint16(data[entry+8:]))
+		}
+	}
+	return 0
+}
+
+// heifOrientation переводит поворот irot (против часовой, шагами по 90°)
+// в эквивалентное значение EXIF Orientation.
+func heifOrientation(data []byte) int {
+	meta := findBox(data, "meta")
+	if len(meta) < 4 {
+		return 0
+	}
+	irot := findBox(findBox(findBox(meta[4:], "iprp"), "ipco"), "irot")
+	if len(irot) < 1 {
+		return 0
+	}
+	return [4]int{1, 8, 3, 6}[irot[0]&3]
+}
@@ -0,0 +1,233 @@
+package imagetype
+
+import (
+	"bytes"
+	"encoding/binary"
+)
+
+// Маркеры активного содержимого, которого не бывает в изображениях, но
+// которое браузер может исполнить, если файл будет отдан не как картинка.
+var activeMarkers = [][]byte{
+	[]byte("<script"), []byte("<html"), []byte("<!doctype html"), []byte("<?php"),
+	[]byte("<iframe"), []byte("<svg"), []byte("javascript:"),
+}
+
+// zipEOCD – сигнатура конца центрального каталога ZIP (JAR, DOCX и т. п.).
+var zipEOCD = []byte("PK\x05\x06")
+
+// Polyglot сообщает, что файл похож на полиглот – одновременно изображение и
+// HTML/скрипт или ZIP-архив. head и tail – начало и конец файла, size – его
+// полный размер. Маркеры ищутся только там, где изображение хранит
+// произвольные данные: в сегментах метаданных из head и в данных после
+// конца изображения. Сжатые пиксели не проверяются – в них маркер
+// встречается случайно. ZIP распознаётся по концу центрального каталога,
+// которым архив заканчивается.
+func Polyglot(mime string, head, tail []byte, size int64) bool {
+	found := false
+	scan := func(b []byte) {
+		found = found || containsActive(b)
+	}
+	switch mime {
+	case JPEG:
+		jpegSegments(head, scan)
+		scan(jpegTrailer(tail))
+	case PNG:
+		pngChunks(head, scan)
+		// IEND пустой, поэтому и его CRC всегда один и тот же
+		if i := bytes.Index(tail, []byte("IEND\xaeB`\x82")); i >= 0 {
+			scan(tail[i+8:])
+		}
+	case GIF:
+		gifBlocks(head, scan)
+		// трейлеру предшествует терминатор подблоков; в сжатых кадрах такая
+		// пара байтов тоже встречается, поэтому берётся последняя
+		if i := bytes.LastIndex(tail, []byte("\x00;")); i >= 0 {
+			scan(tail[i+2:])
+		}
+	case WebP:
+		if end := webpChunks(head, scan); end < size {
+			scan(tail[max(int64(len(tail))-(size-end), 0):])
+		}
+	case HEIC, HEIF, AVIF, JXL:
+		// у голого кодового потока JPEG XL структуры нет, у контейнеров конец
+		// не виден из начала файла – проверяются только метаданные
+		_ = eachBox(head, func(typ string, body []byte) bool {
+			switch typ {
+			case "mdat", "jxlc", "jxlp":
+			default:
+				scan(body)
+			}
+			return true
+		})
+	}
+	return found || zipTrailer(tail)
+}
+
+// containsActive ищет маркеры активного содержимого без учёта регистра.
+func containsActive(b []byte) bool {
+	if len(b) == 0 {
+		return false
+	}
+	lower := bytes.ToLower(b)
+	for _, m := range activeMarkers {
+		if bytes.Contains(lower, m) {
+			return true
+		}
+	}
+	return false
+}
+
+// jpegTrailer возвращает данные после маркера EOI. В сжатых данных JPEG
+// байт 0xFF всегда экранируется, поэтому первый EOI в data – настоящий
+// конец изображения. Если за ним начинается следующее изображение (MPO,
+// карта усиления HDR), берётся конец последнего из них. nil – конец
+// изображения не поместился в data.
+func jpegTrailer(data []byte) []byte {
+	for {
+		i := bytes.Index(data, []byte("\xff\xd9"))
+		if i < 0 {
+			return nil
+		}
+		data = data[i+2:]
+		if !bytes.HasPrefix(data, []byte("\xff\xd8")) {
+			return data
+		}
+	}
+}
+
+// zipTrailer сообщает, что data заканчивается концом центрального каталога
+// ZIP вместе с комментарием архива – так, как его ищут архиваторы.
+func zipTrailer(data []byte) bool {
+	for i := bytes.LastIndex(data, zipEOCD); i >= 0; i = bytes.LastIndex(data[:i], zipEOCD) {
+		if i+22 <= len(data) && i+22+int(binary.LittleEndian.Uint16(data[i+20:])) == len(data) {
+			return true
+		}
+	}
+	return false
+}
+
+// jpegSegments передаёт scan содержимое сегментов APPn и COM до начала скана.
+// APP1 с Exif пропускается: в нём хранится сжатая миниатюра.
+func jpegSegments(data []byte, scan func([]byte)) {
+	if !bytes.HasPrefix(data, []byte("\xff\xd8")) {
+		return
+	}
+	data = data[2:]
+	for len(data) >= 4 && data[0] == 0xFF {
+		marker := data[1]
+		if marker == 0xDA || marker == 0xD9 { // начало скана или конец файла
+			return
+		}
+		size := int(binary.BigEndian.Uint16(data[2:4]))
+		if size < 2 {
+			return
+		}
+		body := data[4:min(2+size, len(data))]
+		if (marker >= 0xE0 && marker <= 0xEF || marker == 0xFE) && !(marker == 0xE1 && bytes.HasPrefix(body, exifHeader)) {
+			scan(body)
+		}
+		data = data[min(2+size, len(data)):]
+	}
+}
+
+// pngChunks передаёт scan содержимое чанков, кроме сжатых (IDAT, fdAT, zTXt,
+// iCCP), и данные после IEND.
+func pngChunks(data []byte, scan func([]byte)) {
+	if len(data) < 8 {
+		return
+	}
+	data = data[8:]
+	for len(data) >= 12 {
+		size := uint64(binary.BigEndian.Uint32(data[:4]))
+		typ := string(data[4:8])
+		end := int(min(12+size, uint64(len(data))))
+		switch typ {
+		case "IDAT", "fdAT", "zTXt", "iCCP":
+		default:
+			scan(data[8:max(end-4, 8)])
+		}
+		data = data[end:]
+		if typ == "IEND" {
+			scan(data)
+			return
+		}
+	}
+}
+
+// gifBlocks передаёт scan содержимое расширений с произвольными данными
+// (комментарий, текст, данные приложения) и данные после трейлера.
+// Сжатые кадры пропускаются.
+func gifBlocks(data []byte, scan func([]byte)) {
+	if len(data) < 13 {
+		return
+	}
+	pos := 13
+	if flags := data[10]; flags&0x80 != 0 {
+		pos += 3 << (flags&7 + 1) // глобальная палитра
+	}
+	for pos < len(data) {
+		switch data[pos] {
+		case 0x21: // расширение
+			if pos+2 > len(data) {
+				return
+			}
+			label := data[pos+1]
+			var body []byte
+			pos, body = gifSubBlocks(data, pos+2)
+			if label == 0x01 || label == 0xFE || label == 0xFF {
+				scan(body)
+			}
+		case 0x2C: // кадр
+			if pos+10 > len(data) {
+				return
+			}
+			flags := data[pos+9]
+			pos += 10
+			if flags&0x80 != 0 {
+				pos += 3 << (flags&7 + 1) // локальная палитра
+			}
+			pos, _ = gifSubBlocks(data, pos+1) // после минимального размера кода LZW
+		case 0x3B: // трейлер
+			scan(data[pos+1:])
+			return
+		default:
+			return
+		}
+	}
+}
+
+// gifSubBlocks читает цепочку подблоков с позиции pos и возвращает позицию
+// после неё и склеенное содержимое.
+func gifSubBlocks(data []byte, pos int) (int, []byte) {
+	var body []byte
+	for pos < len(data) {
+		n := int(data[pos])
+		pos++
+		if n == 0 {
+			return pos, body
+		}
+		body = append(body, data[pos:min(pos+n, len(data))]...)
+		pos += n
+	}
+	return len(data), body
+}
+
+// webpChunks передаёт scan содержимое чанков, кроме сжатых кадров и альфы,
+// и возвращает смещение конца контейнера RIFF.
+func webpChunks(data []byte, scan func([]byte)) int64 {
+	if len(data) < 12 {
+		return 0
+	}
+	end := 8 + int64(binary.LittleEndian.Uint32(data[4:8]))
+	pos := int64(12)
+	for pos+8 <= int64(len(data)) && pos < end {
+		size := int64(binary.LittleEndian.Uint32(data[pos+4 : pos+8]))
+		switch string(data[pos : pos+4]) {
+		case "VP8 ", "VP8L", "ALPH", "ANMF":
+		default:
+			scan(data[pos+8 : min(pos+8+size, int64(len(data)))])
+		}
+		pos += 8 + size + size%2 // чанки выровнены по чётной границе
+	}
+	return end
+}
@@ -0,0 +1,203 @@
+// Package phash считает перцептивный хэш (pHash) изображения: 64 бита,
+// которые почти не меняются при пересохранении, сжатии, масштабировании и
+// лёгкой цветокоррекции. Похожесть изображений – расстояние Хэмминга между
+// хэшами (число различающихся бит).
+package phash
+
+import (
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"math"
+	"math/bits"
+	"slices"
+	"strconv"
+)
+
+// Size – сторона изображения, по которому считается хэш. Изображение
+// приводится к ней без сохранения пропорций (см. vips.Processor.Fingerprint).
+const Size = 32
+
+// lowFreq – сторона блока низких частот DCT, из которого берутся биты.
+const lowFreq = 8
+
+// Hash – перцептивный хэш изображения.
+type Hash uint64
+
+// Distance возвращает расстояние Хэмминга между хэшами: 0 – изображения
+// неотличимы, до ~10 – скорее всего одно и то же изображение.
+func (h Hash) Distance(o Hash) int {
+	return bits.OnesCount64(uint64(h ^ o))
+}
+
+// String возвращает хэш в виде 16 шестнадцатеричных цифр.
+func (h Hash) String() string {
+	return fmt.Sprintf("%016x", uint64(h))
+}
+
+// MarshalText кодирует хэш строкой (в JSON 64-битное число теряло бы точность).
+func (h Hash) MarshalText() ([]byte, error) {
+	return []byte(h.String()), nil
+}
+
+// UnmarshalText разбирает хэш из 16 шестнадцатеричных цифр.
+func (h *Hash) UnmarshalText(text []byte) error {
+	v, err := strconv.ParseUint(string(text), 16, 64)
+	if err != nil || len(text) != 16 {
+		return errors.New("phash: invalid hash")
+	}
+	*h = Hash(v)
+	return nil
+}
+
+// FromImage считает хэш изображения Size×Size: яркость раскладывается
+// по косинусам (DCT-II), и каждый из 8×8 коэффициентов низших частот даёт
+// бит – больше ли он медианы этих коэффициентов.
+func FromImage(img image.Image) (Hash, error) {
+	b := img.Bounds()
+	if b.Dx() != Size || b.Dy() != Size {
+		return 0, fmt.Errorf("phash: image must be %dx%d, got %dx%d", Size, Size, b.Dx(), b.Dy())
+	}
+	var luma [Size][Size]float64
+	for y := range Size {
+		for x := range Size {
+			luma[y][x] = float64(color.GrayModel.Convert(img.At(b.Min.X+x, b.Min.Y+y)).(color.Gray).Y)
+		}
+	}
+
+	var cos [lowFreq][Size]float64
+	for u := range lowFreq {
+		for x := range Size {
+			cos[u][x] = math.Cos(float64((2*x+1)*u) * math.Pi / (2 * Size))
+		}
+	}
+	coeffs := make([]float64, 0, lowFreq*lowFreq)
+	for v := range lowFreq {
+		for u := range lowFreq {
+			var sum float64
+			for y := range Size {
+				var row float64
+				for x := range Size {
+					row += luma[y][x] * cos[u][x]
+				}
+				sum += row * cos[v][y]
+			}
+			coeffs = append(coeffs, sum)
+		}
+	}
+
+	sorted := slices.Clone(coeffs)
+	slices.Sort(sorted)
+	median := (sorted[len(sorted)/2-1] + sorted[len(sorted)/2]) / 2
+	var h Hash
+	for i, c := range coeffs {
+		if c > median {
+			h |= 1 << i
+		}
+	}
+	return h, nil
+}
+
+// Group объединяет хэши в группы похожих: хэши с расстоянием не больше
+// maxDistance попадают в одну группу, и группы связаны транзитивно (A~B и
+// B~C дают группу ABC). Возвращает группы из двух и более индексов hashes,
+// упорядоченные по первому индексу. Пары-кандидаты ищутся по BK-дереву, а не
+// перебором всех пар.
+func Group(hashes []Hash, maxDistance int) [][]int {
+	parent := make([]int, len(hashes))
+	for i := range parent {
+		parent[i] = i
+	}
+	var find func(int) int
+	find = func(i int) int {
+		if parent[i] != i {
+			parent[i] = find(parent[i])
+		}
+		return parent[i]
+	}
+	tree := bkTree{hashes: hashes}
+	for i := range hashes {
+		tree.within(hashes[i], maxDistance, func(j int) {
+			if ri, rj := find(i), find(j); ri != rj {
+				parent[max(ri, rj)] = min(ri, rj)
+			}
+		})
+		tree.add(i)
+	}
+
+	members := make(map[int][]int)
+	var roots []int
+	for i := range hashes {
+		r := find(i)
+		if _, ok := members[r]; !ok {
+			roots = append(roots, r)
+		}
+		members[r] = append(members[r], i)
+	}
+	var groups [][]int
+	for _, r := range roots {
+		if len(members[r]) > 1 {
+			groups = append(groups, members[r])
+		}
+	}
+	return groups
+}
+
+// bkTree – BK-дерево индексов хэшей по расстоянию Хэмминга. Потомки узла
+// разложены по расстоянию до него, поэтому по неравенству треугольника поиск
+// в радиусе d спускается только в потомков с расстоянием от dist-d до
+// dist+d, где dist – расстояние от искомого хэша до узла.
+type bkTree struct {
+	hashes []Hash
+	nodes  []bkNode
+}
+
+type bkNode struct {
+	index    int
+	children map[int]int // расстояние до узла -> номер узла-потомка в nodes
+}
+
+// add добавляет в дерево хэш hashes[i].
+func (t *bkTree) add(i int) {
+	if len(t.nodes) == 0 {
+		t.nodes = append(t.nodes, bkNode{index: i})
+		return
+	}
+	n := 0
+	for {
+		d := t.hashes[t.nodes[n].index].Distance(t.hashes[i])
+		child, ok := t.nodes[n].children[d]
+		if !ok {
+			if t.nodes[n].children == nil {
+				t.nodes[n].children = make(map[int]int)
+			}
+			t.nodes[n].children[d] = len(t.nodes)
+			t.nodes = append(t.nodes, bkNode{index: i})
+			return
+		}
+		n = child
+	}
+}
+
+// within вызывает visit для индекса каждого хэша дерева, который отличается
+// от h не больше чем на maxDistance бит.
+func (t *bkTree) within(h Hash, maxDistance int, visit func(int)) {
+	if len(t.nodes) == 0 {
+		return
+	}
+	stack := []int{0}
+	for len(stack) > 0 {
+		node := t.nodes[stack[len(stack)-1]]
+		stack = stack[:len(stack)-1]
+		d := t.hashes[node.index].Distance(h)
+		if d <= maxDistance {
+			visit(node.index)
+		}
+		for cd, child := range node.children {
+			if cd >= d-maxDistance && cd <= d+maxDistance {
+				stack = append(stack, child)
+			}
+		}
+	}
+}
@@ -0,0 +1,175 @@
+// Package thumbhash кодирует ThumbHash – компактную (~25 байт) заглушку
+// изображения. В отличие от BlurHash она хранит соотношение сторон и
+// прозрачность, а детализация яркости подстраивается под форму кадра.
+// Формат совместим с эталонной реализацией (https://evanw.github.io/thumbhash/).
+package thumbhash
+
+import (
+	"errors"
+	"image"
+	"image/color"
+	"math"
+)
+
+// MaxSize – наибольшая сторона кодируемого изображения.
+const MaxSize = 100
+
+// ErrTooLarge – изображение больше MaxSize×MaxSize.
+var ErrTooLarge = errors.New("thumbhash: image must be at most 100x100")
+
+// Encode возвращает ThumbHash изображения не больше MaxSize×MaxSize.
+func Encode(img image.Image) ([]byte, error) {
+	b := img.Bounds()
+	w, h := b.Dx(), b.Dy()
+	if w > MaxSize || h > MaxSize {
+		return nil, ErrTooLarge
+	}
+	if w == 0 || h == 0 {
+		return nil, errors.New("thumbhash: empty image")
+	}
+	n := w * h
+
+	// средний цвет с учётом прозрачности – им заполняются прозрачные области
+	rgba := make([]color.NRGBA, 0, n)
+	var avgR, avgG, avgB, avgA float64
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		for x := b.Min.X; x < b.Max.X; x++ {
+			c := color.NRGBAModel.Convert(img.At(x, y)).(color.NRGBA)
+			rgba = append(rgba, c)
+			alpha := float64(c.A) / 255
+			avgR += alpha / 255 * float64(c.R)
+			avgG += alpha / 255 * float64(c.G)
+			avgB += alpha / 255 * float64(c.B)
+			avgA += alpha
+		}
+	}
+	if avgA > 0 {
+		avgR /= avgA
+		avgG /= avgA
+		avgB /= avgA
+	}
+
+	hasAlpha := avgA < float64(n)
+	lLimit := 7.0
+	if hasAlpha {
+		lLimit = 5
+	}
+	longest := float64(max(w, h))
+	lx := max(1, int(round(lLimit*float64(w)/longest)))
+	ly := max(1, int(round(lLimit*float64(h)/longest)))
+
+	// яркость, две цветоразностные составляющие и прозрачность
+	l := make([]float64, n)
+	p := make([]float64, n)
+	q := make([]float64, n)
+	a := make([]float64, n)
+	for i, c := range rgba {
+		alpha := float64(c.A) / 255
+		r := avgR*(1-alpha) + alpha/255*float64(c.R)
+		g := avgG*(1-alpha) + alpha/255*float64(c.G)
+		bl := avgB*(1-alpha) + alpha/255*float64(c.B)
+		l[i] = (r + g + bl) / 3
+		p[i] = (r+g)/2 - bl
+		q[i] = r - g
+		a[i] = alpha
+	}
+
+	lc := encodeChannel(l, w, h, max(3, lx), max(3, ly))
+	pc := encodeChannel(p, w, h, 3, 3)
+	qc := encodeChannel(q, w, h, 3, 3)
+	var ac channel
+	if hasAlpha {
+		ac = encodeChannel(a, w, h, 5, 5)
+	}
+
+	landscape := w > h
+	header24 := int(round(63*lc.dc)) |
+		int(round(31.5+31.5*pc.dc))<<6 |
+		int(round(31.5+31.5*qc.dc))<<12 |
+		int(round(31*lc.scale))<<18 |
+		boolBit(hasAlpha)<<23
+	side := lx
+	if landscape {
+		side = ly
+	}
+	header16 := side |
+		int(round(63*pc.scale))<<3 |
+		int(round(63*qc.scale))<<9 |
+		boolBit(landscape)<<15
+
+	hash := []byte{
+		byte(header24), byte(header24 >> 8), byte(header24 >> 16),
+		byte(header16), byte(header16 >> 8),
+	}
+	channels := []channel{lc, pc, qc}
+	if hasAlpha {
+		hash = append(hash, byte(int(round(15*ac.dc))|int(round(15*ac.scale))<<4))
+		channels = append(channels, ac)
+	}
+	start := len(hash)
+	i := 0
+	for _, c := range channels {
+		for _, f := range c.ac {
+			if start+i>>1 >= len(hash) {
+				hash = append(hash, 0)
+			}
+			hash[start+i>>1] |= byte(int(round(15*f)) << ((i & 1) << 2))
+			i++
+		}
+	}
+	return hash, nil
+}
+
+// channel – коэффициенты DCT одного канала: постоянная составляющая и
+// переменные, нормированные в 0..1 делением на scale.
+type channel struct {
+	dc    float64
+	ac    []float64
+	scale float64
+}
+
+// encodeChannel раскладывает канал w×h по косинусам, сохраняя nx×ny
+// низших частот (треугольником – без высоких частот по обеим осям сразу).
+func encodeChannel(values []float64, w, h, nx, ny int) channel {
+	var c channel
+	fx := make([]float64, w)
+	for cy := 0; cy < ny; cy++ {
+		for cx := 0; cx*ny < nx*(ny-cy); cx++ {
+			for x := range w {
+				fx[x] = math.Cos(math.Pi / float64(w) * float64(cx) * (float64(x) + 0.5))
+			}
+			var f float64
+			for y := range h {
+				fy := math.Cos(math.Pi / float64(h) * float64(cy) * (float64(y) + 0.5))
+				for x := range w {
+					f += values[x+y*w] * fx[x] * fy
+				}
+			}
+			f /= float64(w * h)
+			if cx > 0 || cy > 0 {
+				c.ac = append(c.ac, f)
+				c.scale = math.Max(c.scale, math.Abs(f))
+			} else {
+				c.dc = f
+			}
+		}
+	}
+	if c.scale > 0 {
+		for i := range c.ac {
+			c.ac[i] = 0.5 + 0.5/c.scale*c.ac[i]
+		}
+	}
+	return c
+}
+
+// round округляет как Math.round в эталонной реализации (половина – вверх).
+func round(x float64) float64 {
+	return math.Floor(x + 0.5)
+}
+
+func boolBit(b bool) int {
+	if b {
+		return 1
+	}
+	return 0
+}
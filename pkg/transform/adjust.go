@@ -0,0 +1,126 @@
+package transform
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// Ограничения на параметры коррекции.
+const (
+	MaxBlur    = 100.0 // максимальная sigma размытия
+	MaxSharpen = 10.0  // максимальная sigma повышения резкости
+	MaxLevel   = 100   // максимальный модуль яркости, контраста и насыщенности, %
+)
+
+// Adjustments – коррекции и фильтры, применяемые после обрезки.
+// Порядок применения фиксирован и не зависит от порядка параметров запроса:
+// отражения, поворот, масштабирование, ч/б, яркость и насыщенность, контраст,
+// размытие, резкость, заливка фона.
+type Adjustments struct {
+	Rotate     int     `json:"rotate,omitempty"`     // поворот по часовой: 0, 90, 180 или 270
+	FlipV      bool    `json:"flip_v,omitempty"`     // отражение сверху вниз
+	FlipH      bool    `json:"flip_h,omitempty"`     // отражение слева направо
+	Grayscale  bool    `json:"grayscale,omitempty"`  // перевод в оттенки серого
+	Brightness int     `json:"brightness,omitempty"` // -100..100, % от исходной яркости
+	Contrast   int     `json:"contrast,omitempty"`   // -100..100, % от исходного контраста
+	Saturation int     `json:"saturation,omitempty"` // -100..100, % от исходной насыщенности
+	Blur       float64 `json:"blur,omitempty"`       // sigma гауссова размытия, 0 – без размытия
+	Sharpen    float64 `json:"sharpen,omitempty"`    // sigma повышения резкости, 0 – без изменений
+	Background string  `json:"background,omitempty"` // цвет RRGGBB для прозрачных областей и полей contain
+}
+
+// Normalize приводит эквивалентные коррекции к одному виду: угол – к 0..270,
+// двойное отражение – к повороту на 180°, sigma – к шагу 0.1, цвет – к нижнему регистру.
+func (a *Adjustments) Normalize() {
+	a.Rotate = ((a.Rotate % 360) + 360) % 360
+	if a.FlipV && a.FlipH {
+		a.FlipV, a.FlipH = false, false
+		a.Rotate = (a.Rotate + 180) % 360
+	}
+	a.Blur = math.Round(a.Blur*10) / 10
+	a.Sharpen = math.Round(a.Sharpen*10) / 10
+	a.Background = strings.ToLower(strings.TrimPrefix(a.Background, "#"))
+}
+
+// Validate проверяет коррекции на допустимые значения.
+func (a Adjustments) Validate() error {
+	if a.Rotate%90 != 0 {
+		return fmt.Errorf("%w: rotate must be a multiple of 90", ErrInvalidOptions)
+	}
+	for _, v := range []int{a.Brightness, a.Contrast, a.Saturation} {
+		if v < -MaxLevel || v > MaxLevel {
+			return fmt.Errorf("%w: brightness, contrast and saturation must be between %d and %d",
+				ErrInvalidOptions, -MaxLevel, MaxLevel)
+		}
+	}
+	if a.Blur < 0 || a.Blur > MaxBlur || (a.Blur > 0 && a.Blur < 0.3) {
+		return fmt.Errorf("%w: blur must be between 0.3 and %g", ErrInvalidOptions, MaxBlur)
+	}
+	if a.Sharpen < 0 || a.Sharpen > MaxSharpen {
+		return fmt.Errorf("%w: sharpen must be between 0 and %g", ErrInvalidOptions, MaxSharpen)
+	}
+	if a.Background != "" {
+		if _, err := ParseColor(a.Background); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// IsZero сообщает, что коррекций нет.
+func (a Adjustments) IsZero() bool {
+	return a == Adjustments{}
+}
+
+// keyParts возвращает части ключа кэша в порядке применения коррекций.
+func (a Adjustments) keyParts() []string {
+	var parts []string
+	if a.FlipV {
+		parts = append(parts, "flipv")
+	}
+	if a.FlipH {
+		parts = append(parts, "fliph")
+	}
+	if a.Rotate != 0 {
+		parts = append(parts, "rot"+strconv.Itoa(a.Rotate))
+	}
+	if a.Grayscale {
+		parts = append(parts, "gray")
+	}
+	if a.Brightness != 0 {
+		parts = append(parts, "bri"+strconv.Itoa(a.Brightness))
+	}
+	if a.Saturation != 0 {
+		parts = append(parts, "sat"+strconv.Itoa(a.Saturation))
+	}
+	if a.Contrast != 0 {
+		parts = append(parts, "con"+strconv.Itoa(a.Contrast))
+	}
+	if a.Blur > 0 {
+		parts = append(parts, "blur"+strconv.FormatFloat(a.Blur, 'f', -1, 64))
+	}
+	if a.Sharpen > 0 {
+		parts = append(parts, "sharp"+strconv.FormatFloat(a.Sharpen, 'f', -1, 64))
+	}
+	if a.Background != "" {
+		parts = append(parts, "bg"+a.Background)
+	}
+	return parts
+}
+
+// RGB – цвет заливки.
+type RGB struct {
+	R, G, B uint8
+}
+
+// ParseColor разбирает цвет в формате RRGGBB (допускается ведущий #).
+func ParseColor(s string) (RGB, error) {
+	s = strings.TrimPrefix(s, "#")
+	v, err := strconv.ParseUint(s, 16, 32)
+	if len(s) != 6 || err != nil {
+		return RGB{}, fmt.Errorf("%w: color must be RRGGBB", ErrInvalidOptions)
+	}
+	return RGB{R: uint8(v >> 16), G: uint8(v >> 8), B: uint8(v)}, nil
+}
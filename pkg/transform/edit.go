@@ -0,0 +1,66 @@
+package transform
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+)
+
+// Edit – сохранённая правка фото: обрезка и коррекции, которые применяются
+// к оригиналу перед параметрами запроса. Оригинал при этом не меняется,
+// поэтому к любой прошлой правке можно вернуться.
+type Edit struct {
+	Crop   *Crop       `json:"crop,omitempty"` // в координатах оригинала (после EXIF-поворота)
+	Adjust Adjustments `json:"adjust"`         // поворот, отражения, цвет и фильтры
+}
+
+// Normalize приводит правку к каноническому виду.
+func (e *Edit) Normalize() {
+	if e.Crop != nil && *e.Crop == (Crop{}) {
+		e.Crop = nil
+	}
+	e.Adjust.Normalize()
+}
+
+// Validate проверяет правку. Если размеры оригинала известны (больше 0),
+// обрезка должна в них помещаться.
+func (e Edit) Validate(srcW, srcH int) error {
+	if c := e.Crop; c != nil {
+		if err := c.validate(); err != nil {
+			return err
+		}
+		if srcW > 0 && srcH > 0 && (c.X+c.Width > srcW || c.Y+c.Height > srcH) {
+			return fmt.Errorf("%w: crop %dx%d+%d+%d is outside of %dx%d image",
+				ErrInvalidOptions, c.Width, c.Height, c.X, c.Y, srcW, srcH)
+		}
+	}
+	return e.Adjust.Validate()
+}
+
+// IsZero сообщает, что правка ничего не меняет.
+func (e Edit) IsZero() bool {
+	return e.Crop == nil && e.Adjust.IsZero()
+}
+
+// Size возвращает размеры фото после правки по размерам оригинала.
+func (e *Edit) Size(srcW, srcH int) (int, int) {
+	if e == nil {
+		return srcW, srcH
+	}
+	if c := e.Crop; c != nil {
+		srcW, srcH = c.Width, c.Height
+	}
+	if e.Adjust.Rotate == 90 || e.Adjust.Rotate == 270 {
+		srcW, srcH = srcH, srcW
+	}
+	return srcW, srcH
+}
+
+// key – отпечаток правки для ключа кэша: варианты разных правок не
+// пересекаются, а возврат к прошлой правке даёт прежние ключи.
+func (e Edit) key() string {
+	data, _ := json.Marshal(e)
+	sum := sha256.Sum256(data)
+	return "e" + hex.EncodeToString(sum[:6])
+}
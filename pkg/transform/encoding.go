@@ -0,0 +1,172 @@
+package transform
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Subsample – субдискретизация цветности JPEG.
+type Subsample string
+
+const (
+	SubsampleAuto Subsample = ""    // решает libvips: 4:2:0 при качестве ниже 90
+	Subsample420  Subsample = "420" // цветность в половинном разрешении
+	Subsample444  Subsample = "444" // без субдискретизации – чёткие цветные края и текст
+)
+
+// Максимальные значения effort по форматам (минимум – 0, у jxl – 1).
+var maxEffort = map[string]int{FormatWebP: 6, FormatAVIF: 9, FormatJXL: 9}
+
+// Encoding – настройки кодировщика. Нулевые значения – умолчания libvips.
+// Каждый формат использует только свои поля (см. For), поэтому одни и те же
+// настройки можно задать пресету сразу для нескольких форматов.
+type Encoding struct {
+	Progressive  bool      // jpeg: progressive, png: interlace (Adam7)
+	Trellis      bool      // jpeg: trellis-квантование, overshoot deringing и оптимизация сканов (mozjpeg)
+	QuantTable   int       // jpeg: таблица квантования mozjpeg 0..8 (0 – стандартная из Annex K)
+	Subsample    Subsample // jpeg: субдискретизация цветности
+	Lossless     bool      // webp, avif, jxl: сжатие без потерь
+	NearLossless bool      // webp: почти без потерь (предобработка, сила задаётся качеством)
+	Effort       int       // webp 0..6, avif 0..9, jxl 1..9: усилие кодировщика, 0 – по умолчанию
+	BitDepth     int       // avif: 8, 10 или 12 бит на канал, 0 – 8
+}
+
+// Normalize приводит эквивалентные настройки к одному виду.
+func (e *Encoding) Normalize() {
+	// near-lossless в libvips – режим lossless-кодировщика webp
+	if e.NearLossless {
+		e.Lossless = true
+	}
+	if e.BitDepth == 8 {
+		e.BitDepth = 0
+	}
+}
+
+// Validate проверяет настройки. Effort сверяется с границами format, если
+// он известен, иначе – с наибольшей из границ.
+func (e Encoding) Validate(format string) error {
+	if e.QuantTable < 0 || e.QuantTable > 8 {
+		return fmt.Errorf("%w: quant table must be between 0 and 8", ErrInvalidOptions)
+	}
+	switch e.Subsample {
+	case SubsampleAuto, Subsample420, Subsample444:
+	default:
+		return fmt.Errorf("%w: subsample must be auto, 420 or 444", ErrInvalidOptions)
+	}
+	limit, ok := maxEffort[format]
+	if !ok {
+		limit = maxEffort[FormatAVIF]
+	}
+	if e.Effort < 0 || e.Effort > limit {
+		return fmt.Errorf("%w: effort must be between 0 and %d", ErrInvalidOptions, limit)
+	}
+	switch e.BitDepth {
+	case 0, 8, 10, 12:
+	default:
+		return fmt.Errorf("%w: bit depth must be 8, 10 or 12", ErrInvalidOptions)
+	}
+	return nil
+}
+
+// For оставляет только настройки, которые использует кодировщик format.
+// Effort выше предела формата ограничивается им.
+func (e Encoding) For(format string) Encoding {
+	var r Encoding
+	switch format {
+	case FormatJPEG:
+		r.Progressive, r.Trellis, r.QuantTable, r.Subsample = e.Progressive, e.Trellis, e.QuantTable, e.Subsample
+	case FormatPNG:
+		r.Progressive = e.Progressive
+	case FormatWebP:
+		r.Lossless, r.NearLossless, r.Effort = e.Lossless, e.NearLossless, e.Effort
+	case FormatAVIF:
+		r.Lossless, r.Effort, r.BitDepth = e.Lossless, e.Effort, e.BitDepth
+	case FormatJXL:
+		r.Lossless, r.Effort = e.Lossless, e.Effort
+	}
+	if limit, ok := maxEffort[format]; ok {
+		r.Effort = min(r.Effort, limit)
+	}
+	return r
+}
+
+// keyParts возвращает части ключа кэша (вызывать для результата For).
+func (e Encoding) keyParts() []string {
+	var parts []string
+	if e.Progressive {
+		parts = append(parts, "prog")
+	}
+	if e.Trellis {
+		parts = append(parts, "trellis")
+	}
+	if e.QuantTable > 0 {
+		parts = append(parts, "qt"+strconv.Itoa(e.QuantTable))
+	}
+	if e.Subsample != SubsampleAuto {
+		parts = append(parts, "ss"+string(e.Subsample))
+	}
+	if e.NearLossless {
+		parts = append(parts, "nearlossless")
+	} else if e.Lossless {
+		parts = append(parts, "lossless")
+	}
+	if e.Effort > 0 {
+		parts = append(parts, "eff"+strconv.Itoa(e.Effort))
+	}
+	if e.BitDepth > 0 {
+		parts = append(parts, "d"+strconv.Itoa(e.BitDepth))
+	}
+	return parts
+}
+
+// ParseSubsample разбирает субдискретизацию: auto, 420 (on) или 444 (off).
+func ParseSubsample(s string) (Subsample, error) {
+	switch strings.ToLower(strings.TrimSpace(s)) {
+	case "", "auto":
+		return SubsampleAuto, nil
+	case "420", "on":
+		return Subsample420, nil
+	case "444", "off":
+		return Subsample444, nil
+	}
+	return "", fmt.Errorf("%w: subsample must be auto, 420 or 444", ErrInvalidOptions)
+}
+
+// ParseEncoding разбирает настройки кодировщика из списка через запятую или
+// «+», например "progressive,trellis,subsample=444,effort=6". Флаги:
+// progressive, trellis, lossless, near_lossless; значения: quant, subsample,
+// effort, depth. Результат не проверяется – см. Validate.
+func ParseEncoding(spec string) (Encoding, error) {
+	var e Encoding
+	items := strings.FieldsFunc(spec, func(r rune) bool { return r == ',' || r == '+' })
+	for _, item := range items {
+		name, val, hasVal := strings.Cut(strings.TrimSpace(item), "=")
+		name = strings.ToLower(strings.TrimSpace(name))
+		var err error
+		switch name {
+		case "progressive":
+			e.Progressive = true
+		case "trellis":
+			e.Trellis = true
+		case "lossless":
+			e.Lossless = true
+		case "near_lossless":
+			e.NearLossless = true
+		case "subsample":
+			e.Subsample, err = ParseSubsample(val)
+		case "quant":
+			e.QuantTable, err = strconv.Atoi(strings.TrimSpace(val))
+		case "effort":
+			e.Effort, err = strconv.Atoi(strings.TrimSpace(val))
+		case "depth":
+			e.BitDepth, err = strconv.Atoi(strings.TrimSpace(val))
+		default:
+			return e, fmt.Errorf("%w: unknown encoder option %q", ErrInvalidOptions, name)
+		}
+		if err != nil || (!hasVal && (name == "quant" || name == "effort" || name == "depth" || name == "subsample")) {
+			return e, fmt.Errorf("%w: invalid encoder option %q", ErrInvalidOptions, item)
+		}
+	}
+	return e, nil
+}
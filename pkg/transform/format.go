@@ -0,0 +1,47 @@
+package transform
+
+// Форматы результата, которые умеет кодировать процессор.
+const (
+	FormatJPEG = "jpeg"
+	FormatWebP = "webp"
+	FormatAVIF = "avif"
+	FormatJXL  = "jxl"
+	FormatPNG  = "png"
+	FormatGIF  = "gif"
+)
+
+// OutputFormats – все поддерживаемые форматы результата.
+var OutputFormats = []string{FormatJPEG, FormatWebP, FormatAVIF, FormatJXL, FormatPNG, FormatGIF}
+
+// IsOutputFormat сообщает, поддерживается ли формат результата.
+func IsOutputFormat(format string) bool {
+	for _, f := range OutputFormats {
+		if f == format {
+			return true
+		}
+	}
+	return false
+}
+
+// MimeType возвращает MIME-тип формата результата (jpeg для неизвестных).
+func MimeType(format string) string {
+	switch format {
+	case FormatWebP:
+		return "image/webp"
+	case FormatAVIF:
+		return "image/avif"
+	case FormatJXL:
+		return "image/jxl"
+	case FormatPNG:
+		return "image/png"
+	case FormatGIF:
+		return "image/gif"
+	default:
+		return "image/jpeg"
+	}
+}
+
+// Animatable сообщает, может ли формат результата хранить анимацию.
+func Animatable(format string) bool {
+	return format == FormatWebP || format == FormatGIF
+}
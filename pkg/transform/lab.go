@@ -0,0 +1,43 @@
+package transform
+
+import "math"
+
+// Lab – цвет в пространстве CIELAB (белая точка D65): L 0..100, a и b
+// примерно -128..127. Евклидово расстояние между цветами (ΔE CIE76) близко
+// к воспринимаемой разнице: около 2 – едва заметна, больше 20 – другой цвет.
+type Lab struct {
+	L, A, B float64
+}
+
+// Lab переводит цвет sRGB в CIELAB.
+func (c RGB) Lab() Lab {
+	r, g, b := linear(c.R), linear(c.G), linear(c.B)
+	// sRGB -> XYZ, нормированный на белую точку D65
+	x := (0.4124564*r + 0.3575761*g + 0.1804375*b) / 0.95047
+	y := 0.2126729*r + 0.7151522*g + 0.0721750*b
+	z := (0.0193339*r + 0.1191920*g + 0.9503041*b) / 1.08883
+	fx, fy, fz := labF(x), labF(y), labF(z)
+	return Lab{L: 116*fy - 16, A: 500 * (fx - fy), B: 200 * (fy - fz)}
+}
+
+// DeltaE возвращает расстояние ΔE CIE76 между цветами.
+func (l Lab) DeltaE(o Lab) float64 {
+	return math.Sqrt((l.L-o.L)*(l.L-o.L) + (l.A-o.A)*(l.A-o.A) + (l.B-o.B)*(l.B-o.B))
+}
+
+// linear снимает гамму sRGB с канала.
+func linear(v uint8) float64 {
+	c := float64(v) / 255
+	if c <= 0.04045 {
+		return c / 12.92
+	}
+	return math.Pow((c+0.055)/1.055, 2.4)
+}
+
+func labF(t float64) float64 {
+	const delta = 6.0 / 29
+	if t > delta*delta*delta {
+		return math.Cbrt(t)
+	}
+	return t/(3*delta*delta) + 4.0/29
+}
@@ -0,0 +1,409 @@
+// Package transform описывает параметры трансформации изображений,
+// их валидацию и каноническое представление для ключей кэша вариантов.
+package transform
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// ErrInvalidOptions – параметры трансформации вне допустимых границ.
+var ErrInvalidOptions = errors.New("invalid transform options")
+
+// Ограничения на параметры запроса.
+const (
+	MaxDimension  = 4096   // максимальная ширина/высота результата
+	MaxCropOffset = 100000 // максимальная координата/размер прямоугольника обрезки
+	MaxFrame      = 10000  // максимальный номер кадра анимации
+)
+
+// pipelineVersion – версия конвейера обработки. Увеличивается, когда при тех
+// же параметрах меняется результат (2 – поворот по EXIF и перевод в sRGB):
+// ключи и хэши вариантов меняются вместе с ней, и готовые варианты в кэшах
+// больше не находятся.
+const pipelineVersion = 2
+
+// QualityAuto – значение Quality для q=auto: качество подбирается при
+// кодировании как наименьшее, дающее результат, визуально близкий к несжатому.
+const QualityAuto = -1
+
+// Fit – способ вписывания изображения в заданные width×height.
+type Fit string
+
+const (
+	FitCover   Fit = "cover"   // заполнить рамку, лишнее обрезать по gravity
+	FitContain Fit = "contain" // вписать целиком, свободное место залить фоном
+	FitFill    Fit = "fill"    // растянуть без сохранения пропорций
+	FitInside  Fit = "inside"  // вписать целиком без полей (результат не больше рамки)
+	FitOutside Fit = "outside" // покрыть рамку без обрезки (результат не меньше рамки)
+)
+
+// Gravity – точка привязки при обрезке (cover) и размещении (contain).
+type Gravity string
+
+const (
+	GravityCenter    Gravity = "center"
+	GravityNorth     Gravity = "north"
+	GravitySouth     Gravity = "south"
+	GravityEast      Gravity = "east"
+	GravityWest      Gravity = "west"
+	GravityNorthEast Gravity = "northeast"
+	GravityNorthWest Gravity = "northwest"
+	GravitySouthEast Gravity = "southeast"
+	GravitySouthWest Gravity = "southwest"
+	GravitySmart     Gravity = "smart"   // libvips attention: лица, кожа, насыщенные области
+	GravityEntropy   Gravity = "entropy" // libvips entropy: наиболее детализированная область
+)
+
+// Anchor возвращает относительное положение точки привязки по осям (0, 0.5 или 1).
+// Для smart/entropy возвращается центр – они обрабатываются отдельно.
+func (g Gravity) Anchor() (x, y float64) {
+	x, y = 0.5, 0.5
+	if strings.Contains(string(g), "west") {
+		x = 0
+	} else if strings.Contains(string(g), "east") {
+		x = 1
+	}
+	if strings.HasPrefix(string(g), "north") {
+		y = 0
+	} else if strings.HasPrefix(string(g), "south") {
+		y = 1
+	}
+	return x, y
+}
+
+// Color – цветовое пространство результата.
+type Color string
+
+const (
+	// ColorSRGB – перевести в sRGB и не встраивать профиль (браузеры считают
+	// изображения без профиля sRGB).
+	ColorSRGB Color = "srgb"
+	// ColorP3 – изображения со встроенным профилем перевести в Display P3 и
+	// встроить профиль, чтобы не потерять широкий охват. Без профиля и в gif,
+	// который не хранит профиль, – как sRGB.
+	ColorP3 Color = "p3"
+)
+
+// Crop – прямоугольник, вырезаемый из оригинала до масштабирования.
+type Crop struct {
+	X      int `json:"x"`
+	Y      int `json:"y"`
+	Width  int `json:"width"`
+	Height int `json:"height"`
+}
+
+// validate проверяет, что прямоугольник задан и не выходит за MaxCropOffset.
+func (c Crop) validate() error {
+	if c.X < 0 || c.Y < 0 || c.Width <= 0 || c.Height <= 0 ||
+		c.X > MaxCropOffset || c.Y > MaxCropOffset || c.Width > MaxCropOffset || c.Height > MaxCropOffset {
+		return fmt.Errorf("%w: crop must be x,y,width,height with non-negative offsets and positive size", ErrInvalidOptions)
+	}
+	return nil
+}
+
+// Options – параметры одной трансформации.
+type Options struct {
+	Width   int
+	Height  int
+	Fit     Fit
+	Gravity Gravity
+	Crop    *Crop
+	Format  string
+	Quality int
+	// Encoding – настройки кодировщика (progressive, effort и т. п.).
+	Encoding Encoding
+	// Frame – кадр анимированного изображения (с 1), который отдаётся как
+	// статичный постер. 0 – сохранить анимацию, если формат результата её
+	// поддерживает (webp, gif), иначе взять первый кадр.
+	Frame int
+	// Color – цветовое пространство результата (по умолчанию sRGB).
+	Color Color
+	// Adjust – коррекции и фильтры (поворот, ч/б, размытие и т. п.).
+	Adjust Adjustments
+	// Watermark – водяной знак поверх результата (nil – без знака).
+	Watermark *Watermark
+	// Edit – сохранённая правка фото, применяемая к оригиналу до остальных
+	// параметров: Crop и Adjust задаются уже относительно результата правки.
+	Edit *Edit
+}
+
+// Normalize приводит эквивалентные наборы параметров к одному виду,
+// чтобы они давали одинаковый ключ кэша.
+func (o *Options) Normalize() {
+	if o.Width > 0 && o.Height > 0 {
+		if o.Fit == "" {
+			o.Fit = FitCover
+		}
+		if o.Gravity == "" || (o.Fit != FitCover && o.Fit != FitContain) {
+			o.Gravity = GravityCenter
+		}
+	} else {
+		// задан один размер – пропорции сохраняются, fit и gravity ни на что не влияют
+		o.Fit, o.Gravity = "", ""
+	}
+	if o.Crop != nil && *o.Crop == (Crop{}) {
+		o.Crop = nil
+	}
+	if o.Color == "" {
+		o.Color = ColorSRGB
+	}
+	o.Adjust.Normalize()
+	o.Encoding.Normalize()
+	if o.Edit != nil {
+		o.Edit.Normalize()
+		if o.Edit.IsZero() {
+			o.Edit = nil
+		}
+	}
+}
+
+// Validate проверяет параметры на допустимые значения.
+func (o Options) Validate() error {
+	if o.Width < 0 || o.Width > MaxDimension || o.Height < 0 || o.Height > MaxDimension {
+		return fmt.Errorf("%w: width and height must be between 0 and %d", ErrInvalidOptions, MaxDimension)
+	}
+	switch o.Fit {
+	case "", FitCover, FitContain, FitFill, FitInside, FitOutside:
+	default:
+		return fmt.Errorf("%w: unknown fit %q", ErrInvalidOptions, o.Fit)
+	}
+	switch o.Gravity {
+	case "", GravityCenter, GravityNorth, GravitySouth, GravityEast, GravityWest,
+		GravityNorthEast, GravityNorthWest, GravitySouthEast, GravitySouthWest:
+	case GravitySmart, GravityEntropy:
+		if o.Fit != FitCover {
+			return fmt.Errorf("%w: gravity %q requires fit=cover", ErrInvalidOptions, o.Gravity)
+		}
+	default:
+		return fmt.Errorf("%w: unknown gravity %q", ErrInvalidOptions, o.Gravity)
+	}
+	if o.Crop != nil {
+		if err := o.Crop.validate(); err != nil {
+			return err
+		}
+	}
+	if (o.Quality < 1 || o.Quality > 100) && o.Quality != QualityAuto {
+		return fmt.Errorf("%w: quality must be between 1 and 100 or auto", ErrInvalidOptions)
+	}
+	if err := o.Encoding.Validate(o.Format); err != nil {
+		return err
+	}
+	if o.Frame < 0 || o.Frame > MaxFrame {
+		return fmt.Errorf("%w: frame must be between 1 and %d", ErrInvalidOptions, MaxFrame)
+	}
+	switch o.Color {
+	case "", ColorSRGB, ColorP3:
+	default:
+		return fmt.Errorf("%w: unknown color %q", ErrInvalidOptions, o.Color)
+	}
+	if o.Watermark != nil {
+		if err := o.Watermark.Validate(); err != nil {
+			return err
+		}
+	}
+	if o.Edit != nil {
+		if err := o.Edit.Validate(0, 0); err != nil {
+			return err
+		}
+	}
+	return o.Adjust.Validate()
+}
+
+// Animated сообщает, нужно ли сохранить анимацию источника в результате.
+func (o Options) Animated() bool {
+	return o.Frame == 0 && Animatable(o.Format)
+}
+
+// Key возвращает каноническое представление параметров без формата,
+// например "p2-c0.0.800.600-w400-h300-cover-center-q80". Из настроек
+// кодировщика в ключ попадают только те, что использует Format.
+// Вызывать после Normalize.
+func (o Options) Key() string {
+	parts := []string{"p" + strconv.Itoa(pipelineVersion)}
+	if o.Edit != nil {
+		parts = append(parts, o.Edit.key())
+	}
+	if c := o.Crop; c != nil {
+		parts = append(parts, fmt.Sprintf("c%d.%d.%d.%d", c.X, c.Y, c.Width, c.Height))
+	}
+	if o.Width > 0 {
+		parts = append(parts, "w"+strconv.Itoa(o.Width))
+	}
+	if o.Height > 0 {
+		parts = append(parts, "h"+strconv.Itoa(o.Height))
+	}
+	if o.Fit != "" {
+		parts = append(parts, string(o.Fit))
+	}
+	if o.Gravity != "" {
+		parts = append(parts, string(o.Gravity))
+	}
+	if o.Quality == QualityAuto {
+		parts = append(parts, "qauto")
+	} else {
+		parts = append(parts, "q"+strconv.Itoa(o.Quality))
+	}
+	parts = append(parts, o.Encoding.For(o.Format).keyParts()...)
+	if o.Frame > 0 {
+		parts = append(parts, "f"+strconv.Itoa(o.Frame))
+	}
+	// sRGB – умолчание, в ключ не попадает
+	if o.Color == ColorP3 {
+		parts = append(parts, string(o.Color))
+	}
+	parts = append(parts, o.Adjust.keyParts()...)
+	if o.Watermark != nil {
+		parts = append(parts, o.Watermark.key())
+	}
+	return strings.Join(parts, "-")
+}
+
+// sourceSize возвращает размеры изображения перед масштабированием:
+// результата правки, затем области обрезки, если она задана, с учётом
+// поворота на 90° или 270°.
+func (o Options) sourceSize(srcW, srcH int) (int, int) {
+	srcW, srcH = o.Edit.Size(srcW, srcH)
+	if c := o.Crop; c != nil {
+		srcW, srcH = c.Width, c.Height
+	}
+	if o.Adjust.Rotate == 90 || o.Adjust.Rotate == 270 {
+		srcW, srcH = srcH, srcW
+	}
+	return srcW, srcH
+}
+
+// EstimatePixels оценивает площадь результата в пикселях по размерам
+// источника (или области обрезки с учётом поворота). Если источник неизвестен, недостающая
+// сторона считается равной заданной.
+func (o Options) EstimatePixels(srcW, srcH int) int {
+	w, h := o.EstimateSize(srcW, srcH)
+	return w * h
+}
+
+// EstimateSize оценивает размеры результата так же, как EstimatePixels.
+func (o Options) EstimateSize(srcW, srcH int) (int, int) {
+	srcW, srcH = o.sourceSize(srcW, srcH)
+	w, h := o.Width, o.Height
+	switch {
+	case w > 0 && h > 0:
+	case w > 0 && srcW > 0 && srcH > 0:
+		h = int(math.Round(float64(w) * float64(srcH) / float64(srcW)))
+	case h > 0 && srcW > 0 && srcH > 0:
+		w = int(math.Round(float64(h) * float64(srcW) / float64(srcH)))
+	case w > 0:
+		h = w
+	case h > 0:
+		w = h
+	default:
+		w, h = srcW, srcH
+	}
+	return w, h
+}
+
+// Scale оценивает масштаб, с которым источник (результат правки или область
+// обрезки с учётом поворота) попадает в результат: для cover, fill и outside
+// – по стороне, которая заполняет рамку, для contain и inside – по стороне,
+// которая в неё вписывается. Без размеров результата – 1, при неизвестном
+// источнике – 0.
+func (o Options) Scale(srcW, srcH int) float64 {
+	srcW, srcH = o.sourceSize(srcW, srcH)
+	if srcW <= 0 || srcH <= 0 {
+		return 0
+	}
+	sx, sy := float64(o.Width)/float64(srcW), float64(o.Height)/float64(srcH)
+	switch {
+	case o.Width > 0 && o.Height > 0:
+		if o.Fit == FitContain || o.Fit == FitInside {
+			return math.Min(sx, sy)
+		}
+		return math.Max(sx, sy)
+	case o.Width > 0:
+		return sx
+	case o.Height > 0:
+		return sy
+	}
+	return 1
+}
+
+// Hash возвращает короткий детерминированный хэш параметров вместе с форматом.
+// Одинаковые нормализованные параметры всегда дают один и тот же хэш,
+// поэтому он используется как имя объекта варианта в хранилище.
+func (o Options) Hash() string {
+	sum := sha256.Sum256([]byte(o.Key() + "." + o.Format))
+	return hex.EncodeToString(sum[:12])
+}
+
+// ParseCrop разбирает прямоугольник обрезки в формате "x,y,width,height".
+func ParseCrop(s string) (*Crop, error) {
+	fields := strings.Split(s, ",")
+	if len(fields) != 4 {
+		return nil, fmt.Errorf("%w: crop must be x,y,width,height", ErrInvalidOptions)
+	}
+	var v [4]int
+	for i, f := range fields {
+		n, err := strconv.Atoi(strings.TrimSpace(f))
+		if err != nil {
+			return nil, fmt.Errorf("%w: crop must be x,y,width,height", ErrInvalidOptions)
+		}
+		v[i] = n
+	}
+	return &Crop{X: v[0], Y: v[1], Width: v[2], Height: v[3]}, nil
+}
+
+// ParseQuality разбирает качество: число 1..100 или "auto" (QualityAuto).
+// Диапазон числа проверяет Validate.
+func ParseQuality(s string) (int, error) {
+	s = strings.TrimSpace(s)
+	if strings.EqualFold(s, "auto") {
+		return QualityAuto, nil
+	}
+	q, err := strconv.Atoi(s)
+	if err != nil {
+		return 0, fmt.Errorf("%w: quality must be a number or auto", ErrInvalidOptions)
+	}
+	return q, nil
+}
+
+// MaxDPR – максимальный множитель плотности пикселей.
+const MaxDPR = 4.0
+
+// ApplyDPR умножает запрошенные размеры на плотность пикселей экрана.
+// Результат ограничивается размерами источника srcW×srcH (оригинала или
+// прямоугольника обрезки, с учётом поворота), но не становится меньше исходного запроса;
+// пропорции рамки сохраняются. Нулевые srcW/srcH означают «размер неизвестен».
+func (o *Options) ApplyDPR(dpr float64, srcW, srcH int) {
+	if dpr <= 1 || (o.Width == 0 && o.Height == 0) {
+		return
+	}
+	srcW, srcH = o.sourceSize(srcW, srcH)
+	w, h := float64(o.Width)*dpr, float64(o.Height)*dpr
+
+	// коэффициент ограничения: не больше источника (если он больше запроса) и не больше MaxDimension
+	limit := func(scaled float64, requested, src int) float64 {
+		maxV := float64(MaxDimension)
+		if src > requested && float64(src) < maxV {
+			maxV = float64(src)
+		} else if src > 0 && src <= requested {
+			maxV = float64(requested)
+		}
+		if scaled > maxV {
+			return maxV / scaled
+		}
+		return 1
+	}
+	f := 1.0
+	if o.Width > 0 {
+		f = math.Min(f, limit(w, o.Width, srcW))
+	}
+	if o.Height > 0 {
+		f = math.Min(f, limit(h, o.Height, srcH))
+	}
+	o.Width = int(math.Round(w * f))
+	o.Height = int(math.Round(h * f))
+}
@@ -0,0 +1,58 @@
+package transform
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"strconv"
+	"unicode/utf8"
+)
+
+// Ограничения водяного знака.
+const (
+	MaxWatermarkText = 200 // максимальная длина текста в символах
+	MinWatermarkSize = 0.05
+)
+
+// Watermark – водяной знак, накладываемый на вариант последним шагом:
+// текст или изображение, размещённые по Gravity.
+type Watermark struct {
+	Text    string  // текст знака; пусто – используется изображение
+	Image   string  // ключ изображения знака в хранилище
+	Gravity Gravity // угол, сторона или центр (smart/entropy не допускаются)
+	Opacity float64 // непрозрачность 0..1
+	Scale   float64 // ширина знака относительно ширины результата
+}
+
+// Validate проверяет параметры водяного знака.
+func (w Watermark) Validate() error {
+	if (w.Text == "") == (w.Image == "") {
+		return fmt.Errorf("%w: watermark needs either text or image", ErrInvalidOptions)
+	}
+	if utf8.RuneCountInString(w.Text) > MaxWatermarkText {
+		return fmt.Errorf("%w: watermark text must not exceed %d characters", ErrInvalidOptions, MaxWatermarkText)
+	}
+	switch w.Gravity {
+	case GravityCenter, GravityNorth, GravitySouth, GravityEast, GravityWest,
+		GravityNorthEast, GravityNorthWest, GravitySouthEast, GravitySouthWest:
+	default:
+		return fmt.Errorf("%w: unknown watermark position %q", ErrInvalidOptions, w.Gravity)
+	}
+	if w.Opacity <= 0 || w.Opacity > 1 {
+		return fmt.Errorf("%w: watermark opacity must be in (0, 1]", ErrInvalidOptions)
+	}
+	if w.Scale < MinWatermarkSize || w.Scale > 1 {
+		return fmt.Errorf("%w: watermark scale must be between %g and 1", ErrInvalidOptions, MinWatermarkSize)
+	}
+	return nil
+}
+
+// key – короткий отпечаток знака для ключа кэша: текст может быть длинным,
+// а ключ изображения уникален для каждой загрузки, поэтому смена знака
+// даёт новые варианты.
+func (w Watermark) key() string {
+	spec := w.Text + "\x00" + w.Image + "\x00" + string(w.Gravity) + "\x00" +
+		strconv.FormatFloat(w.Opacity, 'f', -1, 64) + "\x00" + strconv.FormatFloat(w.Scale, 'f', -1, 64)
+	sum := sha256.Sum256([]byte(spec))
+	return "wm" + hex.EncodeToString(sum[:6])
+}
@@ -0,0 +1,168 @@
+package vips
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"math"
+	"slices"
+)
+
+// PaletteSize – число цветов палитры фото.
+const PaletteSize = 5
+
+// Palette возвращает до n основных цветов изображения в виде "#rrggbb" по
+// убыванию доли пикселей; первый цвет – доминирующий. Начальные цвета
+// находятся медианным сечением: на каждом шаге делится пополам (по медиане)
+// группа пикселей с наибольшим разбросом по одному из каналов с учётом её
+// размера. Медиана может пройти внутри однотонной области и смешать её с
+// соседней, поэтому затем цвета уточняются несколькими шагами k-means.
+// Пиксели, прозрачные больше чем наполовину, не учитываются. Однотонное
+// изображение даёт один цвет, полностью прозрачное – пустую палитру.
+// Рассчитана на маленькое превью (см. Analyze).
+func Palette(img image.Image, n int) []string {
+	b := img.Bounds()
+	pixels := make([][3]uint8, 0, b.Dx()*b.Dy())
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		for x := b.Min.X; x < b.Max.X; x++ {
+			c := color.NRGBAModel.Convert(img.At(x, y)).(color.NRGBA)
+			if c.A >= 128 {
+				pixels = append(pixels, [3]uint8{c.R, c.G, c.B})
+			}
+		}
+	}
+	if len(pixels) == 0 || n <= 0 {
+		return nil
+	}
+
+	boxes := []colorBox{newColorBox(pixels)}
+	for len(boxes) < n {
+		best, bestScore := -1, 0
+		for i, box := range boxes {
+			if score := len(box.pixels) * box.spread; score > bestScore {
+				best, bestScore = i, score
+			}
+		}
+		if best < 0 {
+			break // остались только однотонные группы
+		}
+		low, high := boxes[best].split()
+		boxes[best] = low
+		boxes = append(boxes, high)
+	}
+	centers := make([][3]float64, len(boxes))
+	for i, box := range boxes {
+		centers[i] = box.mean()
+	}
+	clusters := refine(pixels, centers)
+	slices.SortStableFunc(clusters, func(a, b cluster) int { return b.count - a.count })
+
+	palette := make([]string, 0, len(clusters))
+	for _, c := range clusters {
+		hex := fmt.Sprintf("#%02x%02x%02x", uint8(math.Round(c.center[0])), uint8(math.Round(c.center[1])), uint8(math.Round(c.center[2])))
+		if !slices.Contains(palette, hex) {
+			palette = append(palette, hex)
+		}
+	}
+	return palette
+}
+
+// paletteIterations – наибольшее число шагов k-means при уточнении палитры.
+const paletteIterations = 8
+
+// cluster – цвет палитры и число пикселей, отнесённых к нему.
+type cluster struct {
+	center [3]float64
+	count  int
+}
+
+// refine уточняет цвета centers шагами k-means: каждый пиксель относится к
+// ближайшему цвету, цвет заменяется средним своих пикселей. Цвета без
+// пикселей отбрасываются.
+func refine(pixels [][3]uint8, centers [][3]float64) []cluster {
+	clusters := make([]cluster, len(centers))
+	for i, c := range centers {
+		clusters[i].center = c
+	}
+	sums := make([][3]float64, len(centers))
+	for range paletteIterations {
+		for i := range clusters {
+			clusters[i].count, sums[i] = 0, [3]float64{}
+		}
+		for _, p := range pixels {
+			nearest, dist := 0, math.Inf(1)
+			for i, c := range clusters {
+				var d float64
+				for ch := range 3 {
+					diff := float64(p[ch]) - c.center[ch]
+					d += diff * diff
+				}
+				if d < dist {
+					nearest, dist = i, d
+				}
+			}
+			clusters[nearest].count++
+			for ch := range 3 {
+				sums[nearest][ch] += float64(p[ch])
+			}
+		}
+		moved := false
+		for i := range clusters {
+			if clusters[i].count == 0 {
+				continue
+			}
+			for ch := range 3 {
+				c := sums[i][ch] / float64(clusters[i].count)
+				moved = moved || math.Abs(c-clusters[i].center[ch]) > 0.5
+				clusters[i].center[ch] = c
+			}
+		}
+		if !moved {
+			break
+		}
+	}
+	return slices.DeleteFunc(clusters, func(c cluster) bool { return c.count == 0 })
+}
+
+// colorBox – группа пикселей медианного сечения. channel – канал с
+// наибольшим разбросом, spread – сам разброс (max-min).
+type colorBox struct {
+	pixels  [][3]uint8
+	channel int
+	spread  int
+}
+
+func newColorBox(pixels [][3]uint8) colorBox {
+	box := colorBox{pixels: pixels}
+	for ch := range 3 {
+		lo, hi := 255, 0
+		for _, p := range pixels {
+			lo, hi = min(lo, int(p[ch])), max(hi, int(p[ch]))
+		}
+		if hi-lo > box.spread {
+			box.channel, box.spread = ch, hi-lo
+		}
+	}
+	return box
+}
+
+// split делит группу по медиане канала с наибольшим разбросом.
+func (b colorBox) split() (colorBox, colorBox) {
+	slices.SortFunc(b.pixels, func(p, q [3]uint8) int { return int(p[b.channel]) - int(q[b.channel]) })
+	mid := len(b.pixels) / 2
+	return newColorBox(b.pixels[:mid]), newColorBox(b.pixels[mid:])
+}
+
+// mean возвращает средний цвет группы.
+func (b colorBox) mean() [3]float64 {
+	var sum [3]float64
+	for _, p := range b.pixels {
+		for ch := range 3 {
+			sum[ch] += float64(p[ch])
+		}
+	}
+	for ch := range 3 {
+		sum[ch] /= float64(len(b.pixels))
+	}
+	return sum
+}
@@ -1,56 +1,624 @@
 package vips
 
 import (
+	"bytes"
+	"cmp"
+	"errors"
 	"fmt"
+	"image/png"
 	"log"
+	"math"
 
 	vips "github.com/davidbyttow/govips/v2/vips"
+
+	"github.com/freshtea599/PhotoHubServer.git/pkg/phash"
+	"github.com/freshtea599/PhotoHubServer.git/pkg/transform"
 )
 
-type Processor struct{}
+// ErrSourceTooLarge – исходное изображение превышает Limits.
+var ErrSourceTooLarge = errors.New("source image exceeds size limits")
+
+// Limits – ограничения исходного изображения, проверяемые по заголовку до
+// декодирования пикселей (защита от decompression bomb). 0 – без ограничения.
+type Limits struct {
+	MaxPixels    int // площадь всех загруженных кадров
+	MaxDimension int // ширина или высота кадра
+}
+
+type Processor struct {
+	limits Limits
+	// целевой SSIM для transform.QualityAuto
+	qualityTarget float64
+}
 
-func NewProcessor() (*Processor, error) {
+// NewProcessor запускает libvips. qualityTarget – SSIM, который должен
+// обеспечивать подобранный для q=auto уровень качества.
+func NewProcessor(limits Limits, qualityTarget float64) (*Processor, error) {
 	// Инициализация libvips с настройками по умолчанию
 	vips.Startup(nil)
 	// Необязательное логирование (если не сработает — закомментируйте)
 	vips.LoggingSettings(func(messageDomain string, messageLevel vips.LogLevel, message string) {
 		log.Printf("[vips] %s: %s", messageDomain, message)
 	}, vips.LogLevelWarning)
-	return &Processor{}, nil
+	return &Processor{limits: limits, qualityTarget: qualityTarget}, nil
 }
 
-// Transform принимает байты изображения, желаемую ширину, формат и качество.
-// Возвращает байты трансформированного изображения.
-func (p *Processor) Transform(data []byte, width int, format string, quality int) ([]byte, error) {
-	img, err := vips.NewImageFromBuffer(data)
+// Result – закодированное изображение, его фактические размеры
+// и размеры исходного изображения (до обрезки и масштабирования).
+// Для анимации высота – высота одного кадра.
+type Result struct {
+	Data         []byte
+	Width        int
+	Height       int
+	SourceWidth  int
+	SourceHeight int
+	// Quality – качество, с которым закодирован результат (для q=auto –
+	// подобранное; 0 – формат без потерь, качество не применяется)
+	Quality int
+	// число кадров и суммарная длительность анимации источника, палитра
+	// превью и перцептивный хэш оригинала (заполняет Analyze)
+	SourceFrames     int
+	SourceDurationMs int
+	Palette          []string
+	PHash            *phash.Hash
+}
+
+// Transform принимает байты изображения и параметры трансформации
+// (обрезка, размеры, fit, gravity, формат, качество и кадр).
+// Анимированный GIF/WebP в webp/gif сохраняется анимированным: каждый кадр
+// обрабатывается одинаково. watermark – изображение водяного знака, если
+// opts.Watermark задан картинкой. Возвращает изображение с его итоговыми размерами.
+func (p *Processor) Transform(data []byte, opts transform.Options, watermark []byte) (*Result, error) {
+	img, err := p.load(data, opts.Animated(), max(opts.Frame-1, 0))
 	if err != nil {
+		if errors.Is(err, ErrSourceTooLarge) {
+			return nil, err
+		}
+		if opts.Frame > 1 {
+			return nil, fmt.Errorf("%w: frame %d: %v", transform.ErrInvalidOptions, opts.Frame, err)
+		}
 		return nil, fmt.Errorf("decode image: %w", err)
 	}
 	defer img.Close()
+	srcW, srcH := img.Width(), img.PageHeight()
 
-	// Ресайз по ширине, сохраняя пропорции
-	if width > 0 && width < img.Width() {
-		scale := float64(width) / float64(img.Width())
-		if err := img.Resize(scale, vips.KernelLanczos3); err != nil {
-			return nil, fmt.Errorf("resize: %w", err)
+	// Сохранённая правка превращает оригинал в то фото, которое видит
+	// пользователь; её цветовые коррекции выполняются вместе с остальными
+	edit := opts.Edit
+	if edit == nil {
+		edit = &transform.Edit{}
+	}
+	if err := crop(img, edit.Crop); err != nil {
+		return nil, err
+	}
+	if err := orient(img, edit.Adjust); err != nil {
+		return nil, err
+	}
+
+	// Явная обрезка выполняется по координатам фото, до масштабирования
+	if err := crop(img, opts.Crop); err != nil {
+		return nil, err
+	}
+	if err := orient(img, opts.Adjust); err != nil {
+		return nil, err
+	}
+	if err := resize(img, opts, p.limits.MaxPixels); err != nil {
+		return nil, err
+	}
+
+	// Профиль конвертируется после уменьшения – так дешевле; коррекции цвета
+	// выполняются уже в пространстве результата
+	keepProfile, err := convertColor(img, opts)
+	if err != nil {
+		return nil, err
+	}
+	if err := adjust(img, edit.Adjust); err != nil {
+		return nil, err
+	}
+	if err := adjust(img, opts.Adjust); err != nil {
+		return nil, err
+	}
+	// ч/б изображение не может нести RGB-профиль
+	keepProfile = keepProfile && !opts.Adjust.Grayscale && !edit.Adjust.Grayscale
+	if opts.Watermark != nil {
+		if err := applyWatermark(img, opts.Watermark, watermark); err != nil {
+			return nil, err
 		}
 	}
+	// EXIF, XMP и прочие метаданные не нужны в вариантах; встроенный профиль
+	// Display P3 сохраняется, остальное удаляется при экспорте целиком
+	// (jxlsave не умеет strip, поэтому метаданные снимаются заранее)
+	if err := img.RemoveMetadata(); err != nil {
+		return nil, fmt.Errorf("remove metadata: %w", err)
+	}
+	strip := !keepProfile
 
 	// Экспорт в целевой формат
+	var out []byte
+	quality, enc := opts.Quality, opts.Encoding.For(opts.Format)
+	if quality == transform.QualityAuto {
+		out, quality, err = p.exportAuto(img, opts.Format, enc, strip)
+	} else {
+		out, err = export(img, opts.Format, quality, enc, strip)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("export %s: %w", opts.Format, err)
+	}
+	if opts.Format == transform.FormatPNG || (enc.Lossless && !enc.NearLossless) {
+		quality = 0
+	}
+	return &Result{Data: out, Width: img.Width(), Height: img.PageHeight(), SourceWidth: srcW, SourceHeight: srcH, Quality: quality}, nil
+}
+
+// Усилие кодировщиков, если оно не задано в transform.Encoding
+// (умолчания libvips; для jxl – 7, как у cjxl).
+const (
+	defaultWebpEffort = 4
+	defaultAvifEffort = 5
+	defaultJxlEffort  = 7
+)
+
+// export кодирует изображение в format с качеством quality и настройками
+// кодировщика enc (результат transform.Encoding.For(format)).
+// strip удаляет из результата все метаданные, включая профиль.
+func export(img *vips.ImageRef, format string, quality int, enc transform.Encoding, strip bool) ([]byte, error) {
+	var out []byte
+	var err error
 	switch format {
 	case "webp":
-		ep := vips.WebpExportParams{Quality: quality}
-		result, _, err := img.ExportWebp(&ep)
-		return result, err
+		ep := vips.WebpExportParams{
+			Quality:         quality,
+			Lossless:        enc.Lossless,
+			NearLossless:    enc.NearLossless,
+			ReductionEffort: cmp.Or(enc.Effort, defaultWebpEffort),
+			StripMetadata:   strip,
+		}
+		out, _, err = img.ExportWebp(&ep)
 	case "avif":
-		ep := vips.AvifExportParams{Quality: quality}
-		result, _, err := img.ExportAvif(&ep)
-		return result, err
+		// libvips применяет глубину и effort только вместе, поэтому задаются оба
+		ep := vips.AvifExportParams{
+			Quality:       quality,
+			Lossless:      enc.Lossless,
+			Effort:        cmp.Or(enc.Effort, defaultAvifEffort),
+			Bitdepth:      cmp.Or(enc.BitDepth, 8),
+			StripMetadata: strip,
+		}
+		out, _, err = img.ExportAvif(&ep)
+	case "jxl":
+		ep := vips.JxlExportParams{Distance: jxlDistance(quality), Lossless: enc.Lossless, Effort: cmp.Or(enc.Effort, defaultJxlEffort)}
+		out, _, err = img.ExportJxl(&ep)
+	case "png":
+		// png без потерь – качество не используется
+		ep := vips.PngExportParams{Compression: 6, Interlace: enc.Progressive, StripMetadata: strip}
+		out, _, err = img.ExportPng(&ep)
+	case "gif":
+		ep := vips.GifExportParams{Quality: quality, Effort: 7, Bitdepth: 8, StripMetadata: true}
+		out, _, err = img.ExportGIF(&ep)
 	default: // jpeg
-		ep := vips.JpegExportParams{Quality: quality, StripMetadata: true}
-		result, _, err := img.ExportJpeg(&ep)
-		return result, err
+		ep := vips.JpegExportParams{
+			Quality:            quality,
+			Interlace:          enc.Progressive,
+			OptimizeCoding:     true,
+			SubsampleMode:      subsampleMode(enc.Subsample),
+			TrellisQuant:       enc.Trellis,
+			OvershootDeringing: enc.Trellis,
+			OptimizeScans:      enc.Trellis && enc.Progressive,
+			QuantTable:         enc.QuantTable,
+			StripMetadata:      strip,
+		}
+		out, _, err = img.ExportJpeg(&ep)
+	}
+	return out, err
+}
+
+// subsampleMode переводит субдискретизацию цветности в режим libvips.
+func subsampleMode(s transform.Subsample) vips.SubsampleMode {
+	switch s {
+	case transform.Subsample420:
+		return vips.VipsForeignSubsampleOn
+	case transform.Subsample444:
+		return vips.VipsForeignSubsampleOff
+	}
+	return vips.VipsForeignSubsampleAuto
+}
+
+// Analyze определяет размеры, число кадров и длительность анимации источника
+// и возвращает в Data маленькое превью первого кадра в png, вписанное в
+// previewSize×previewSize с сохранением пропорций и прозрачности, с учётом
+// правки edit (nil – без правки). Палитра (см. Palette) считается по превью,
+// перцептивный хэш – по оригиналу (см. Fingerprint) и только при fingerprint:
+// от правки он не зависит, поэтому при повторном анализе не пересчитывается.
+func (p *Processor) Analyze(data []byte, previewSize int, edit *transform.Edit, fingerprint bool) (*Result, error) {
+	img, err := p.load(data, true, 0)
+	if err != nil {
+		if errors.Is(err, ErrSourceTooLarge) {
+			return nil, err
+		}
+		return nil, fmt.Errorf("decode image: %w", err)
+	}
+	frames := img.Height() / img.PageHeight()
+	duration := 0
+	if frames > 1 {
+		delays, err := img.PageDelay()
+		if err != nil {
+			log.Printf("[vips] failed to read frame delays: %v", err)
+		}
+		for _, d := range delays {
+			duration += d
+		}
+	}
+	img.Close()
+
+	result, err := p.Transform(data, transform.Options{
+		Width:  previewSize,
+		Height: previewSize,
+		Fit:    transform.FitInside,
+		Format: transform.FormatPNG,
+		Frame:  1,
+		Edit:   edit,
+	}, nil)
+	if err != nil {
+		return nil, err
+	}
+	preview, err := png.Decode(bytes.NewReader(result.Data))
+	if err != nil {
+		return nil, fmt.Errorf("decode preview: %w", err)
+	}
+	result.SourceFrames, result.SourceDurationMs = frames, duration
+	result.Palette = Palette(preview, PaletteSize)
+	if fingerprint {
+		hash, err := p.Fingerprint(data)
+		if err != nil {
+			return nil, err
+		}
+		result.PHash = &hash
+	}
+	return result, nil
+}
+
+// Fingerprint возвращает перцептивный хэш первого кадра изображения (см.
+// пакет phash). Хэш считается по оригиналу без правок, чтобы находить
+// повторные загрузки того же снимка: изображение сжимается до
+// phash.Size×phash.Size без сохранения пропорций.
+func (p *Processor) Fingerprint(data []byte) (phash.Hash, error) {
+	result, err := p.Transform(data, transform.Options{
+		Width:  phash.Size,
+		Height: phash.Size,
+		Fit:    transform.FitFill,
+		Format: transform.FormatPNG,
+		Frame:  1,
+	}, nil)
+	if err != nil {
+		return 0, err
+	}
+	img, err := png.Decode(bytes.NewReader(result.Data))
+	if err != nil {
+		return 0, fmt.Errorf("decode fingerprint preview: %w", err)
+	}
+	return phash.FromImage(img)
+}
+
+// Встроенные профили libvips, используемые при конвертации цвета.
+const (
+	profileSRGB = "srgb"
+	profileP3   = "p3"
+	profileCMYK = "cmyk"
+)
+
+// convertColor переводит изображение в цветовое пространство opts.Color по
+// встроенному профилю (CMYK без профиля – по стандартному CMYK-профилю).
+// Возвращает true, если профиль результата нужно встроить (Display P3).
+// Изображения без профиля уже считаются sRGB и не трогаются.
+func convertColor(img *vips.ImageRef, opts transform.Options) (bool, error) {
+	cmyk := img.Interpretation() == vips.InterpretationCMYK
+	if !img.HasICCProfile() && !cmyk {
+		return false, nil
+	}
+	fallback := profileSRGB
+	if cmyk {
+		fallback = profileCMYK
+	}
+	wide := opts.Color == transform.ColorP3 && img.HasICCProfile() && opts.Format != transform.FormatGIF
+	target := profileSRGB
+	if wide {
+		target = profileP3
+	}
+	if err := img.TransformICCProfileWithFallback(target, fallback); err != nil {
+		return false, fmt.Errorf("icc transform to %s: %w", target, err)
+	}
+	return wide, nil
+}
+
+// load открывает изображение. У анимированных форматов (GIF, WebP) при
+// allFrames загружаются все кадры одной «лентой» высотой frames×PageHeight,
+// иначе – только кадр frame (с 0). Остальные форматы загружаются как есть.
+// libvips декодирует пиксели лениво, поэтому лимиты проверяются по заголовку
+// до того, как под изображение будет выделена память. Статичное изображение
+// поворачивается по EXIF Orientation, поэтому размеры и координаты обрезки
+// дальше относятся к тому, как фото видит пользователь.
+func (p *Processor) load(data []byte, allFrames bool, frame int) (*vips.ImageRef, error) {
+	params := vips.NewImportParams()
+	switch vips.DetermineImageType(data) {
+	case vips.ImageTypeGIF, vips.ImageTypeWEBP:
+		if allFrames {
+			params.NumPages.Set(-1)
+		} else if frame > 0 {
+			params.Page.Set(frame)
+		}
+	}
+	img, err := vips.LoadImageFromBuffer(data, params)
+	if err != nil {
+		return nil, err
+	}
+	w, h, frameH := img.Width(), img.Height(), img.PageHeight()
+	if (p.limits.MaxDimension > 0 && (w > p.limits.MaxDimension || frameH > p.limits.MaxDimension)) ||
+		(p.limits.MaxPixels > 0 && w*h > p.limits.MaxPixels) {
+		img.Close()
+		return nil, fmt.Errorf("%w: %dx%d, %d frame(s)", ErrSourceTooLarge, w, frameH, h/frameH)
+	}
+	if h == frameH && img.Orientation() > 1 {
+		if err := img.AutoRotate(); err != nil {
+			img.Close()
+			return nil, fmt.Errorf("auto-rotate: %w", err)
+		}
+	}
+	return img, nil
+}
+
+// jxlDistance переводит качество 1..100 в дистанцию Butteraugli для JPEG XL
+// (та же формула, что использует libvips для параметра Q).
+func jxlDistance(quality int) float64 {
+	q := float64(quality)
+	if q >= 30 {
+		return 0.1 + (100-q)*0.09
+	}
+	return 53.0/3000.0*q*q - 23.0/20.0*q + 25.0
+}
+
+// resize масштабирует изображение согласно width/height и fit.
+// Если задан только один размер, пропорции сохраняются и увеличение не выполняется.
+// maxPixels ограничивает площадь промежуточного изображения для cover (0 – без ограничения).
+func resize(img *vips.ImageRef, opts transform.Options, maxPixels int) error {
+	srcW, srcH := float64(img.Width()), float64(img.PageHeight())
+	w, h := opts.Width, opts.Height
+
+	switch {
+	case w > 0 && h <= 0:
+		if w < img.Width() {
+			return scale(img, float64(w)/srcW)
+		}
+		return nil
+	case h > 0 && w <= 0:
+		if h < img.PageHeight() {
+			return scale(img, float64(h)/srcH)
+		}
+		return nil
+	case w <= 0 && h <= 0:
+		return nil
+	}
+
+	sx, sy := float64(w)/srcW, float64(h)/srcH
+	switch opts.Fit {
+	case transform.FitFill:
+		if err := img.ResizeWithVScale(sx, sy, vips.KernelLanczos3); err != nil {
+			return fmt.Errorf("resize: %w", err)
+		}
+		return nil
+	case transform.FitInside:
+		if s := math.Min(sx, sy); s < 1 {
+			return scale(img, s)
+		}
+		return nil
+	case transform.FitOutside:
+		if s := math.Max(sx, sy); s < 1 {
+			return scale(img, s)
+		}
+		return nil
+	case transform.FitContain:
+		if err := scale(img, math.Min(sx, sy)); err != nil {
+			return err
+		}
+		ax, ay := opts.Gravity.Anchor()
+		left := int(math.Round(float64(w-img.Width()) * ax))
+		top := int(math.Round(float64(h-img.PageHeight()) * ay))
+		// прозрачные поля заливаются цветом фона позже, вместе с прозрачностью изображения
+		bg := vips.Color{R: 255, G: 255, B: 255}
+		if opts.Adjust.Background != "" {
+			c, _ := transform.ParseColor(opts.Adjust.Background)
+			bg = vips.Color{R: c.R, G: c.G, B: c.B}
+		}
+		var err error
+		if img.HasAlpha() {
+			err = img.EmbedBackgroundRGBA(left, top, w, h, &vips.ColorRGBA{R: 0, G: 0, B: 0, A: 0})
+		} else {
+			err = img.EmbedBackground(left, top, w, h, &bg)
+		}
+		if err != nil {
+			return fmt.Errorf("embed: %w", err)
+		}
+		return nil
+	default: // cover
+		// промежуточное изображение cover может быть намного больше рамки
+		// (узкая рамка поперёк длинной стороны), поэтому его площадь
+		// ограничена тем же лимитом, что и исходник
+		s := math.Max(sx, sy)
+		pages := img.Height() / img.PageHeight()
+		iw, ih := int(math.Round(srcW*s)), int(math.Round(srcH*s))
+		if maxPixels > 0 && iw*ih*pages > maxPixels {
+			return fmt.Errorf("%w: cover %dx%d needs a %dx%d intermediate image", transform.ErrInvalidOptions, w, h, iw, ih)
+		}
+		if err := scale(img, s); err != nil {
+			return err
+		}
+		// после округления при ресайзе изображение может оказаться на пиксель меньше рамки
+		w, h = min(w, img.Width()), min(h, img.PageHeight())
+		// smartcrop libvips не умеет работать с кадрами анимации – для неё обрезка по центру
+		if img.Height() == img.PageHeight() {
+			switch opts.Gravity {
+			case transform.GravitySmart:
+				return smartCrop(img, w, h, vips.InterestingAttention)
+			case transform.GravityEntropy:
+				return smartCrop(img, w, h, vips.InterestingEntropy)
+			}
+		}
+		ax, ay := opts.Gravity.Anchor()
+		left := int(math.Round(float64(img.Width()-w) * ax))
+		top := int(math.Round(float64(img.PageHeight()-h) * ay))
+		if err := img.ExtractArea(left, top, w, h); err != nil {
+			return fmt.Errorf("crop: %w", err)
+		}
+		return nil
+	}
+}
+
+// crop вырезает прямоугольник c (nil – без обрезки), проверяя, что он
+// помещается в изображение. У анимации область вырезается из каждого кадра:
+// ExtractArea учитывает высоту страницы.
+func crop(img *vips.ImageRef, c *transform.Crop) error {
+	if c == nil {
+		return nil
+	}
+	w, h := img.Width(), img.PageHeight()
+	if c.X+c.Width > w || c.Y+c.Height > h {
+		return fmt.Errorf("%w: crop %dx%d+%d+%d is outside of %dx%d image",
+			transform.ErrInvalidOptions, c.Width, c.Height, c.X, c.Y, w, h)
+	}
+	if err := img.ExtractArea(c.X, c.Y, c.Width, c.Height); err != nil {
+		return fmt.Errorf("crop: %w", err)
+	}
+	return nil
+}
+
+// orient отражает и поворачивает изображение (сначала отражения, затем поворот).
+// У анимации кадры уложены в «ленту» по вертикали, поэтому вертикальное
+// отражение и поворот на 180° сделали бы обратным порядок кадров – они
+// выражаются через горизонтальное отражение и повороты на 90°, которые
+// libvips выполняет для каждого кадра отдельно.
+func orient(img *vips.ImageRef, a transform.Adjustments) error {
+	animated := img.Height() > img.PageHeight()
+	rotate := a.Rotate
+	if a.FlipV {
+		if animated {
+			// отражение сверху вниз = отражение слева направо + поворот на 180°
+			if err := img.Flip(vips.DirectionHorizontal); err != nil {
+				return fmt.Errorf("flip: %w", err)
+			}
+			rotate = (rotate + 180) % 360
+		} else if err := img.Flip(vips.DirectionVertical); err != nil {
+			return fmt.Errorf("flip: %w", err)
+		}
+	}
+	if a.FlipH {
+		if err := img.Flip(vips.DirectionHorizontal); err != nil {
+			return fmt.Errorf("flip: %w", err)
+		}
+	}
+	var angles []vips.Angle
+	switch rotate {
+	case 90:
+		angles = []vips.Angle{vips.Angle90}
+	case 180:
+		angles = []vips.Angle{vips.Angle180}
+		if animated {
+			angles = []vips.Angle{vips.Angle90, vips.Angle90}
+		}
+	case 270:
+		angles = []vips.Angle{vips.Angle270}
+	}
+	for _, angle := range angles {
+		if err := img.Rotate(angle); err != nil {
+			return fmt.Errorf("rotate: %w", err)
+		}
+	}
+	return nil
+}
+
+// adjust применяет цветовые коррекции и фильтры в порядке, описанном у
+// transform.Adjustments.
+func adjust(img *vips.ImageRef, a transform.Adjustments) error {
+	if a.Grayscale {
+		if err := img.ToColorSpace(vips.InterpretationBW); err != nil {
+			return fmt.Errorf("grayscale: %w", err)
+		}
+		if err := img.RemoveICCProfile(); err != nil {
+			return fmt.Errorf("grayscale: %w", err)
+		}
+	}
+	if a.Brightness != 0 || a.Saturation != 0 {
+		// яркость и насыщенность – множители L и C в пространстве LCh
+		bri, sat := 1+float64(a.Brightness)/100, 1+float64(a.Saturation)/100
+		if err := img.Modulate(bri, sat, 0); err != nil {
+			return fmt.Errorf("modulate: %w", err)
+		}
+	}
+	if a.Contrast != 0 {
+		if err := contrast(img, 1+float64(a.Contrast)/100); err != nil {
+			return err
+		}
+	}
+	if a.Blur > 0 {
+		if err := img.GaussianBlur(a.Blur); err != nil {
+			return fmt.Errorf("blur: %w", err)
+		}
+	}
+	if a.Sharpen > 0 {
+		// пороги по умолчанию libvips: плоские области не трогаются
+		if err := img.Sharpen(a.Sharpen, 2, 3); err != nil {
+			return fmt.Errorf("sharpen: %w", err)
+		}
+	}
+	if a.Background != "" && img.HasAlpha() {
+		c, err := transform.ParseColor(a.Background)
+		if err != nil {
+			return err
+		}
+		if err := img.Flatten(&vips.Color{R: c.R, G: c.G, B: c.B}); err != nil {
+			return fmt.Errorf("flatten: %w", err)
+		}
+	}
+	return nil
+}
+
+// contrast растягивает (factor > 1) или сжимает значения цветовых каналов
+// относительно середины диапазона; альфа-канал не меняется.
+func contrast(img *vips.ImageRef, factor float64) error {
+	mid, format := 128.0, vips.BandFormatUchar
+	if img.BandFormat() == vips.BandFormatUshort {
+		mid, format = 32768.0, vips.BandFormatUshort
+	}
+	bands := img.Bands()
+	a, b := make([]float64, bands), make([]float64, bands)
+	for i := range a {
+		a[i], b[i] = factor, mid*(1-factor)
+	}
+	if img.HasAlpha() {
+		a[bands-1], b[bands-1] = 1, 0
+	}
+	if err := img.Linear(a, b); err != nil {
+		return fmt.Errorf("contrast: %w", err)
+	}
+	// linear возвращает float – обратно в целые с отсечением по диапазону
+	if err := img.Cast(format); err != nil {
+		return fmt.Errorf("contrast: %w", err)
+	}
+	return nil
+}
+
+func scale(img *vips.ImageRef, s float64) error {
+	if s == 1 {
+		return nil
+	}
+	if err := img.Resize(s, vips.KernelLanczos3); err != nil {
+		return fmt.Errorf("resize: %w", err)
+	}
+	return nil
+}
+
+func smartCrop(img *vips.ImageRef, w, h int, interesting vips.Interesting) error {
+	if err := img.SmartCrop(w, h, interesting); err != nil {
+		return fmt.Errorf("smart crop: %w", err)
 	}
+	return nil
 }
 
 // Shutdown завершает работу libvips
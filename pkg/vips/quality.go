@@ -0,0 +1,157 @@
+package vips
+
+import (
+	"fmt"
+
+	vips "github.com/davidbyttow/govips/v2/vips"
+
+	"github.com/freshtea599/PhotoHubServer.git/pkg/transform"
+)
+
+// Границы поиска качества для q=auto: ниже autoQualityMin артефакты заметны
+// на любом содержимом, выше autoQualityMax файл растёт почти без пользы.
+const (
+	autoQualityMin = 30
+	autoQualityMax = 95
+)
+
+// exportAuto кодирует изображение с наименьшим качеством, при котором SSIM
+// яркости декодированного результата относительно несжатого изображения не
+// ниже p.qualityTarget. Качество ищется двоичным поиском – около шести
+// кодирований. Если цель недостижима, используется autoQualityMax. png и gif
+// кодируются без поиска: png без потерь, а у gif качество задаёт палитру;
+// так же – lossless-режим остальных форматов.
+func (p *Processor) exportAuto(img *vips.ImageRef, format string, enc transform.Encoding, strip bool) ([]byte, int, error) {
+	if format == transform.FormatPNG || format == transform.FormatGIF || (enc.Lossless && !enc.NearLossless) {
+		out, err := export(img, format, autoQualityMax, enc, strip)
+		return out, autoQualityMax, err
+	}
+	ref, err := luma(img)
+	if err != nil {
+		return nil, 0, err
+	}
+	animated := img.Height() > img.PageHeight()
+
+	var best []byte
+	bestQ := autoQualityMax
+	lo, hi := autoQualityMin, autoQualityMax
+	for lo <= hi {
+		q := (lo + hi) / 2
+		out, err := export(img, format, q, enc, strip)
+		if err != nil {
+			return nil, 0, err
+		}
+		score, err := compare(ref, out, img.Width(), img.Height(), animated)
+		if err != nil {
+			return nil, 0, err
+		}
+		if score >= p.qualityTarget {
+			best, bestQ = out, q
+			hi = q - 1
+		} else {
+			lo = q + 1
+		}
+	}
+	if best == nil {
+		out, err := export(img, format, autoQualityMax, enc, strip)
+		return out, autoQualityMax, err
+	}
+	return best, bestQ, nil
+}
+
+// compare декодирует закодированный вариант и возвращает SSIM его яркости
+// относительно эталонной яркости ref размером width×height.
+func compare(ref, encoded []byte, width, height int, animated bool) (float64, error) {
+	params := vips.NewImportParams()
+	if animated {
+		params.NumPages.Set(-1)
+	}
+	img, err := vips.LoadImageFromBuffer(encoded, params)
+	if err != nil {
+		return 0, fmt.Errorf("auto quality decode: %w", err)
+	}
+	defer img.Close()
+	if img.Width() != width || img.Height() != height {
+		return 0, fmt.Errorf("auto quality: decoded %dx%d, want %dx%d", img.Width(), img.Height(), width, height)
+	}
+	got, err := luma(img)
+	if err != nil {
+		return 0, err
+	}
+	return ssim(ref, got, width, height), nil
+}
+
+// luma возвращает яркость изображения – по байту на пиксель. Само
+// изображение не меняется.
+func luma(img *vips.ImageRef) ([]byte, error) {
+	c, err := img.Copy()
+	if err != nil {
+		return nil, fmt.Errorf("auto quality: %w", err)
+	}
+	defer c.Close()
+	if c.Interpretation() != vips.InterpretationBW && c.Interpretation() != vips.InterpretationGrey16 {
+		if err := c.ToColorSpace(vips.InterpretationBW); err != nil {
+			return nil, fmt.Errorf("auto quality luma: %w", err)
+		}
+	}
+	if c.Bands() > 1 {
+		if err := c.ExtractBand(0, 1); err != nil {
+			return nil, fmt.Errorf("auto quality luma: %w", err)
+		}
+	}
+	if c.BandFormat() == vips.BandFormatUshort {
+		if err := c.Linear([]float64{1.0 / 256}, []float64{0}); err != nil {
+			return nil, fmt.Errorf("auto quality luma: %w", err)
+		}
+	}
+	if c.BandFormat() != vips.BandFormatUchar {
+		if err := c.Cast(vips.BandFormatUchar); err != nil {
+			return nil, fmt.Errorf("auto quality luma: %w", err)
+		}
+	}
+	return c.ToBytes()
+}
+
+// ssimWindow – сторона окна, по которому считается локальный SSIM.
+const ssimWindow = 8
+
+// ssim возвращает средний по окнам 8×8 индекс структурного сходства двух
+// яркостных плоскостей width×height (1 – изображения совпадают).
+// Окна идут с шагом в половину окна; неполные окна у краёв не учитываются,
+// а изображение меньше окна сравнивается целиком.
+func ssim(a, b []byte, width, height int) float64 {
+	const (
+		c1 = (0.01 * 255) * (0.01 * 255)
+		c2 = (0.03 * 255) * (0.03 * 255)
+	)
+	win := min(ssimWindow, width, height)
+	step := max(win/2, 1)
+	var sum float64
+	var n int
+	for y := 0; y+win <= height; y += step {
+		for x := 0; x+win <= width; x += step {
+			var sa, sb, saa, sbb, sab float64
+			for j := y; j < y+win; j++ {
+				row := j * width
+				for i := x; i < x+win; i++ {
+					va, vb := float64(a[row+i]), float64(b[row+i])
+					sa += va
+					sb += vb
+					saa += va * va
+					sbb += vb * vb
+					sab += va * vb
+				}
+			}
+			k := float64(win * win)
+			ma, mb := sa/k, sb/k
+			va, vb := saa/k-ma*ma, sbb/k-mb*mb
+			cov := sab/k - ma*mb
+			sum += ((2*ma*mb + c1) * (2*cov + c2)) / ((ma*ma + mb*mb + c1) * (va + vb + c2))
+			n++
+		}
+	}
+	if n == 0 {
+		return 1
+	}
+	return sum / float64(n)
+}
@@ -0,0 +1,164 @@
+package vips
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+	"math"
+	"sync"
+
+	vips "github.com/davidbyttow/govips/v2/vips"
+	"golang.org/x/image/font"
+	"golang.org/x/image/font/gofont/gobold"
+	"golang.org/x/image/font/opentype"
+	"golang.org/x/image/math/fixed"
+
+	"github.com/freshtea599/PhotoHubServer.git/pkg/transform"
+)
+
+// watermarkMargin – отступ знака от края в долях ширины результата.
+const watermarkMargin = 0.02
+
+// applyWatermark накладывает знак на каждый кадр изображения. Текст
+// рисуется белым с тенью, изображение знака – с его прозрачностью;
+// и то и другое масштабируется до wm.Scale от ширины результата.
+func applyWatermark(img *vips.ImageRef, wm *transform.Watermark, data []byte) error {
+	width, pageH := img.Width(), img.PageHeight()
+	boxW := max(int(math.Round(float64(width)*wm.Scale)), 1)
+
+	var err error
+	if wm.Text != "" {
+		// текст не выше трети кадра, даже если он короткий
+		data, err = renderText(wm.Text, boxW, max(pageH/3, 1))
+		if err != nil {
+			return fmt.Errorf("watermark text: %w", err)
+		}
+	} else if len(data) == 0 {
+		return errors.New("watermark image is missing")
+	}
+	mark, err := vips.NewImageFromBuffer(data)
+	if err != nil {
+		return fmt.Errorf("watermark image: %w", err)
+	}
+	defer mark.Close()
+
+	if wm.Text == "" {
+		s := math.Min(float64(boxW)/float64(mark.Width()), float64(pageH)/float64(mark.PageHeight()))
+		if err := mark.Resize(s, vips.KernelLanczos3); err != nil {
+			return fmt.Errorf("watermark resize: %w", err)
+		}
+	}
+	if err := prepareOverlay(mark, wm.Opacity); err != nil {
+		return err
+	}
+
+	margin := int(math.Round(float64(width) * watermarkMargin))
+	ax, ay := wm.Gravity.Anchor()
+	left := margin + int(math.Round(float64(width-mark.Width()-2*margin)*ax))
+	top := margin + int(math.Round(float64(pageH-mark.Height()-2*margin)*ay))
+	left, top = max(left, 0), max(top, 0)
+
+	// у анимации знак ставится на каждый кадр «ленты»
+	hadAlpha := img.HasAlpha()
+	var layers []*vips.ImageComposite
+	for y := top; y < img.Height(); y += pageH {
+		layers = append(layers, &vips.ImageComposite{Image: mark, BlendMode: vips.BlendModeOver, X: left, Y: y})
+	}
+	if err := img.CompositeMulti(layers); err != nil {
+		return fmt.Errorf("watermark composite: %w", err)
+	}
+	// composite всегда добавляет альфа-канал – непрозрачному результату он не нужен
+	if !hadAlpha && img.HasAlpha() {
+		if err := img.ExtractBand(0, img.Bands()-1); err != nil {
+			return fmt.Errorf("watermark composite: %w", err)
+		}
+	}
+	return nil
+}
+
+// prepareOverlay приводит знак к sRGB с альфа-каналом и умножает
+// прозрачность на opacity.
+func prepareOverlay(mark *vips.ImageRef, opacity float64) error {
+	if mark.Interpretation() != vips.InterpretationSRGB {
+		if err := mark.ToColorSpace(vips.InterpretationSRGB); err != nil {
+			return fmt.Errorf("watermark colorspace: %w", err)
+		}
+	}
+	if err := mark.AddAlpha(); err != nil {
+		return fmt.Errorf("watermark alpha: %w", err)
+	}
+	if opacity < 1 {
+		if err := mark.Linear([]float64{1, 1, 1, opacity}, []float64{0, 0, 0, 0}); err != nil {
+			return fmt.Errorf("watermark opacity: %w", err)
+		}
+		if err := mark.Cast(vips.BandFormatUchar); err != nil {
+			return fmt.Errorf("watermark opacity: %w", err)
+		}
+	}
+	return nil
+}
+
+var (
+	textFontOnce sync.Once
+	textFont     *opentype.Font
+	textFontErr  error
+)
+
+// renderText рисует текст знака в PNG шириной не больше width и высотой
+// не больше maxHeight: белые буквы с полупрозрачной тенью читаются и на
+// светлом, и на тёмном фоне.
+func renderText(text string, width, maxHeight int) ([]byte, error) {
+	textFontOnce.Do(func() {
+		textFont, textFontErr = opentype.Parse(gobold.TTF)
+	})
+	if textFontErr != nil {
+		return nil, textFontErr
+	}
+
+	// размеры на кегле 100 пропорциональны кеглю – по ним подбирается нужный
+	const probeSize = 100
+	probe, err := opentype.NewFace(textFont, &opentype.FaceOptions{Size: probeSize, DPI: 72})
+	if err != nil {
+		return nil, err
+	}
+	advance := font.MeasureString(probe, text).Ceil()
+	metrics := probe.Metrics()
+	lineHeight := (metrics.Ascent + metrics.Descent).Ceil()
+	probe.Close()
+	if advance <= 0 || lineHeight <= 0 {
+		return nil, errors.New("text has no visible glyphs")
+	}
+	size := probeSize * math.Min(float64(width)/float64(advance), float64(maxHeight)/float64(lineHeight))
+	size = math.Max(size, 1)
+
+	face, err := opentype.NewFace(textFont, &opentype.FaceOptions{Size: size, DPI: 72})
+	if err != nil {
+		return nil, err
+	}
+	defer face.Close()
+	metrics = face.Metrics()
+	ascent := metrics.Ascent.Ceil()
+	shadow := max(int(size/24), 1)
+	w := font.MeasureString(face, text).Ceil() + shadow
+	h := ascent + metrics.Descent.Ceil() + shadow
+
+	canvas := image.NewNRGBA(image.Rect(0, 0, w, h))
+	d := &font.Drawer{
+		Dst:  canvas,
+		Src:  image.NewUniform(color.NRGBA{A: 160}),
+		Face: face,
+		Dot:  fixed.P(shadow, ascent+shadow),
+	}
+	d.DrawString(text)
+	d.Src, d.Dot = image.White, fixed.P(0, ascent)
+	d.DrawString(text)
+
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, canvas); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
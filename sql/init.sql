@@ -2,6 +2,9 @@
 -- 1. Таблицы
 -- =====================================================
 
+-- cube – точки CIELAB с GiST-индексом для поиска по цвету (photo_colors)
+CREATE EXTENSION IF NOT EXISTS cube WITH SCHEMA public;
+
 CREATE TABLE public.users (
     id integer NOT NULL,
     email character varying(255) NOT NULL,
@@ -26,11 +29,23 @@ CREATE TABLE public.photos (
     description text,
     is_public boolean DEFAULT false,
     blurhash text,
+    thumbhash text,
+    dominant_color character varying(7),
+    palette text[],
+    phash bigint,
     content_hash text,
     width integer,
     height integer,
+    display_width integer,
+    display_height integer,
+    frame_count integer DEFAULT 1 NOT NULL,
+    duration_ms integer DEFAULT 0 NOT NULL,
     likes_count integer DEFAULT 0,
     comments_count integer DEFAULT 0,
+    views_count bigint DEFAULT 0,
+    processing_status character varying(20) DEFAULT 'pending'::character varying NOT NULL,
+    edit jsonb,
+    edit_version integer DEFAULT 0 NOT NULL,
     created_at timestamp without time zone DEFAULT CURRENT_TIMESTAMP,
     updated_at timestamp without time zone DEFAULT CURRENT_TIMESTAMP
 );
@@ -42,7 +57,7 @@ ALTER TABLE ONLY public.photos ALTER COLUMN id SET DEFAULT nextval('public.photo
 CREATE TABLE public.photo_variants (
     id integer NOT NULL,
     photo_id integer NOT NULL,
-    size_name character varying(50) NOT NULL,
+    size_name character varying(255) NOT NULL,
     format character varying(10) NOT NULL,
     file_path character varying(500) NOT NULL,
     file_size bigint,
@@ -107,6 +122,96 @@ CREATE SEQUENCE public.comment_reports_id_seq AS integer START WITH 1 INCREMENT
 ALTER SEQUENCE public.comment_reports_id_seq OWNED BY public.comment_reports.id;
 ALTER TABLE ONLY public.comment_reports ALTER COLUMN id SET DEFAULT nextval('public.comment_reports_id_seq'::regclass);
 
+-- история правок фото: каждая версия хранит правку целиком (NULL – без правок),
+-- текущая копируется в photos.edit / photos.edit_version
+CREATE TABLE public.photo_edits (
+    id integer NOT NULL,
+    photo_id integer NOT NULL,
+    version integer NOT NULL,
+    edit jsonb,
+    created_at timestamp without time zone DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE SEQUENCE public.photo_edits_id_seq AS integer START WITH 1 INCREMENT BY 1 NO MINVALUE NO MAXVALUE CACHE 1;
+ALTER SEQUENCE public.photo_edits_id_seq OWNED BY public.photo_edits.id;
+ALTER TABLE ONLY public.photo_edits ALTER COLUMN id SET DEFAULT nextval('public.photo_edits_id_seq'::regclass);
+
+-- качество, подобранное для q=auto: одно на фото и формат
+CREATE TABLE public.photo_auto_quality (
+    photo_id integer NOT NULL,
+    format character varying(10) NOT NULL,
+    quality integer NOT NULL,
+    created_at timestamp without time zone DEFAULT CURRENT_TIMESTAMP
+);
+
+-- палитра фото для поиска по цвету: строка на цвет, rank 0 – доминирующий,
+-- lab – точка (L, a, b) в CIELAB
+CREATE TABLE public.photo_colors (
+    photo_id integer NOT NULL,
+    rank smallint NOT NULL,
+    color character varying(7) NOT NULL,
+    lab public.cube NOT NULL
+);
+
+-- хэши отклонённых модерацией фото (сами фото удаляются) – для поиска
+-- повторных загрузок; phash – перцептивный хэш, NULL – не успел посчитаться
+CREATE TABLE public.rejected_photos (
+    id integer NOT NULL,
+    photo_id integer NOT NULL,
+    user_id integer NOT NULL,
+    content_hash text,
+    phash bigint,
+    rejected_at timestamp without time zone DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE SEQUENCE public.rejected_photos_id_seq AS integer START WITH 1 INCREMENT BY 1 NO MINVALUE NO MAXVALUE CACHE 1;
+ALTER SEQUENCE public.rejected_photos_id_seq OWNED BY public.rejected_photos.id;
+ALTER TABLE ONLY public.rejected_photos ALTER COLUMN id SET DEFAULT nextval('public.rejected_photos_id_seq'::regclass);
+
+-- самое похожее отклонённое фото для фото на модерации: считается при
+-- анализе фото и при отклонении, чтобы список повторов не сравнивал хэши
+-- всех фото на модерации со всеми отклонёнными
+CREATE TABLE public.reupload_matches (
+    photo_id integer NOT NULL,
+    rejected_id integer NOT NULL,
+    distance integer NOT NULL
+);
+
+-- водяные знаки: photo_id IS NULL – настройка пользователя по умолчанию,
+-- иначе – настройка конкретного фото (kind = 'none' отключает знак для него)
+CREATE TABLE public.watermarks (
+    id integer NOT NULL,
+    user_id integer NOT NULL,
+    photo_id integer,
+    kind character varying(10) NOT NULL,
+    text character varying(200),
+    image_path character varying(500),
+    position character varying(20) DEFAULT 'southeast'::character varying NOT NULL,
+    opacity real DEFAULT 0.5 NOT NULL,
+    scale real DEFAULT 0.25 NOT NULL,
+    created_at timestamp without time zone DEFAULT CURRENT_TIMESTAMP,
+    updated_at timestamp without time zone DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE SEQUENCE public.watermarks_id_seq AS integer START WITH 1 INCREMENT BY 1 NO MINVALUE NO MAXVALUE CACHE 1;
+ALTER SEQUENCE public.watermarks_id_seq OWNED BY public.watermarks.id;
+ALTER TABLE ONLY public.watermarks ALTER COLUMN id SET DEFAULT nextval('public.watermarks_id_seq'::regclass);
+
+-- дневные агрегаты просмотров (сбрасываются из Redis фоновой задачей)
+CREATE TABLE public.photo_view_daily (
+    photo_id integer NOT NULL,
+    day date NOT NULL,
+    views bigint DEFAULT 0 NOT NULL,
+    unique_viewers bigint DEFAULT 0 NOT NULL
+);
+
+CREATE TABLE public.photo_referrer_daily (
+    photo_id integer NOT NULL,
+    day date NOT NULL,
+    referrer character varying(255) NOT NULL,
+    views bigint DEFAULT 0 NOT NULL
+);
+
 -- =====================================================
 -- 2. Первичные ключи и уникальность
 -- =====================================================
@@ -122,6 +227,16 @@ ALTER TABLE ONLY public.comments ADD CONSTRAINT comments_pkey PRIMARY KEY (id);
 ALTER TABLE ONLY public.comment_likes ADD CONSTRAINT comment_likes_pkey PRIMARY KEY (id);
 ALTER TABLE ONLY public.comment_likes ADD CONSTRAINT comment_likes_comment_id_user_id_key UNIQUE (comment_id, user_id);
 ALTER TABLE ONLY public.comment_reports ADD CONSTRAINT comment_reports_pkey PRIMARY KEY (id);
+ALTER TABLE ONLY public.photo_edits ADD CONSTRAINT photo_edits_pkey PRIMARY KEY (id);
+ALTER TABLE ONLY public.photo_edits ADD CONSTRAINT photo_edits_photo_id_version_key UNIQUE (photo_id, version);
+ALTER TABLE ONLY public.photo_auto_quality ADD CONSTRAINT photo_auto_quality_pkey PRIMARY KEY (photo_id, format);
+ALTER TABLE ONLY public.photo_colors ADD CONSTRAINT photo_colors_pkey PRIMARY KEY (photo_id, rank);
+ALTER TABLE ONLY public.rejected_photos ADD CONSTRAINT rejected_photos_pkey PRIMARY KEY (id);
+ALTER TABLE ONLY public.reupload_matches ADD CONSTRAINT reupload_matches_pkey PRIMARY KEY (photo_id);
+ALTER TABLE ONLY public.watermarks ADD CONSTRAINT watermarks_pkey PRIMARY KEY (id);
+ALTER TABLE ONLY public.watermarks ADD CONSTRAINT watermarks_photo_id_key UNIQUE (photo_id);
+ALTER TABLE ONLY public.photo_view_daily ADD CONSTRAINT photo_view_daily_pkey PRIMARY KEY (photo_id, day);
+ALTER TABLE ONLY public.photo_referrer_daily ADD CONSTRAINT photo_referrer_daily_pkey PRIMARY KEY (photo_id, day, referrer);
 
 -- =====================================================
 -- 3. Индексы
@@ -133,14 +248,36 @@ CREATE INDEX idx_photos_user_id ON public.photos USING btree (user_id);
 CREATE INDEX idx_photos_user_public ON public.photos USING btree (user_id, is_public);
 CREATE INDEX idx_photos_likes_count ON public.photos USING btree (likes_count);
 CREATE INDEX idx_photos_comments_count ON public.photos USING btree (comments_count);
+-- сортировки публичной ленты popular / most_commented / trending
+CREATE INDEX idx_photos_public_popular ON public.photos USING btree (likes_count DESC, created_at DESC, id DESC) WHERE is_public = true;
+CREATE INDEX idx_photos_public_commented ON public.photos USING btree (comments_count DESC, created_at DESC, id DESC) WHERE is_public = true;
+-- фильтры ленты по автору и MIME-типу
+CREATE INDEX idx_photos_public_user_feed ON public.photos USING btree (user_id, created_at DESC, id DESC) WHERE is_public = true;
+CREATE INDEX idx_photos_public_mime_feed ON public.photos USING btree (mime_type, created_at DESC, id DESC) WHERE is_public = true;
+-- keyset-пагинация по (created_at, id)
+CREATE INDEX idx_photos_public_feed ON public.photos USING btree (created_at DESC, id DESC) WHERE is_public = true;
+CREATE INDEX idx_photos_pending_feed ON public.photos USING btree (created_at, id) WHERE is_public = false;
+CREATE INDEX idx_photos_user_feed ON public.photos USING btree (user_id, created_at DESC, id DESC);
+-- дозаполнение заглушек и перцептивных хэшей у фото, загруженных до их появления
+CREATE INDEX idx_photos_unanalyzed ON public.photos USING btree (id) WHERE thumbhash IS NULL OR phash IS NULL;
 CREATE INDEX idx_photo_variants_photo_id ON public.photo_variants USING btree (photo_id);
+-- поиск по цвету: выборка точек CIELAB внутри куба вокруг искомого цвета
+CREATE INDEX idx_photo_colors_lab ON public.photo_colors USING gist (lab);
+-- точные повторы отклонённых фото
+CREATE INDEX idx_rejected_photos_content_hash ON public.rejected_photos USING btree (content_hash);
 CREATE INDEX idx_photo_likes_photo_id ON public.photo_likes USING btree (photo_id);
 CREATE INDEX idx_photo_likes_user_id ON public.photo_likes USING btree (user_id);
+CREATE INDEX idx_photo_likes_created_at ON public.photo_likes USING btree (created_at);
 CREATE INDEX idx_comments_photo_id ON public.comments USING btree (photo_id);
 CREATE INDEX idx_comments_user_id ON public.comments USING btree (user_id);
+CREATE INDEX idx_comments_created_at ON public.comments USING btree (created_at);
+CREATE INDEX idx_comments_photo_feed ON public.comments USING btree (photo_id, created_at DESC, id DESC);
 CREATE INDEX idx_comment_likes_comment_id ON public.comment_likes USING btree (comment_id);
 CREATE INDEX idx_comment_likes_user_id ON public.comment_likes USING btree (user_id);
 CREATE INDEX idx_comment_reports_status ON public.comment_reports USING btree (status);
+-- одна настройка по умолчанию на пользователя
+CREATE UNIQUE INDEX idx_watermarks_user_default ON public.watermarks USING btree (user_id) WHERE photo_id IS NULL;
+CREATE INDEX idx_photo_view_daily_day ON public.photo_view_daily USING btree (day);
 
 -- =====================================================
 -- 4. Внешние ключи
@@ -155,4 +292,13 @@ ALTER TABLE ONLY public.comments ADD CONSTRAINT comments_user_id_fkey FOREIGN KE
 ALTER TABLE ONLY public.comment_likes ADD CONSTRAINT comment_likes_comment_id_fkey FOREIGN KEY (comment_id) REFERENCES public.comments(id) ON DELETE CASCADE;
 ALTER TABLE ONLY public.comment_likes ADD CONSTRAINT comment_likes_user_id_fkey FOREIGN KEY (user_id) REFERENCES public.users(id) ON DELETE CASCADE;
 ALTER TABLE ONLY public.comment_reports ADD CONSTRAINT comment_reports_comment_id_fkey FOREIGN KEY (comment_id) REFERENCES public.comments(id) ON DELETE CASCADE;
-ALTER TABLE ONLY public.comment_reports ADD CONSTRAINT comment_reports_reported_by_fkey FOREIGN KEY (reported_by) REFERENCES public.users(id) ON DELETE CASCADE;
\ No newline at end of file
+ALTER TABLE ONLY public.comment_reports ADD CONSTRAINT comment_reports_reported_by_fkey FOREIGN KEY (reported_by) REFERENCES public.users(id) ON DELETE CASCADE;
+ALTER TABLE ONLY public.photo_edits ADD CONSTRAINT photo_edits_photo_id_fkey FOREIGN KEY (photo_id) REFERENCES public.photos(id) ON DELETE CASCADE;
+ALTER TABLE ONLY public.photo_auto_quality ADD CONSTRAINT photo_auto_quality_photo_id_fkey FOREIGN KEY (photo_id) REFERENCES public.photos(id) ON DELETE CASCADE;
+ALTER TABLE ONLY public.photo_colors ADD CONSTRAINT photo_colors_photo_id_fkey FOREIGN KEY (photo_id) REFERENCES public.photos(id) ON DELETE CASCADE;
+ALTER TABLE ONLY public.reupload_matches ADD CONSTRAINT reupload_matches_photo_id_fkey FOREIGN KEY (photo_id) REFERENCES public.photos(id) ON DELETE CASCADE;
+ALTER TABLE ONLY public.reupload_matches ADD CONSTRAINT reupload_matches_rejected_id_fkey FOREIGN KEY (rejected_id) REFERENCES public.rejected_photos(id) ON DELETE CASCADE;
+ALTER TABLE ONLY public.watermarks ADD CONSTRAINT watermarks_user_id_fkey FOREIGN KEY (user_id) REFERENCES public.users(id) ON DELETE CASCADE;
+ALTER TABLE ONLY public.watermarks ADD CONSTRAINT watermarks_photo_id_fkey FOREIGN KEY (photo_id) REFERENCES public.photos(id) ON DELETE CASCADE;
+ALTER TABLE ONLY public.photo_view_daily ADD CONSTRAINT photo_view_daily_photo_id_fkey FOREIGN KEY (photo_id) REFERENCES public.photos(id) ON DELETE CASCADE;
+ALTER TABLE ONLY public.photo_referrer_daily ADD CONSTRAINT photo_referrer_daily_photo_id_fkey FOREIGN KEY (photo_id) REFERENCES public.photos(id) ON DELETE CASCADE;